@@ -0,0 +1,129 @@
+// Command errepl explores instances of an entity model.
+//
+//	errepl MODEL DATA
+//
+// MODEL is an entity model in the format read by package schema and DATA holds
+// entities of that model in the record format. Type help at the prompt for a
+// list of commands. Tab completes commands and the names in the model.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bobappleyard/er/instance"
+	"github.com/bobappleyard/er/l2p"
+	"github.com/bobappleyard/er/schema"
+	"golang.org/x/term"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: errepl MODEL DATA")
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	s, err := load(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		err = runTerminal(s)
+	} else {
+		err = runLines(s, os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func load(modelPath, dataPath string) (*session, error) {
+	bs, err := os.ReadFile(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	m, err := schema.Parse(bs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", modelPath, err)
+	}
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		return nil, fmt.Errorf("%s: %w", modelPath, err)
+	}
+	inst := instance.New(m)
+	bs, err = os.ReadFile(dataPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := inst.Unmarshal(bs); err != nil {
+		return nil, fmt.Errorf("%s: %w", dataPath, err)
+	}
+	return &session{inst: inst, path: dataPath}, nil
+}
+
+func runTerminal(s *session) error {
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "er> ")
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		line, pos, alts := s.complete(line, pos)
+		if alts != nil {
+			fmt.Fprintf(t, "%s\n", strings.Join(alts, "  "))
+		}
+		return line, pos, true
+	}
+	s.out = t
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !report(s, t, line) {
+			return nil
+		}
+	}
+}
+
+func runLines(s *session, in io.Reader, out io.Writer) error {
+	s.out = out
+	lines := bufio.NewScanner(in)
+	for lines.Scan() {
+		if !report(s, out, lines.Text()) {
+			return nil
+		}
+	}
+	return lines.Err()
+}
+
+// report runs a command, printing any error, and says whether to carry on.
+func report(s *session, out io.Writer, line string) bool {
+	err := s.exec(line)
+	if err == errQuit {
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(out, "error: %v\n", err)
+	}
+	return true
+}
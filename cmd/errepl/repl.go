@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/instance"
+	"github.com/bobappleyard/er/query"
+	"github.com/bobappleyard/er/rtl"
+)
+
+var errQuit = errors.New("quit")
+
+const help = `types                          list the entity types
+describe TYPE                  show a type's attributes and relationships
+select TYPE [where COND]       run a query, e.g. select c where parent.name = "A1"
+follow TYPE.REL { KEY }        show the entity related to the one with KEY
+insert TYPE { ATTRS }          add an entity
+update TYPE { ATTRS }          replace an entity with the same key
+delete TYPE { KEY }            remove an entity
+validate                       check relationships and constraints
+save [PATH]                    write the entities back to the data file
+quit                           leave
+`
+
+var commands = []string{"types", "describe", "select", "follow", "insert", "update", "delete", "validate", "save", "help", "quit"}
+
+// session runs commands against a model instance loaded from a data file.
+type session struct {
+	inst *instance.Instance
+	path string
+	out  io.Writer
+}
+
+func (s *session) exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+	cmd := strings.Fields(line)[0]
+	args := strings.TrimSpace(line[len(cmd):])
+	switch cmd {
+	case "types":
+		for _, t := range s.inst.Model().Types {
+			fmt.Fprintln(s.out, t.Name)
+		}
+		return nil
+	case "describe":
+		t, err := s.entityType(args)
+		if err != nil {
+			return err
+		}
+		s.describe(t)
+		return nil
+	case "select":
+		q, err := query.Parse(s.inst.Model(), line)
+		if err != nil {
+			return err
+		}
+		rows, err := q.Run(s.inst.Accessors())
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			s.print(q.Type, row)
+		}
+		return nil
+	case "follow":
+		return s.follow(args)
+	case "insert", "update", "delete":
+		t, row, err := s.row(args)
+		if err != nil {
+			return err
+		}
+		a := s.inst.Accessors()[t.Name]
+		op := map[string]func(rtl.Row) error{"insert": a.Insert, "update": a.Update, "delete": a.Delete}[cmd]
+		return op(row)
+	case "validate":
+		if err := s.inst.Validate(); err != nil {
+			return err
+		}
+		fmt.Fprintln(s.out, "ok")
+		return nil
+	case "save":
+		path := s.path
+		if args != "" {
+			path = args
+		}
+		bs, err := s.inst.Marshal()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, bs, 0666)
+	case "help":
+		fmt.Fprint(s.out, help)
+		return nil
+	case "quit", "exit":
+		return errQuit
+	}
+	return fmt.Errorf("unknown command %q, try help", cmd)
+}
+
+func (s *session) entityType(name string) (*er.EntityType, error) {
+	for _, t := range s.inst.Model().Types {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("%q: %w", name, er.ErrInvalidRecord)
+}
+
+func (s *session) describe(t *er.EntityType) {
+	if t.Description != "" {
+		fmt.Fprintf(s.out, "%s\n", t.Description)
+	}
+	if t.Supertype != nil {
+		fmt.Fprintf(s.out, "subtype of %s\n", t.Supertype.Name)
+	}
+	for _, a := range t.Attributes {
+		key := ""
+		if a.Identifying {
+			key = " (key)"
+		}
+		if a.Generated != er.NoKey {
+			key = " (key, " + a.Generated.String() + ")"
+		}
+		if a.Optional {
+			key = " (optional)"
+		}
+		if a.Default != "" {
+			key += " = " + a.Default
+		}
+		typ := a.Type.String()
+		if a.Type == er.EnumType {
+			typ = a.Enum.Name + " (" + strings.Join(a.Enum.Values, " | ") + ")"
+		}
+		fmt.Fprintf(s.out, "%s: %s%s%s\n", a.Name, typ, key, note(a.Doc))
+	}
+	for _, d := range t.Derived {
+		var path []string
+		for _, c := range d.Path {
+			path = append(path, c.Rel.Name)
+		}
+		value := strings.Join(path, ".")
+		if d.Count == nil {
+			value = strings.Join(append(path, d.Attribute.Name), ".")
+		} else if value == "" {
+			value = "count " + d.Count.String()
+		} else {
+			value = "count " + d.Count.String() + " to " + value
+		}
+		fmt.Fprintf(s.out, "%s = %s%s\n", d.Name, value, note(d.Doc))
+	}
+	for _, r := range t.Relationships {
+		fmt.Fprintf(s.out, "%s -> %s%s\n", r.Name, r.Target.Name, note(r.Doc))
+	}
+	for _, u := range t.Subtypes {
+		fmt.Fprintf(s.out, "subtype %s\n", u.Name)
+	}
+}
+
+// note follows a line describing an element with the first line of its
+// description.
+func note(d er.Doc) string {
+	if d.Description == "" {
+		return ""
+	}
+	return " -- " + strings.SplitN(d.Description, "\n", 2)[0]
+}
+
+// row reads arguments of the form TYPE { ATTRS }.
+func (s *session) row(args string) (*er.EntityType, rtl.Row, error) {
+	brace := strings.Index(args, "{")
+	if brace < 0 {
+		return nil, nil, er.ErrBadSyntax
+	}
+	t, err := s.entityType(strings.TrimSpace(args[:brace]))
+	if err != nil {
+		return nil, nil, err
+	}
+	p := rtl.NewReader([]byte(args[brace:]))
+	row := instance.ReadRow(p.Record(), t)
+	p.ExpectEOF()
+	return t, row, p.Err()
+}
+
+func (s *session) follow(args string) error {
+	dot, brace := strings.Index(args, "."), strings.Index(args, "{")
+	if dot < 0 || brace < dot {
+		return er.ErrBadSyntax
+	}
+	t, key, err := s.row(args[:dot] + " " + args[brace:])
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSpace(args[dot+1 : brace])
+	var rel *er.Relationship
+	for _, r := range t.Relationships {
+		if r.Name == name {
+			rel = r
+		}
+	}
+	if rel == nil {
+		return fmt.Errorf("%q: %w", name, er.ErrInvalidAttribute)
+	}
+	as := s.inst.Accessors()
+	row, ok := as[t.Name].Find(key)
+	if !ok {
+		return er.ErrMissingEntity
+	}
+	target, ok := query.Follow(as, rel, row)
+	if !ok {
+		return er.ErrMissingEntity
+	}
+	s.print(rel.Target, target)
+	return nil
+}
+
+func (s *session) print(t *er.EntityType, row rtl.Row) {
+	w := rtl.NewWriter("")
+	instance.WriteRow(w, t, row)
+	fmt.Fprintf(s.out, "%s\n", w.Bytes())
+}
+
+// complete finishes the word before pos in line from the commands and the
+// names in the model. If there is more than one way to finish it, the
+// alternatives are returned too.
+func (s *session) complete(line string, pos int) (string, int, []string) {
+	start := pos
+	for start > 0 && isNameChar(line[start-1]) {
+		start--
+	}
+	prefix := line[start:pos]
+	var matches []string
+	for _, w := range s.words() {
+		if strings.HasPrefix(w, prefix) {
+			matches = append(matches, w)
+		}
+	}
+	if len(matches) == 0 {
+		return line, pos, nil
+	}
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(matches) == 1 {
+		common += " "
+		matches = nil
+	}
+	return line[:start] + common + line[pos:], start + len(common), matches
+}
+
+func (s *session) words() []string {
+	seen := map[string]bool{}
+	add := func(w string) { seen[w] = true }
+	for _, w := range commands {
+		add(w)
+	}
+	for _, w := range []string{"where", "and", "or", "not"} {
+		add(w)
+	}
+	for _, t := range s.inst.Model().Types {
+		add(t.Name)
+		for _, a := range t.Attributes {
+			add(a.Name)
+		}
+		for _, r := range t.Relationships {
+			add(r.Name)
+		}
+	}
+	res := make([]string, 0, len(seen))
+	for w := range seen {
+		res = append(res, w)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const model = `
+name: "square"
+type {
+	name: "a"
+	attribute { name: "name" type: "string" identifying: true }
+	attribute { name: "size" type: "int" }
+	relationship { name: "s" type_name: "b" }
+}
+type {
+	name: "b"
+	attribute { name: "name" type: "string" identifying: true }
+}
+`
+
+const data = `a {
+	name: "A1"
+	size: 2
+	s_name: "B1"
+}
+
+b {
+	name: "B1"
+}
+`
+
+func testSession(t *testing.T) *session {
+	dir := t.TempDir()
+	for name, src := range map[string]string{"model": model, "data": data} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := load(filepath.Join(dir, "model"), filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSession(t *testing.T) {
+	s := testSession(t)
+	in := strings.Join([]string{
+		`types`,
+		`select a where size > 1`,
+		`follow a.s { name: "A1" }`,
+		`insert a { name: "A2" s_name: "B2" }`,
+		`validate`,
+		`delete a { name: "A2" }`,
+		`validate`,
+		`frobnicate`,
+		`save`,
+		`quit`,
+		`types`,
+	}, "\n")
+	var out bytes.Buffer
+	if err := runLines(s, strings.NewReader(in), &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		`a`,
+		`b`,
+		`a { name: "A1" size: 2 s_name: "B1" }`,
+		`b { name: "B1" }`,
+		`error: entity not found by key`,
+		`ok`,
+		`error: unknown command "frobnicate", try help`,
+		``,
+	}, "\n")
+	if got := out.String(); got != expected {
+		t.Errorf("got:\n%s\nexpecting:\n%s", got, expected)
+	}
+	bs, err := os.ReadFile(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != data {
+		t.Errorf("saved:\n%s\nexpecting:\n%s", bs, data)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := testSession(t)
+	for _, test := range []struct {
+		line, result string
+		alts         []string
+	}{
+		{"sel", "select ", nil},
+		{"select a where si", "select a where size ", nil},
+		{"s", "s", []string{"s", "s_name", "save", "select", "size"}},
+		{"de", "de", []string{"delete", "describe"}},
+		{"desc x", "desc x", nil},
+	} {
+		line, pos, alts := s.complete(test.line, len(test.line))
+		if line != test.result || pos != len(test.result) || strings.Join(alts, " ") != strings.Join(test.alts, " ") {
+			t.Errorf("%q: got %q, %d, %v", test.line, line, pos, alts)
+		}
+	}
+}
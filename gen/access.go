@@ -0,0 +1,153 @@
+package gen
+
+import (
+	"strings"
+
+	"github.com/bobappleyard/er"
+)
+
+func (g *generator) generateModelAccess() error {
+	g.out("// Accessors gives untyped access to the model's entities, keyed by type")
+	g.out("// name.")
+	g.out("func (m *Model) Accessors() map[string]rtl.Accessor {")
+	g.out("return map[string]rtl.Accessor{")
+	for _, t := range g.m.Types {
+		g.out("%q: m.%s.accessor(),", t.Name, GoName(t.Name))
+	}
+	g.out("}")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+func (g *generator) generateAccess(t *er.EntityType) error {
+	g.out("func (s *setOf%s) accessor() rtl.Accessor {", GoName(t.Name))
+	g.out("return rtl.Accessor{")
+	g.out("ForEach: func(f func(rtl.Row) error) error {")
+	g.out("return s.ForEach(func(e %s) error { return f(e.row()) })", GoName(t.Name))
+	g.out("},")
+	g.out("Find: func(key rtl.Row) (rtl.Row, bool) {")
+	g.out("e, err := s.fromRow(key)")
+	g.out("if err != nil { return nil, false }")
+	g.out("r := s.evalKey(e)")
+	g.out("if !r.Next() { return nil, false }")
+	g.out("return s.entity(s.rows[r.This()]).row(), true")
+	g.out("},")
+	g.out("Count: func(match rtl.Row) int {")
+	g.out("e, err := s.fromRow(match)")
+	g.out("if err != nil { return 0 }")
+	g.out("var q rtl.Query")
+	g.out("for k := range match {")
+	g.out("switch k {")
+	for _, a := range t.Attributes {
+		val := "e." + GoName(a.Name)
+		if a.Optional {
+			g.out("case %q: if %s == nil { return 0 }; q = q.And(s.%s.Eq(*%[2]s))", a.Name, val, GoName(a.Name))
+			continue
+		}
+		g.out("case %q: q = q.And(s.%s.Eq(%s))", a.Name, GoName(a.Name), val)
+	}
+	g.out("}")
+	g.out("}")
+	g.out("return s.Where(q).Count()")
+	g.out("},")
+	g.out("Insert: func(r rtl.Row) error {")
+	g.out("_, err := s.insertRow(r)")
+	g.out("return err")
+	g.out("},")
+	for _, op := range []string{"Update", "Delete"} {
+		g.out("%s: func(r rtl.Row) error {", op)
+		g.out("e, err := s.fromRow(r)")
+		g.out("if err != nil { return err }")
+		g.out("return s.%s(e)", op)
+		g.out("},")
+	}
+	g.out("InsertNew: s.insertRow,")
+	g.out("}")
+	g.out("}")
+	g.out("")
+
+	// the defaults and key are filled in on copies, leaving r as it is
+	g.out("func (s *setOf%s) insertRow(r rtl.Row) (rtl.Row, error) {", GoName(t.Name))
+	if names := literalDefaults(t); len(names) != 0 {
+		g.out("given := r")
+		g.out("r = rtl.Row{}")
+		g.out("for k, v := range given { r[k] = v }")
+		g.out("d := s.New().row()")
+		g.out("for _, k := range []string{%s} {", strings.Join(names, ", "))
+		g.out("if _, ok := r[k]; !ok { r[k] = d[k] }")
+		g.out("}")
+	}
+	g.out("e, err := s.fromRow(r)")
+	g.out("if err != nil { return nil, err }")
+	switch {
+	case generatedKey(t) != nil:
+		g.out("if e, err = s.InsertNew(e); err != nil { return nil, err }")
+	default:
+		if hasInsertDefaults(t) {
+			g.out("s.applyDefaults(&e)")
+		}
+		g.out("if err := s.Insert(e); err != nil { return nil, err }")
+	}
+	g.out("return e.row(), nil")
+	g.out("}")
+	g.out("")
+
+	g.out("func (e %s) row() rtl.Row {", GoName(t.Name))
+	g.out("r := rtl.Row{")
+	for _, a := range t.Attributes {
+		if !a.Optional {
+			g.out("%q: %s,", a.Name, rowValue(a, "e."+GoName(a.Name)))
+		}
+	}
+	g.out("}")
+	for _, a := range t.Attributes {
+		if a.Optional {
+			g.out("if e.%s != nil { r[%q] = %s }", GoName(a.Name), a.Name, rowValue(a, "(*e."+GoName(a.Name)+")"))
+		}
+	}
+	g.out("return r")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s *setOf%s) fromRow(r rtl.Row) (%[1]s, error) {", GoName(t.Name))
+	g.out("e := %s{model: s.model}", GoName(t.Name))
+	g.out("for k, v := range r {")
+	g.out("switch k {")
+	for _, a := range t.Attributes {
+		g.out("case %q:", a.Name)
+		ref := ""
+		if a.Optional {
+			g.out("if v == nil { continue }")
+			ref = "&"
+		}
+		if a.Type == er.EnumType {
+			g.out("x, ok := v.(string)")
+			g.out("if !ok { return e, er.ErrInvalidAttribute }")
+			g.out("y, err := Parse%s(x)", GoName(a.Enum.Name))
+			g.out("if err != nil { return e, err }")
+			g.out("e.%s = %sy", GoName(a.Name), ref)
+			continue
+		}
+		g.out("x, ok := v.(%s)", valueType(a))
+		g.out("if !ok { return e, er.ErrInvalidAttribute }")
+		g.out("e.%s = %sx", GoName(a.Name), ref)
+	}
+	g.out("default:")
+	g.out("return e, er.ErrInvalidAttribute")
+	g.out("}")
+	g.out("}")
+	g.out("return e, nil")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+// rowValue is the value of an attribute as it appears in an rtl.Row, where
+// enum values are given by name.
+func rowValue(a *er.Attribute, val string) string {
+	if a.Type == er.EnumType {
+		return val + ".String()"
+	}
+	return val
+}
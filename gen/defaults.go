@@ -0,0 +1,183 @@
+package gen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+// generateDefaults emits New, which makes an entity holding the default values
+// of its attributes, and applyDefaults, which Insert and Upsert use to fill in
+// the attributes a new entity leaves out. An entity can only be seen to leave
+// out an optional attribute, or one with a generated default whose zero value
+// it holds; the rest take their defaults from New and from records.
+func (g *generator) generateDefaults(t *er.EntityType) error {
+	g.out("// New makes a %s with the default values of its attributes.", GoName(t.Name))
+	g.out("func (s *setOf%s) New() %[1]s {", GoName(t.Name))
+	g.out("var e %s", GoName(t.Name))
+	g.out("e.model = s.model")
+	for _, a := range t.Attributes {
+		if a.Default == "" {
+			continue
+		}
+		stmt, err := assignDefault(a)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(stmt, ";") {
+			stmt = "{ " + stmt + " }"
+		}
+		g.out("%s", stmt)
+	}
+	g.out("return e")
+	g.out("}")
+	g.out("")
+
+	if !hasInsertDefaults(t) {
+		return nil
+	}
+	g.out("func (s *setOf%s) applyDefaults(e *%[1]s) {", GoName(t.Name))
+	for _, a := range t.Attributes {
+		if !insertsDefault(a) {
+			continue
+		}
+		stmt, err := assignDefault(a)
+		if err != nil {
+			return err
+		}
+		g.out("if %s { %s }", leftOut(a), stmt)
+	}
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+// hasInsertDefaults reports whether Insert fills in any attributes of t.
+func hasInsertDefaults(t *er.EntityType) bool {
+	for _, a := range t.Attributes {
+		if insertsDefault(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertsDefault reports whether Insert fills in the attribute when it is left
+// out.
+func insertsDefault(a *er.Attribute) bool {
+	return a.Optional && a.Default != "" || a.Default == rtl.Now || a.Default == rtl.Sequence
+}
+
+// defaultsKey reports whether Insert can fill in part of the key of t.
+func defaultsKey(t *er.EntityType) bool {
+	for _, a := range t.Attributes {
+		if a.Identifying && insertsDefault(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// literalDefaults lists the quoted names of the attributes of t that Insert
+// cannot see to be left out, but which have a default.
+func literalDefaults(t *er.EntityType) []string {
+	var res []string
+	for _, a := range t.Attributes {
+		if a.Default != "" && !insertsDefault(a) {
+			res = append(res, strconv.Quote(a.Name))
+		}
+	}
+	return res
+}
+
+// leftOut is a condition that holds when e leaves out the attribute.
+func leftOut(a *er.Attribute) string {
+	switch {
+	case a.Optional:
+		return "e." + GoName(a.Name) + " == nil"
+	case a.Type == er.IntType:
+		return "e." + GoName(a.Name) + " == 0"
+	}
+	return "e." + GoName(a.Name) + ".IsZero()"
+}
+
+// assignDefault gives the attribute of e its default, in one or more
+// statements.
+func assignDefault(a *er.Attribute) (string, error) {
+	val, err := defaultValue(a)
+	if err != nil {
+		return "", err
+	}
+	if a.Default == rtl.Sequence {
+		return assign(a, "s.next"+GoName(a.Name)+"()"), nil
+	}
+	return assign(a, val), nil
+}
+
+func assign(a *er.Attribute, val string) string {
+	if a.Optional {
+		return fmt.Sprintf("v := %s; e.%s = &v", val, GoName(a.Name))
+	}
+	return fmt.Sprintf("e.%s = %s", GoName(a.Name), val)
+}
+
+// defaultValue is an expression with the default value of the attribute,
+// other than sequence().
+func defaultValue(a *er.Attribute) (string, error) {
+	v, err := rtl.ParseDefault(a)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case a.Default == rtl.Sequence:
+		return "", nil
+	case a.Default == rtl.Now && a.Type == er.DateType:
+		return "rtl.Today()", nil
+	case a.Default == rtl.Now:
+		return "time.Now()", nil
+	}
+	switch v := v.(type) {
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		v = v.UTC()
+		return fmt.Sprintf("time.Date(%d, time.%s, %d, %d, %d, %d, %d, time.UTC)",
+			v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond()), nil
+	case []byte:
+		bs := make([]string, len(v))
+		for i, b := range v {
+			bs[i] = fmt.Sprintf("0x%02x", b)
+		}
+		return "[]byte{" + strings.Join(bs, ", ") + "}", nil
+	case rtl.Decimal:
+		s := v.String()
+		scale := 0
+		if dot := strings.IndexByte(s, '.'); dot >= 0 {
+			scale = len(s) - dot - 1
+			s = s[:dot] + s[dot+1:]
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return "", er.ErrInvalidAttribute
+		}
+		return fmt.Sprintf("rtl.NewDecimal(%d, %d)", n, scale), nil
+	case string:
+		if a.Type == er.EnumType {
+			return GoName(a.Enum.Name) + GoName(v), nil
+		}
+		return strconv.Quote(v), nil
+	}
+	return "", er.ErrInvalidAttribute
+}
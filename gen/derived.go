@@ -0,0 +1,58 @@
+package gen
+
+import (
+	"strings"
+
+	"github.com/bobappleyard/er"
+)
+
+// generateDerived emits a read-only accessor for each derived attribute. The
+// sets of the type also have a column for each, which can be used in queries
+// but not narrowed by an index.
+func (g *generator) generateDerived(t *er.EntityType) error {
+	for _, d := range t.Derived {
+		v := d.Value()
+		if v.Collation != "" {
+			g.out("var %s = rtl.MustCollation(%q)", collationVar(v), v.Collation)
+			g.out("")
+		}
+		var path, names []string
+		for _, c := range d.Path {
+			path = append(path, GoName(c.Rel.Name)+"()")
+			names = append(names, c.Rel.Name)
+		}
+		from := strings.Join(append([]string{"e"}, path...), ".")
+		if d.Count == nil {
+			if !g.doc(d.Doc) {
+				g.out("// %s is derived from %s.", GoName(d.Name), strings.Join(append(names, d.Attribute.Name), "."))
+			}
+			g.out("func (e %s) %s() %s {", GoName(t.Name), GoName(d.Name), attrType(v))
+			g.out("return %s.%s", from, GoName(d.Attribute.Name))
+			g.out("}")
+			g.out("")
+			continue
+		}
+		src := d.Count.Source
+		if !g.doc(d.Doc) {
+			g.out("// %s counts the entities of %s whose %s leads to %s.", GoName(d.Name), src.Name, d.Count.Name, strings.Join(append([]string{"e"}, names...), "."))
+		}
+		g.out("func (e %s) %s() int {", GoName(t.Name), GoName(d.Name))
+		g.out("x := %s", from)
+		g.out("var q rtl.Query")
+		for _, k := range d.Count.Implementation {
+			if len(k.BasePath) != 0 {
+				return er.ErrInvalidAttribute
+			}
+			val := "x." + GoName(k.Target.Name)
+			if k.Target.Optional {
+				g.out("if %s == nil { return 0 }", val)
+				val = "*" + val
+			}
+			g.out("q = q.And(e.model.%s.%s.Eq(%s))", GoName(src.Name), GoName(k.Source.Name), val)
+		}
+		g.out("return e.model.%s.Where(q).Count()", GoName(src.Name))
+		g.out("}")
+		g.out("")
+	}
+	return nil
+}
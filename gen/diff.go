@@ -0,0 +1,203 @@
+package gen
+
+import (
+	"github.com/bobappleyard/er"
+)
+
+func (g *generator) generateModelDiff() error {
+	g.out("// Diff describes how to get from one model to another.")
+	g.out("type Diff struct {")
+	for _, t := range g.m.Types {
+		g.out("%s DiffOf%[1]s", GoName(t.Name))
+	}
+	g.out("}")
+	g.out("")
+
+	g.out("// Diff compares the model with other, keying entities by their identifying")
+	g.out("// attributes.")
+	g.out("func (m *Model) Diff(other *Model) Diff {")
+	g.out("var d Diff")
+	for _, t := range g.m.Types {
+		g.out("d.%s = m.%[1]s.diff(other.%[1]s)", GoName(t.Name))
+	}
+	g.out("return d")
+	g.out("}")
+	g.out("")
+
+	g.out("// Apply replays a diff against the model.")
+	g.out("func (m *Model) Apply(d Diff) error {")
+	for _, t := range g.m.Types {
+		g.out("if err := m.%s.apply(d.%[1]s); err != nil { return err }", GoName(t.Name))
+	}
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+func (g *generator) generateDiff(t *er.EntityType) error {
+	g.out("// DiffOf%s lists the %[1]s entities that differ between two models, in key", GoName(t.Name))
+	g.out("// order.")
+	g.out("type DiffOf%s struct {", GoName(t.Name))
+	g.out("Added, Removed []%s", GoName(t.Name))
+	g.out("Changed []ChangeOf%s", GoName(t.Name))
+	g.out("}")
+	g.out("")
+
+	g.out("func compareKeyOf%s(x, y attrsOf%[1]s) int {", GoName(t.Name))
+	for _, a := range t.Attributes {
+		if !a.Identifying {
+			continue
+		}
+		g.out("if c := %s(x.%s, y.%[2]s); c != 0 { return c }", compareFunc(a), GoName(a.Name))
+	}
+	g.out("return 0")
+	g.out("}")
+	g.out("")
+
+	g.out("func compareAttrsOf%s(x, y attrsOf%[1]s) int {", GoName(t.Name))
+	for _, a := range t.Attributes {
+		g.out("if c := %s(x.%s, y.%[2]s); c != 0 { return c }", compareFunc(a), GoName(a.Name))
+	}
+	g.out("return 0")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) diff(other setOf%[1]s) DiffOf%[1]s {", GoName(t.Name))
+	g.out("var d DiffOf%s", GoName(t.Name))
+	g.out("i, j := 0, 0")
+	g.out("for i < len(s.rows) || j < len(other.rows) {")
+	g.out("var c int")
+	g.out("switch {")
+	g.out("case i == len(s.rows): c = 1")
+	g.out("case j == len(other.rows): c = -1")
+	g.out("default: c = compareKeyOf%s(s.rows[i], other.rows[j])", GoName(t.Name))
+	g.out("}")
+	g.out("switch {")
+	g.out("case c < 0:")
+	g.out("d.Removed = append(d.Removed, s.entity(s.rows[i]))")
+	g.out("i++")
+	g.out("case c > 0:")
+	g.out("d.Added = append(d.Added, other.entity(other.rows[j]))")
+	g.out("j++")
+	g.out("default:")
+	g.out("if compareAttrsOf%s(s.rows[i], other.rows[j]) != 0 {", GoName(t.Name))
+	g.out("d.Changed = append(d.Changed, ChangeOf%s{", GoName(t.Name))
+	g.out("Op: rtl.Updated,")
+	g.out("Before: s.entity(s.rows[i]),")
+	g.out("After: other.entity(other.rows[j]),")
+	g.out("})")
+	g.out("}")
+	g.out("i++")
+	g.out("j++")
+	g.out("}")
+	g.out("}")
+	g.out("return d")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s *setOf%s) apply(d DiffOf%[1]s) error {", GoName(t.Name))
+	g.out("for _, e := range d.Removed {")
+	g.out("if err := s.Delete(e); err != nil { return err }")
+	g.out("}")
+	g.out("for _, e := range d.Added {")
+	g.out("if err := s.Insert(e); err != nil { return err }")
+	g.out("}")
+	g.out("for _, c := range d.Changed {")
+	g.out("if err := s.Update(c.After); err != nil { return err }")
+	g.out("}")
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+func (g *generator) generateModelMerge() error {
+	g.out("// Merge combines the changes made to base by ours and theirs. Where both")
+	g.out("// sides make different changes to the same attribute, ours is kept and a")
+	g.out("// conflict is reported. The merged model is validated, as combining valid")
+	g.out("// changes does not always produce a valid model.")
+	g.out("func Merge(base, ours, theirs *Model) (*Model, []rtl.Conflict, error) {")
+	g.out("m := New()")
+	g.out("var cs []rtl.Conflict")
+	for _, t := range g.m.Types {
+		g.out("cs = m.%s.merge(base.%[1]s, ours.%[1]s, theirs.%[1]s, cs)", GoName(t.Name))
+	}
+	g.out("return m, cs, m.Validate()")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+func (g *generator) generateMerge(t *er.EntityType) error {
+	g.out("func (s *setOf%s) merge(base, ours, theirs setOf%[1]s, cs []rtl.Conflict) []rtl.Conflict {", GoName(t.Name))
+	g.out("i, j, k := 0, 0, 0")
+	g.out("for i < len(base.rows) || j < len(ours.rows) || k < len(theirs.rows) {")
+	g.out("var key *attrsOf%s", GoName(t.Name))
+	g.out("for _, r := range []*attrsOf%s{base.at(i), ours.at(j), theirs.at(k)} {", GoName(t.Name))
+	g.out("if r != nil && (key == nil || compareKeyOf%s(*r, *key) < 0) { key = r }", GoName(t.Name))
+	g.out("}")
+	g.out("b, o, t := base.at(i), ours.at(j), theirs.at(k)")
+	g.out("if b != nil && compareKeyOf%s(*b, *key) == 0 { i++ } else { b = nil }", GoName(t.Name))
+	g.out("if o != nil && compareKeyOf%s(*o, *key) == 0 { j++ } else { o = nil }", GoName(t.Name))
+	g.out("if t != nil && compareKeyOf%s(*t, *key) == 0 { k++ } else { t = nil }", GoName(t.Name))
+	g.out("switch {")
+	g.out("case same%s(o, t), same%[1]s(b, t):", GoName(t.Name))
+	g.out("if o != nil { s.rows = append(s.rows, *o) }")
+	g.out("case same%s(b, o):", GoName(t.Name))
+	g.out("if t != nil { s.rows = append(s.rows, *t) }")
+	g.out("case b != nil && o != nil && t != nil:")
+	g.out("r := *o")
+	for _, a := range t.Attributes {
+		if a.Identifying {
+			continue
+		}
+		g.out("switch {")
+		g.out("case %s(o.%s, t.%[2]s) == 0, %[1]s(b.%[2]s, t.%[2]s) == 0:", compareFunc(a), GoName(a.Name))
+		g.out("case %s(b.%s, o.%[2]s) == 0:", compareFunc(a), GoName(a.Name))
+		g.out("r.%s = t.%[1]s", GoName(a.Name))
+		g.out("default:")
+		g.out("cs = append(cs, rtl.Conflict{Type: %q, Attribute: %q, Base: base.entity(*b), Ours: ours.entity(*o), Theirs: theirs.entity(*t)})", t.Name, a.Name)
+		g.out("}")
+	}
+	g.out("s.rows = append(s.rows, r)")
+	g.out("default:")
+	g.out("c := rtl.Conflict{Type: %q}", t.Name)
+	g.out("if b != nil { c.Base = base.entity(*b) }")
+	g.out("if o != nil { c.Ours = ours.entity(*o) }")
+	g.out("if t != nil { c.Theirs = theirs.entity(*t) }")
+	g.out("cs = append(cs, c)")
+	g.out("if o != nil { s.rows = append(s.rows, *o) }")
+	g.out("}")
+	g.out("}")
+	if sharesValues(t) {
+		g.out("for i := range s.rows {")
+		g.out("d := &s.rows[i]")
+		g.copyValues(t, "d", "d")
+		g.out("}")
+	}
+	g.out("for _, x := range s.indexes { x.Reset(len(s.rows)) }")
+	if cs := counters(t); len(cs) != 0 {
+		g.out("for _, x := range []setOf%s{base, ours, theirs} {", GoName(t.Name))
+		for _, a := range cs {
+			g.out("if x.last%s > s.last%[1]s { s.last%[1]s = x.last%[1]s }", GoName(a.Name))
+		}
+		g.out("}")
+	}
+	g.out("return cs")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) at(i int) *attrsOf%[1]s {", GoName(t.Name))
+	g.out("if i < len(s.rows) { return &s.rows[i] }")
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+
+	g.out("func same%s(x, y *attrsOf%[1]s) bool {", GoName(t.Name))
+	g.out("if x == nil || y == nil { return x == y }")
+	g.out("return compareAttrsOf%s(*x, *y) == 0", GoName(t.Name))
+	g.out("}")
+	g.out("")
+	return nil
+}
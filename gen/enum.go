@@ -0,0 +1,91 @@
+package gen
+
+import (
+	"github.com/bobappleyard/er"
+	"strings"
+	"unicode"
+)
+
+func (g *generator) generateEnums() error {
+	for _, e := range g.m.Enums {
+		for _, v := range e.Values {
+			if !validEnumValue(v) {
+				return er.ErrInvalidAttribute
+			}
+		}
+		name := GoName(e.Name)
+		g.doc(e.Doc)
+		g.out("type %s int", name)
+		g.out("")
+		g.out("const (")
+		for i, v := range e.Values {
+			if i == 0 {
+				g.out("%s%s %[1]s = iota", name, GoName(v))
+				continue
+			}
+			g.out("%s%s", name, GoName(v))
+		}
+		g.out(")")
+		g.out("")
+		g.out("var valuesOf%s = []string{", name)
+		for _, v := range e.Values {
+			g.out("%q,", v)
+		}
+		g.out("}")
+		g.out("")
+		g.out("func (v %s) String() string {", name)
+		g.out("if !v.valid() { return %q + strconv.Itoa(int(v)) + \")\" }", name+"(")
+		g.out("return valuesOf%s[v]", name)
+		g.out("}")
+		g.out("")
+		g.out("func (v %s) valid() bool {", name)
+		g.out("return v >= 0 && int(v) < len(valuesOf%s)", name)
+		g.out("}")
+		g.out("")
+		g.out("// Parse%s finds the %[1]s with the given name.", name)
+		g.out("func Parse%s(s string) (%[1]s, error) {", name)
+		g.out("for i, v := range valuesOf%s {", name)
+		g.out("if v == s { return %s(i), nil }", name)
+		g.out("}")
+		g.out("return 0, er.ErrInvalidAttribute")
+		g.out("}")
+		g.out("")
+		g.out("func compare%s(a, b %[1]s) int {", name)
+		g.out("return rtl.CompareInt(int(a), int(b))")
+		g.out("}")
+		g.out("")
+		g.out("// columnOf%s queries %[1]s attributes, which are ordered as declared.", name)
+		g.out("type columnOf%s struct {", name)
+		g.out("rtl.Int")
+		g.out("}")
+		g.out("")
+		for _, op := range []string{"Eq", "Ne", "Lt", "Le", "Gt", "Ge"} {
+			g.out("func (c columnOf%s) %s(v %[1]s) rtl.Query { return c.Int.%[2]s(int(v)) }", name, op)
+		}
+		g.out("func (c columnOf%s) Range(from, to %[1]s) rtl.Query { return c.Int.Range(int(from), int(to)) }", name)
+		for _, op := range []string{"In", "NotIn"} {
+			g.out("func (c columnOf%s) %s(vs ...%[1]s) rtl.Query {", name, op)
+			g.out("ns := make([]int, len(vs))")
+			g.out("for i, v := range vs { ns[i] = int(v) }")
+			g.out("return c.Int.%s(ns...)", op)
+			g.out("}")
+		}
+		g.out("")
+	}
+	return nil
+}
+
+// validEnumValue reports whether v can be part of a Go identifier.
+func validEnumValue(v string) bool {
+	for _, part := range strings.Split(v, "_") {
+		if part == "" {
+			return false
+		}
+	}
+	for _, r := range v {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,10 +1,17 @@
+// Package gen generates a Go package holding the entities of a physical entity
+// model, with typed sets to query them and the record format to store them.
+//
+// Records must give every mandatory attribute; omitting one is
+// er.ErrMissingAttribute.
 package gen
 
 import (
 	"bytes"
 	"fmt"
 	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
 	"go/format"
+	"strconv"
 	"strings"
 )
 
@@ -15,8 +22,13 @@ func generate(m *er.EntityModel) ([]byte, error) {
 	for _, action := range []func() error{
 		g.generateHeader,
 		g.generateModelDecl,
+		g.generateEnums,
 		g.generateModelCRUD,
 		g.generateModelIO,
+		g.generateModelChanges,
+		g.generateModelDiff,
+		g.generateModelMerge,
+		g.generateModelAccess,
 		g.generateEntities,
 	} {
 		if err := action(); err != nil {
@@ -35,11 +47,39 @@ func (g *generator) out(form string, args ...interface{}) {
 	fmt.Fprintf(&g.dest, form+"\n", args...)
 }
 
+// doc writes an element's description as a comment, reporting whether there
+// was one.
+func (g *generator) doc(d er.Doc) bool {
+	if d.Description == "" {
+		return false
+	}
+	for _, line := range strings.Split(strings.TrimRight(d.Description, "\n"), "\n") {
+		g.out("// %s", line)
+	}
+	return true
+}
+
 func (g *generator) generateHeader() error {
+	g.doc(g.m.Doc)
 	g.out("package %s", g.m.Name)
 	g.out("import (")
 	g.out("%q", "github.com/bobappleyard/er")
 	g.out("%q", "github.com/bobappleyard/er/rtl")
+	for _, t := range g.m.Types {
+		if usesTime(t) {
+			g.out("%q", "time")
+			break
+		}
+	}
+	if len(g.m.Enums) != 0 {
+		g.out("%q", "strconv")
+	}
+	for _, t := range g.m.Types {
+		if usesLength(t) {
+			g.out("%q", "unicode/utf8")
+			break
+		}
+	}
 	g.out(")")
 	return nil
 }
@@ -47,13 +87,16 @@ func (g *generator) generateHeader() error {
 func (g *generator) generateModelDecl() error {
 	g.out("type Model struct {")
 	for _, t := range g.m.Types {
-		g.out("%s setOf%s", goName(t.Name), goName(t.Name))
+		g.out("%s setOf%s", GoName(t.Name), GoName(t.Name))
 	}
+	g.out("")
+	g.out("observers []*func(Change)")
+	g.out("log *rtl.Log")
 	g.out("}")
 	g.out("func New() *Model{ ")
 	g.out("m := new(Model)")
 	for _, t := range g.m.Types {
-		g.out("m.%s.init(m)", goName(t.Name))
+		g.out("m.%s.init(m)", GoName(t.Name))
 	}
 	g.out("return m")
 	g.out("}")
@@ -67,33 +110,179 @@ func (g *generator) generateModelIO() error {
 	g.out("switch p.Name() {")
 	for _, t := range g.dependants(nil) {
 		g.out("case %q:", t.Name)
-		g.out("m.%s.parse(p.Record())", goName(t.Name))
+		g.out("m.%s.parse(p.Record())", GoName(t.Name))
+	}
+	if g.hasCounters() {
+		g.out("case \"_counters\":")
+		g.out("m.parseCounters(p.Record())")
 	}
 	g.out("}}")
 	g.out("p.ExpectEOF()")
 	g.out("return p.Err()")
 	g.out("}")
+	g.out("")
+
+	g.out("func (m *Model) Marshal() ([]byte, error) {")
+	g.out("w := rtl.NewWriter(\"\\t\")")
+	for _, t := range g.dependants(nil) {
+		g.out("if err := m.%s.marshal(w); err != nil { return nil, err }", GoName(t.Name))
+	}
+	if g.hasCounters() {
+		g.out("w.Begin(\"_counters\")")
+		for _, t := range g.m.Types {
+			if len(counters(t)) != 0 {
+				g.out("m.%s.marshalCounters(w)", GoName(t.Name))
+			}
+		}
+		g.out("w.End()")
+	}
+	g.out("return w.Bytes(), nil")
+	g.out("}")
+	g.out("")
+
+	if g.hasCounters() {
+		g.out("// parseCounters raises the counters numbering attributes to those recorded in")
+		g.out("// a snapshot, which may be above the numbers still held by entities.")
+		g.out("func (m *Model) parseCounters(p *rtl.Reader) {")
+		g.out("for p.Next() { switch p.Name() {")
+		for _, t := range g.m.Types {
+			if len(counters(t)) != 0 {
+				g.out("case %q: m.%s.parseCounters(p.Record())", t.Name, GoName(t.Name))
+			}
+		}
+		g.out("default: p.SetErr(er.ErrInvalidRecord)")
+		g.out("}}")
+		g.out("}")
+		g.out("")
+	}
+
+	g.out("// Open loads the model stored at path, replaying any writes logged since")
+	g.out("// the last call to Compact. Subsequent writes are logged before they are")
+	g.out("// applied.")
+	g.out("func Open(path string) (*Model, error) {")
+	g.out("m := New()")
+	g.out("log, err := rtl.OpenLog(path, m.Unmarshal, m.replay)")
+	g.out("if err != nil { return nil, err }")
+	g.out("m.log = log")
+	g.out("return m, nil")
+	g.out("}")
+	g.out("")
+
+	g.out("// Compact writes a snapshot of the model and empties the log.")
+	g.out("func (m *Model) Compact() error {")
+	g.out("if m.log == nil { return nil }")
+	g.out("bs, err := m.Marshal()")
+	g.out("if err != nil { return err }")
+	g.out("return m.log.Compact(bs)")
+	g.out("}")
+	g.out("")
+
+	g.out("func (m *Model) Close() error {")
+	g.out("if m.log == nil { return nil }")
+	g.out("return m.log.Close()")
+	g.out("}")
+	g.out("")
+
+	g.out("func (m *Model) replay(entry []byte) error {")
+	g.out("p := rtl.NewReader(entry)")
+	g.out("for p.Next() {")
+	g.out("op := p.Name()")
+	g.out("r := p.Record()")
+	g.out("for r.Next() {")
+	g.out("switch r.Name() {")
+	for _, t := range g.m.Types {
+		g.out("case %q:", t.Name)
+		g.out("m.%s.replay(op, r.Record())", GoName(t.Name))
+	}
+	g.out("default:")
+	g.out("r.SetErr(er.ErrInvalidRecord)")
+	g.out("}}}")
+	g.out("p.ExpectEOF()")
+	g.out("return p.Err()")
+	g.out("}")
+	g.out("")
+
+	g.out("func (m *Model) record(op rtl.ChangeOp, name string, write func(*rtl.Writer)) error {")
+	g.out("if m.log == nil { return nil }")
+	g.out("w := rtl.NewWriter(\"\")")
+	g.out("w.Begin(op.String())")
+	g.out("w.Begin(name)")
+	g.out("write(w)")
+	g.out("w.End()")
+	g.out("w.End()")
+	g.out("return m.log.Append(w.Bytes())")
+	g.out("}")
+	g.out("")
 	return nil
 }
 
+// hasCounters reports whether any type in the model numbers attributes from a
+// counter.
+func (g *generator) hasCounters() bool {
+	for _, t := range g.m.Types {
+		if len(counters(t)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *generator) generateModelCRUD() error {
 	g.out("func (m *Model) Validate() error {")
 	for _, t := range g.m.Types {
-		g.out("if err := m.%s.validate(); err != nil { return err }", goName(t.Name))
+		g.out("if err := m.%s.validate(); err != nil { return err }", GoName(t.Name))
 	}
 	g.out("return nil")
 	g.out("}")
 	return nil
 }
 
+func (g *generator) generateModelChanges() error {
+	g.out("// Change is an insert, update or delete of a single entity.")
+	g.out("type Change interface {")
+	g.out("change()")
+	g.out("}")
+	g.out("")
+	g.out("// OnChange registers f to be called after every successful write to the")
+	g.out("// model. The returned function cancels the registration.")
+	g.out("func (m *Model) OnChange(f func(Change)) func() {")
+	g.out("p := &f")
+	g.out("m.observers = append(m.observers, p)")
+	g.out("return func() {")
+	g.out("for i, q := range m.observers {")
+	// a new slice, so that a notify under way is not disturbed
+	g.out("if q == p { m.observers = append(m.observers[:i:i], m.observers[i+1:]...); return }")
+	g.out("}")
+	g.out("}")
+	g.out("}")
+	g.out("")
+	g.out("func (m *Model) notify(c Change) {")
+	g.out("for _, f := range m.observers {")
+	g.out("(*f)(c)")
+	g.out("}")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
 func (g *generator) generateEntities() error {
 	for _, t := range g.m.Types {
 		g.out("")
 		for _, action := range []func(*er.EntityType) error{
 			g.generateDecls,
+			g.generateRules,
+			g.generateUnique,
+			g.generateSubtypes,
+			g.generateKeys,
+			g.generateDefaults,
+			g.generateDerived,
 			g.generateRelationships,
 			g.generateCRUD,
+			g.generateChanges,
+			g.generateDiff,
+			g.generateMerge,
 			g.generateIO,
+			g.generateAccess,
 		} {
 			if err := action(t); err != nil {
 				return err
@@ -104,37 +293,78 @@ func (g *generator) generateEntities() error {
 }
 
 func (g *generator) generateDecls(t *er.EntityType) error {
-	g.out("type %s struct {", goName(t.Name))
 	for _, a := range t.Attributes {
-		g.out("%s %s", goName(a.Name), attrType(a))
+		if (a.Type == er.EnumType) != (a.Enum != nil) || a.Optional && a.Identifying {
+			return er.ErrInvalidAttribute
+		}
+		if a.Optional {
+			g.out("func %s(x, y %s) int {", compareFunc(a), attrType(a))
+			g.out("if x == nil || y == nil { return rtl.CompareBool(x != nil, y != nil) }")
+			g.out("return %s(*x, *y)", valueCompareFunc(a))
+			g.out("}")
+			g.out("")
+		}
+		if a.Collation == "" {
+			continue
+		}
+		if _, err := rtl.NewCollation(a.Collation); err != nil {
+			return err
+		}
+		g.out("var %s = rtl.MustCollation(%q)", collationVar(a), a.Collation)
+		g.out("")
+	}
+	g.doc(t.Doc)
+	g.out("type %s struct {", GoName(t.Name))
+	for _, a := range t.Attributes {
+		g.doc(a.Doc)
+		g.out("%s %s", GoName(a.Name), attrType(a))
 	}
 	g.out("")
 	g.out("model *Model")
 	g.out("}")
 	g.out("")
-	g.out("type attrsOf%s struct {", goName(t.Name))
+	g.out("type attrsOf%s struct {", GoName(t.Name))
 	for _, a := range t.Attributes {
-		g.out("%s %s", goName(a.Name), attrType(a))
+		g.out("%s %s", GoName(a.Name), attrType(a))
 	}
 	g.out("}")
 	g.out("")
-	g.out("type setOf%s struct {", goName(t.Name))
+	g.out("type setOf%s struct {", GoName(t.Name))
 	for _, a := range t.Attributes {
-		g.out("%s %s", goName(a.Name), columnType(a))
+		g.out("%s %s", GoName(a.Name), columnType(a))
+	}
+	for _, d := range t.Derived {
+		g.out("%s %s", GoName(d.Name), columnType(d.Value()))
 	}
 	g.out("")
 	g.out("model *Model")
 	g.out("query *rtl.Query")
-	g.out("rows []attrsOf%s", goName(t.Name))
+	g.out("rows []attrsOf%s", GoName(t.Name))
+	g.out("indexes []*rtl.Index")
+	g.out("order []rtl.Order")
+	g.out("offset, limit int")
+	for _, a := range counters(t) {
+		g.out("last%s int", GoName(a.Name))
+	}
 	g.out("}")
-	g.out("func(s *setOf%s) init(m *Model) {", goName(t.Name))
+	g.out("func(s *setOf%s) init(m *Model) {", GoName(t.Name))
 	g.out("s.model = m")
+	g.out("s.limit = -1")
 	for i, a := range t.Attributes {
-		init := "Column"
-		if a.Identifying {
-			init = "Index"
+		g.out("s.%s = %s", GoName(a.Name), columnInit(a, i, "s.rows[idx]."+GoName(a.Name)))
+	}
+	for _, d := range t.Derived {
+		g.out("s.%s = %s", GoName(d.Name), columnInit(d.Value(), -1, "s.entity(s.rows[idx])."+GoName(d.Name)+"()"))
+	}
+	for _, x := range t.Indexes {
+		columns := make([]string, len(x.Attributes))
+		g.out("s.indexes = append(s.indexes, rtl.NewIndex(func(i, j int) int {")
+		for i, a := range x.Attributes {
+			g.out("if c := %s(s.rows[i].%s, s.rows[j].%[2]s); c != 0 { return c }", compareFunc(a), GoName(a.Name))
+			columns[i] = fmt.Sprint(attrIndex(t, a))
 		}
-		g.out("s.%s = %s%s(%d, func(idx int) %s { return s.rows[idx].%[1]s})", goName(a.Name), columnType(a), init, i, attrType(a))
+		g.out("return 0")
+		g.out("}, %s))", strings.Join(columns, ", "))
 	}
 	g.out("}")
 	g.out("")
@@ -142,98 +372,223 @@ func (g *generator) generateDecls(t *er.EntityType) error {
 }
 
 func (g *generator) generateRelationships(t *er.EntityType) error {
-	g.out("func (s setOf%s) validate() error {", goName(t.Name))
-	if len(t.Relationships) != 0 {
-		g.out("if err := s.ForEach(func(e %s) error {", goName(t.Name))
-		for _, r := range t.Relationships {
-			g.out("{")
-			g.out("q := e.queryFor%s()", goName(r.Name))
-			g.out("if q.Count() != 1 { return er.ErrMissingEntity }")
-			if len(r.Constraints) == 0 {
-				g.out("}")
-				continue
+	g.out("func (s setOf%s) validate() error {", GoName(t.Name))
+	g.out("if err := s.ForEach(func(e %s) error {", GoName(t.Name))
+	g.out("if err := e.check(); err != nil { return err }")
+	g.out("if err := s.checkUnique(e); err != nil { return err }")
+	if hasSubtypeChecks(t) {
+		g.out("if err := s.checkSubtypes(e); err != nil { return err }")
+	}
+	for _, a := range t.Attributes {
+		if a.Type != er.EnumType {
+			continue
+		}
+		if a.Optional {
+			g.out("if e.%s != nil && !e.%[1]s.valid() { return er.ErrInvalidAttribute }", GoName(a.Name))
+			continue
+		}
+		g.out("if !e.%s.valid() { return er.ErrInvalidAttribute }", GoName(a.Name))
+	}
+	for _, r := range t.Relationships {
+		g.out("{")
+		g.out("q := e.queryFor%s()", GoName(r.Name))
+		g.out("if q.Count() != 1 { return er.ErrMissingEntity }")
+		if len(r.Constraints) == 0 {
+			g.out("}")
+			continue
+		}
+		g.out("t := q.ExactlyOne()")
+		for _, c := range r.Constraints {
+			diagonal := make([]string, len(c.Diagonal.Components))
+			for i, m := range c.Diagonal.Components {
+				diagonal[i] = GoName(m.Rel.Name) + "()"
+			}
+			riser := make([]string, len(c.Riser.Components))
+			for i, m := range c.Riser.Components {
+				riser[i] = GoName(m.Rel.Name) + "()"
 			}
-			g.out("t := q.ExactlyOne()")
-			for _, c := range r.Constraints {
-				diagonal := make([]string, len(c.Diagonal.Components))
-				for i, m := range c.Diagonal.Components {
-					diagonal[i] = goName(m.Rel.Name) + "()"
-				}
-				riser := make([]string, len(c.Riser.Components))
-				for i, m := range c.Riser.Components {
-					riser[i] = goName(m.Rel.Name) + "()"
-				}
-				g.out("if e.%s != t.%s {", strings.Join(diagonal, "."), strings.Join(riser, "."))
-				g.out("return er.ErrMissingEntity")
-				g.out("}")
+			end := c.Riser.Components[len(c.Riser.Components)-1].Rel.Target
+			var differ []string
+			for _, a := range end.Attributes {
+				differ = append(differ, fmt.Sprintf("%s(x.%s, y.%[2]s) != 0", compareFunc(a), GoName(a.Name)))
 			}
+			if differ == nil {
+				differ = []string{"false"}
+			}
+			g.out("if x, y := e.%s, t.%s; %s {", strings.Join(diagonal, "."), strings.Join(riser, "."), strings.Join(differ, " || "))
+			g.out("return er.ErrMissingEntity")
 			g.out("}")
 		}
-		g.out("return nil")
-		g.out("}); err != nil { return err }")
+		g.out("}")
 	}
 	g.out("return nil")
+	g.out("}); err != nil { return err }")
+	g.out("return nil")
 	g.out("}")
 	g.out("")
 	for _, r := range t.Relationships {
-		g.out("func (e %s) %s() %s {", goName(t.Name), goName(r.Name), goName(r.Target.Name))
-		g.out("return e.queryFor%s().ExactlyOne()", goName(r.Name))
+		g.doc(r.Doc)
+		g.out("func (e %s) %s() %s {", GoName(t.Name), GoName(r.Name), GoName(r.Target.Name))
+		g.out("return e.queryFor%s().ExactlyOne()", GoName(r.Name))
 		g.out("}")
 		g.out("")
 
-		g.out("func (e %s) queryFor%s() setOf%s {", goName(t.Name), goName(r.Name), goName(r.Target.Name))
+		g.out("func (e %s) queryFor%s() setOf%s {", GoName(t.Name), GoName(r.Name), GoName(r.Target.Name))
 		g.out("var q rtl.Query")
 		for _, k := range r.Implementation {
 			path := make([]string, len(k.BasePath)+1)
 			for i, c := range k.BasePath {
-				path[i] = goName(c.Rel.Name) + "()"
+				path[i] = GoName(c.Rel.Name) + "()"
 			}
-			path[len(path)-1] = goName(k.Source.Name)
-			g.out("q = q.And(e.model.%s.%s.Eq(e.%s))", goName(r.Target.Name), goName(k.Target.Name), strings.Join(path, "."))
+			path[len(path)-1] = GoName(k.Source.Name)
+			g.out("q = q.And(e.model.%s.%s.Eq(e.%s))", GoName(r.Target.Name), GoName(k.Target.Name), strings.Join(path, "."))
 		}
-		g.out("return e.model.%s.Where(q)", goName(r.Target.Name))
+		g.out("return e.model.%s.Where(q)", GoName(r.Target.Name))
+		g.out("}")
+		g.out("")
+
+		g.out("// Where%s restricts the set to entities whose %s matches q.", GoName(r.Name), r.Name)
+		g.out("func (s setOf%s) Where%s(q rtl.Query) setOf%[1]s {", GoName(t.Name), GoName(r.Name))
+		g.out("return s.Where(s.Has%s(q))", GoName(r.Name))
+		g.out("}")
+		g.out("")
+
+		g.out("// Has%s matches the entities whose %s matches q at the time of the call.", GoName(r.Name), r.Name)
+		g.out("func (s setOf%s) Has%s(q rtl.Query) rtl.Query {", GoName(t.Name), GoName(r.Name))
+		g.out("m := s.model")
+		cols, targets := joinColumns(t, r)
+		g.out("return rtl.Join([]rtl.Column{%s}, []rtl.Column{%s}, m.%s.Where(q).eval())", strings.Join(cols, ", "), strings.Join(targets, ", "), GoName(r.Target.Name))
 		g.out("}")
 		g.out("")
 	}
 	return nil
 }
 
+// joinColumns lists the columns joining the entities of t to the targets of r,
+// with the columns that t holds first so that they can lead the join. Key
+// columns reached through a base path are computed from each entity.
+func joinColumns(t *er.EntityType, r *er.Relationship) ([]string, []string) {
+	var cols, targets, pathCols, pathTargets []string
+	for _, k := range r.Implementation {
+		target := joinColumn("m."+GoName(r.Target.Name)+"."+GoName(k.Target.Name), k.Target)
+		if len(k.BasePath) == 0 {
+			cols = append(cols, joinColumn("s."+GoName(k.Source.Name), k.Source))
+			targets = append(targets, target)
+			continue
+		}
+		path := make([]string, len(k.BasePath)+1)
+		for i, c := range k.BasePath {
+			path[i] = GoName(c.Rel.Name) + "()"
+		}
+		path[len(path)-1] = GoName(k.Source.Name)
+		a := *k.Source
+		a.Identifying = false
+		get := fmt.Sprintf("m.%s.entity(m.%[1]s.rows[idx]).%s", GoName(t.Name), strings.Join(path, "."))
+		pathCols = append(pathCols, joinColumn(columnInit(&a, -1, get), &a))
+		pathTargets = append(pathTargets, target)
+	}
+	return append(cols, pathCols...), append(targets, pathTargets...)
+}
+
+// joinColumn is the column to join on for an attribute, unwrapping enumerations
+// to their values.
+func joinColumn(col string, a *er.Attribute) string {
+	if a.Type == er.EnumType {
+		return col + ".Int"
+	}
+	return col
+}
+
 func (g *generator) generateCRUD(t *er.EntityType) error {
-	g.out("func (s setOf%s) ForEach(f func(%[1]s) error) error {", goName(t.Name))
-	g.out("q := rtl.All(len(s.rows))")
-	g.out("if s.query != nil { q = rtl.EvalQuery(*s.query, len(s.rows)) }")
+	g.out("func (s setOf%s) ForEach(f func(%[1]s) error) error {", GoName(t.Name))
+	g.out("q := s.eval()")
 	g.out("for q.Next() {")
-	g.out("d := s.rows[q.This()]")
-	g.out("if err := f(%s{", goName(t.Name))
+	g.out("if err := f(s.entity(s.rows[q.This()])); err != nil { return err }")
+	g.out("}")
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) eval() *rtl.QueryResult {", GoName(t.Name))
+	g.out("q := rtl.All(len(s.rows))")
+	g.out("if s.query != nil { q = rtl.EvalQuery(*s.query, len(s.rows), s.indexes...) }")
+	g.out("if s.order != nil { q.Sort(s.order) }")
+	g.out("q.Page(s.offset, s.limit)")
+	g.out("return q")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) entity(d attrsOf%[1]s) %[1]s {", GoName(t.Name))
+	g.out("e := %s{", GoName(t.Name))
 	g.out("model: s.model,")
 	for _, a := range t.Attributes {
-		g.out("%s: d.%[1]s,", goName(a.Name))
+		g.out("%s: d.%[1]s,", GoName(a.Name))
 	}
-	g.out("}); err != nil { return err }")
 	g.out("}")
-	g.out("return nil")
+	g.copyValues(t, "e", "d")
+	g.out("return e")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s setOf%s) Count() int {", goName(t.Name))
+	g.out("func (s setOf%s) Count() int {", GoName(t.Name))
 	g.out("c := 0")
-	g.out("s.ForEach(func(%s) error {", goName(t.Name))
+	g.out("for q := s.eval(); q.Next(); {")
 	g.out("c++")
-	g.out("return nil")
-	g.out("})")
+	g.out("}")
 	g.out("return c")
 	g.out("}")
 
-	g.out("func (s setOf%s) ExactlyOne() %[1]s {", goName(t.Name))
-	g.out("var res %s", goName(t.Name))
-	g.out("s.ForEach(func(t %s) error {", goName(t.Name))
+	g.out("func (s setOf%s) ExactlyOne() %[1]s {", GoName(t.Name))
+	g.out("var res %s", GoName(t.Name))
+	g.out("s.ForEach(func(t %s) error {", GoName(t.Name))
 	g.out("res = t")
 	g.out("return nil")
 	g.out("})")
 	g.out("return res")
 	g.out("}")
 
-	g.out("func (s setOf%s) Where(q rtl.Query) setOf%[1]s {", goName(t.Name))
+	g.out("// GroupBy splits the entities matching the set's query into subsets sharing")
+	g.out("// a value of c, in order of c. The set's order, offset and limit are left")
+	g.out("// out, as they page through entities rather than groups; page through the")
+	g.out("// groups instead.")
+	g.out("func (s setOf%s) GroupBy(c rtl.Column) []setOf%[1]s {", GoName(t.Name))
+	g.out("s.order, s.offset, s.limit = nil, 0, -1")
+	g.out("qs := rtl.GroupBy(s.eval(), c)")
+	g.out("res := make([]setOf%s, len(qs))", GoName(t.Name))
+	g.out("for i, q := range qs { res[i] = s.Where(q) }")
+	g.out("return res")
+	g.out("}")
+	g.out("")
+
+	for _, a := range t.Attributes {
+		if a.Type != er.IntType && a.Type != er.FloatType {
+			continue
+		}
+		g.out("func (s setOf%s) Sum%s() %s { return s.%[2]s.Sum(s.eval()) }", GoName(t.Name), GoName(a.Name), valueType(a))
+		g.out("func (s setOf%s) Min%s() (%s, bool) { return s.%[2]s.Min(s.eval()) }", GoName(t.Name), GoName(a.Name), valueType(a))
+		g.out("func (s setOf%s) Max%s() (%s, bool) { return s.%[2]s.Max(s.eval()) }", GoName(t.Name), GoName(a.Name), valueType(a))
+		g.out("func (s setOf%s) Avg%s() (float64, bool) { return s.%[2]s.Avg(s.eval()) }", GoName(t.Name), GoName(a.Name))
+		g.out("")
+	}
+
+	g.out("// Explain describes how the set's rows would be found.")
+	g.out("func (s setOf%s) Explain() string {", GoName(t.Name))
+	g.out("var q rtl.Query")
+	g.out("if s.query != nil { q = *s.query }")
+	g.out("return q.Explain(len(s.rows), s.indexes...)")
+	g.out("}")
+	g.out("")
+
+	g.out("// Predicate builds a query from an arbitrary test of the entities.")
+	g.out("func (s setOf%s) Predicate(f func(%[1]s) bool) rtl.Query {", GoName(t.Name))
+	g.out("m := s.model")
+	g.out("return rtl.Where(func(idx int) bool {")
+	g.out("return f(m.%s.entity(m.%[1]s.rows[idx]))", GoName(t.Name))
+	g.out("})")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) Where(q rtl.Query) setOf%[1]s {", GoName(t.Name))
 	g.out("res := s")
 	g.out("if res.query != nil { q = q.And(*res.query) }")
 	g.out("res.query = &q")
@@ -241,104 +596,315 @@ func (g *generator) generateCRUD(t *er.EntityType) error {
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) Insert(e %[1]s) error {", goName(t.Name))
+	g.out("// OrderBy sorts the set by a column, after any orderings already applied.")
+	g.out("func (s setOf%s) OrderBy(c rtl.Column, desc bool) setOf%[1]s {", GoName(t.Name))
+	g.out("res := s.view()")
+	g.out("res.order = append(res.order[:len(res.order):len(res.order)], rtl.OrderBy(c, desc))")
+	g.out("return res")
+	g.out("}")
+	g.out("")
+
+	g.out("// Limit restricts the set to at most n entities.")
+	g.out("func (s setOf%s) Limit(n int) setOf%[1]s {", GoName(t.Name))
+	g.out("res := s.view()")
+	g.out("if res.limit < 0 || n < res.limit { res.limit = n }")
+	g.out("return res")
+	g.out("}")
+	g.out("")
+
+	g.out("// Offset skips the first n entities in the set.")
+	g.out("func (s setOf%s) Offset(n int) setOf%[1]s {", GoName(t.Name))
+	g.out("res := s.view()")
+	g.out("res.offset += n")
+	g.out("if res.limit >= 0 {")
+	g.out("res.limit -= n")
+	g.out("if res.limit < 0 { res.limit = 0 }")
+	g.out("}")
+	g.out("return res")
+	g.out("}")
+	g.out("")
+
+	g.out("// After restricts the set to entities whose keys come after e's, for")
+	g.out("// paging through the set in key order.")
+	g.out("func (s setOf%s) After(e %[1]s) setOf%[1]s {", GoName(t.Name))
+	g.out("var q, prefix rtl.Query")
+	k := key(t)
+	if len(k) == 0 {
+		g.out("q = rtl.Not(prefix)")
+	}
+	for i, a := range k {
+		if i == 0 {
+			g.out("q = s.%s.Gt(e.%[1]s)", GoName(a.Name))
+		} else {
+			g.out("q = q.Or(prefix.And(s.%s.Gt(e.%[1]s)))", GoName(a.Name))
+		}
+		g.out("prefix = prefix.And(s.%s.Eq(e.%[1]s))", GoName(a.Name))
+	}
+	g.out("return s.Where(q)")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) view() setOf%[1]s {", GoName(t.Name))
+	g.out("if s.query == nil { s.query = &rtl.Query{} }")
+	g.out("return s")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s *setOf%s) Insert(e %[1]s) error {", GoName(t.Name))
 	g.out("if s.query != nil { return er.ErrImmutableSet }")
+	if a := generatedKey(t); a != nil {
+		g.out("%s", assignKey(a))
+	}
+	if hasInsertDefaults(t) {
+		g.out("s.applyDefaults(&e)")
+	}
+	g.out("if err := e.check(); err != nil { return err }")
 	g.out("r := s.evalKey(e)")
 	g.out("if r.Next() { return er.ErrDuplicateKey }")
+	g.out("if err := s.checkUnique(e); err != nil { return err }")
+	g.out("if err := s.model.record(rtl.Inserted, %q, e.write); err != nil { return err }", t.Name)
 	g.out("s.clearSpace(r)")
 	g.out("s.writeRow(r, e)")
+	g.out("rtl.Reindex(s.indexes, rtl.Inserted, r.This())")
+	g.out("s.model.notify(ChangeOf%s{Op: rtl.Inserted, After: s.entity(s.rows[r.This()])})", GoName(t.Name))
 	g.out("return nil")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) Update(e %[1]s) error {", goName(t.Name))
+	g.out("func (s *setOf%s) Update(e %[1]s) error {", GoName(t.Name))
 	g.out("if s.query != nil { return er.ErrImmutableSet }")
+	g.out("if err := e.check(); err != nil { return err }")
 	g.out("r := s.evalKey(e)")
 	g.out("if !r.Next() { return er.ErrMissingEntity }")
+	g.out("if err := s.checkUnique(e); err != nil { return err }")
+	g.out("if err := s.model.record(rtl.Updated, %q, e.write); err != nil { return err }", t.Name)
+	g.out("before := s.entity(s.rows[r.This()])")
 	g.out("s.writeRow(r, e)")
+	g.out("rtl.Reindex(s.indexes, rtl.Updated, r.This())")
+	g.out("s.model.notify(ChangeOf%s{Op: rtl.Updated, Before: before, After: s.entity(s.rows[r.This()])})", GoName(t.Name))
 	g.out("return nil")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) Upsert(e %[1]s) error {", goName(t.Name))
+	g.out("func (s *setOf%s) Upsert(e %[1]s) error {", GoName(t.Name))
 	g.out("if s.query != nil { return er.ErrImmutableSet }")
+	if a := generatedKey(t); a != nil {
+		g.out("%s", assignKey(a))
+	}
 	g.out("r := s.evalKey(e)")
-	g.out("if !r.Next() { s.clearSpace(r) }")
+	g.out("c := ChangeOf%s{Op: rtl.Inserted}", GoName(t.Name))
+	g.out("if r.Next() {")
+	g.out("c.Op = rtl.Updated")
+	g.out("c.Before = s.entity(s.rows[r.This()])")
+	if hasInsertDefaults(t) {
+		g.out("} else {")
+		g.out("s.applyDefaults(&e)")
+		if defaultsKey(t) {
+			g.out("r = s.evalKey(e)")
+			g.out("if r.Next() { return er.ErrDuplicateKey }")
+		}
+	}
+	g.out("}")
+	g.out("if err := e.check(); err != nil { return err }")
+	g.out("if err := s.checkUnique(e); err != nil { return err }")
+	g.out("if err := s.model.record(c.Op, %q, e.write); err != nil { return err }", t.Name)
+	g.out("if c.Op == rtl.Inserted { s.clearSpace(r) }")
 	g.out("s.writeRow(r, e)")
+	g.out("rtl.Reindex(s.indexes, c.Op, r.This())")
+	g.out("c.After = s.entity(s.rows[r.This()])")
+	g.out("s.model.notify(c)")
 	g.out("return nil")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) Delete(e %[1]s) error {", goName(t.Name))
+	g.out("func (s *setOf%s) Delete(e %[1]s) error {", GoName(t.Name))
 	g.out("if s.query != nil { return er.ErrImmutableSet }")
 	g.out("r := s.evalKey(e)")
 	g.out("if !r.Next() { return er.ErrMissingEntity }")
-	g.out("copy(s.rows[r.This():], s.rows[r.This():+1])")
+	g.out("if err := s.model.record(rtl.Deleted, %q, e.write); err != nil { return err }", t.Name)
+	g.out("before := s.entity(s.rows[r.This()])")
+	g.out("copy(s.rows[r.This():], s.rows[r.This()+1:])")
 	g.out("s.rows = s.rows[:len(s.rows)-1]")
+	g.out("rtl.Reindex(s.indexes, rtl.Deleted, r.This())")
+	g.out("s.model.notify(ChangeOf%s{Op: rtl.Deleted, Before: before})", GoName(t.Name))
 	g.out("return nil")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) evalKey(e %[1]s) *rtl.QueryResult {", goName(t.Name))
+	g.out("func (s *setOf%s) evalKey(e %[1]s) *rtl.QueryResult {", GoName(t.Name))
 	g.out("var query rtl.Query")
 	for _, a := range t.Attributes {
 		if !a.Identifying {
 			continue
 		}
-		g.out("query = query.And(s.%s.Eq(e.%[1]s))", goName(a.Name))
+		g.out("query = query.And(s.%s.Eq(e.%[1]s))", GoName(a.Name))
 	}
 	g.out("return rtl.EvalQuery(query, len(s.rows))")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) clearSpace(r *rtl.QueryResult) {", goName(t.Name))
-	g.out("s.rows = append(s.rows, attrsOf%s{})", goName(t.Name))
+	g.out("func (s *setOf%s) clearSpace(r *rtl.QueryResult) {", GoName(t.Name))
+	g.out("s.rows = append(s.rows, attrsOf%s{})", GoName(t.Name))
 	g.out("copy(s.rows[r.This()+1:], s.rows[r.This():])")
 	g.out("}")
 	g.out("")
 
-	g.out("func (s *setOf%s) writeRow(r *rtl.QueryResult, e %[1]s) {", goName(t.Name))
-	g.out("s.rows[r.This()] = attrsOf%s {", goName(t.Name))
+	g.out("func (s *setOf%s) writeRow(r *rtl.QueryResult, e %[1]s) {", GoName(t.Name))
+	g.out("d := attrsOf%s {", GoName(t.Name))
 	for _, a := range t.Attributes {
-		g.out("%s: e.%[1]s,", goName(a.Name))
+		g.out("%s: e.%[1]s,", GoName(a.Name))
 	}
 	g.out("}")
+	g.copyValues(t, "d", "e")
+	g.out("s.rows[r.This()] = d")
+	if len(counters(t)) != 0 {
+		g.out("s.raiseCounters(d)")
+	}
 	g.out("}")
 	g.out("")
 
 	return nil
 }
 
+func (g *generator) generateChanges(t *er.EntityType) error {
+	g.out("// ChangeOf%s describes a write to a %[1]s. Before is the zero value for", GoName(t.Name))
+	g.out("// inserts and After is the zero value for deletes.")
+	g.out("type ChangeOf%s struct {", GoName(t.Name))
+	g.out("Op rtl.ChangeOp")
+	g.out("Before, After %s", GoName(t.Name))
+	g.out("}")
+	g.out("")
+	g.out("func (ChangeOf%s) change() {}", GoName(t.Name))
+	g.out("")
+	return nil
+}
+
 func (g *generator) generateIO(t *er.EntityType) error {
 	omit := map[string]bool{}
 	if t.DependsOn != nil {
 		parent := t.DependsOn.Target
-		g.out("func (s *setOf%s) parse(p *rtl.Reader, parent %s) {", goName(t.Name), goName(parent.Name))
-		g.out("var e %s", goName(t.Name))
+		g.out("func (s *setOf%s) parse(p *rtl.Reader, parent %s) {", GoName(t.Name), GoName(parent.Name))
+		g.out("e := s.New()")
 		for _, k := range t.DependsOn.Implementation {
-			g.out("e.%s = parent.%s", goName(k.Source.Name), goName(k.Target.Name))
+			g.out("e.%s = parent.%s", GoName(k.Source.Name), GoName(k.Target.Name))
 			omit[k.Source.Name] = true
 		}
 	} else {
-		g.out("func (s *setOf%s) parse(p *rtl.Reader) {", goName(t.Name))
-		g.out("var e %s", goName(t.Name))
+		g.out("func (s *setOf%s) parse(p *rtl.Reader) {", GoName(t.Name))
+		g.out("e := s.New()")
 	}
 	g.out("for p.Next() { switch p.Name() {")
 	for _, a := range t.Attributes {
 		if omit[a.Name] {
 			continue
 		}
-		g.out("case %q: e.%s = p.%s()", a.Name, goName(a.Name), attrParse(a))
+		g.out("case %q: %s", a.Name, readAttr(a))
 	}
 	for _, d := range g.dependants(t) {
-		g.out("case %q: s.model.%s.parse(p.Record(), e)", d.Name, goName(d.Name))
+		g.out("case %q: s.model.%s.parse(p.Record(), e)", d.Name, GoName(d.Name))
 	}
+	g.out("default: p.SetErr(er.ErrInvalidAttribute)")
 	g.out("}}")
+	g.out("p.Require(%s)", required(t, omit))
 	g.out("if p.Err() == nil { p.SetErr(s.Insert(e)) }")
 	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) marshal(w *rtl.Writer) error {", GoName(t.Name))
+	g.out("return s.ForEach(func(e %s) error {", GoName(t.Name))
+	g.out("w.Begin(%q)", t.Name)
+	for _, a := range t.Attributes {
+		if omit[a.Name] {
+			continue
+		}
+		g.out("%s", writeAttr(a))
+	}
+	for _, d := range g.dependants(t) {
+		g.out("{")
+		g.out("var q rtl.Query")
+		for _, k := range d.DependsOn.Implementation {
+			g.out("q = q.And(s.model.%s.%s.Eq(e.%s))", GoName(d.Name), GoName(k.Source.Name), GoName(k.Target.Name))
+		}
+		g.out("if err := s.model.%s.Where(q).marshal(w); err != nil { return err }", GoName(d.Name))
+		g.out("}")
+	}
+	g.out("w.End()")
+	g.out("return nil")
+	g.out("})")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s *setOf%s) replay(op string, p *rtl.Reader) {", GoName(t.Name))
+	g.out("var e %s", GoName(t.Name))
+	g.out("for p.Next() { switch p.Name() {")
+	for _, a := range t.Attributes {
+		g.out("case %q: %s", a.Name, readAttr(a))
+	}
+	g.out("default: p.SetErr(er.ErrInvalidAttribute)")
+	g.out("}}")
+	g.out("p.Require(%s)", required(t, nil))
+	g.out("if p.Err() != nil { return }")
+	g.out("switch op {")
+	g.out("case rtl.Inserted.String(), rtl.Updated.String():")
+	g.out("p.SetErr(s.Upsert(e))")
+	g.out("case rtl.Deleted.String():")
+	g.out("if err := s.Delete(e); err != er.ErrMissingEntity { p.SetErr(err) }")
+	g.out("default:")
+	g.out("p.SetErr(er.ErrBadSyntax)")
+	g.out("}")
+	g.out("}")
+	g.out("")
+
+	g.out("func (e %s) write(w *rtl.Writer) {", GoName(t.Name))
+	for _, a := range t.Attributes {
+		g.out("%s", writeAttr(a))
+	}
+	g.out("}")
+	g.out("")
 	return nil
 }
 
+// copyValues points the optional attributes of dst at copies of the values in
+// src and copies the bytes attributes, so that the stored rows are not shared
+// with entities or with other models.
+func (g *generator) copyValues(t *er.EntityType, dst, src string) {
+	for _, a := range t.Attributes {
+		val := "*" + src + "." + GoName(a.Name)
+		if a.Type == er.BytesType {
+			val = "append([]byte(nil), " + val + "...)"
+		}
+		switch {
+		case a.Optional:
+			g.out("if %s.%s != nil { v := %s; %s.%[2]s = &v }", src, GoName(a.Name), val, dst)
+		case a.Type == er.BytesType:
+			g.out("%s.%s = append([]byte(nil), %s.%[2]s...)", dst, GoName(a.Name), src)
+		}
+	}
+}
+
+// sharesValues reports whether the rows of t hold values that copyValues
+// copies.
+func sharesValues(t *er.EntityType) bool {
+	for _, a := range t.Attributes {
+		if a.Optional || a.Type == er.BytesType {
+			return true
+		}
+	}
+	return false
+}
+
+// required lists the quoted names of the attributes that records of t must
+// give, apart from those in omit and those with a default.
+func required(t *er.EntityType, omit map[string]bool) string {
+	var res []string
+	for _, a := range t.Attributes {
+		if !a.Optional && a.Generated == er.NoKey && a.Default == "" && !omit[a.Name] {
+			res = append(res, strconv.Quote(a.Name))
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
 func (g *generator) dependants(t *er.EntityType) []*er.EntityType {
 	var res []*er.EntityType
 	for _, u := range g.m.Types {
@@ -353,7 +919,8 @@ func (g *generator) dependants(t *er.EntityType) []*er.EntityType {
 	return res
 }
 
-func goName(name string) string {
+// GoName is the Go identifier generated code uses for a name in the model.
+func GoName(name string) string {
 	parts := strings.Split(name, "_")
 	for i, p := range parts {
 		parts[i] = strings.ToTitle(p[:1]) + p[1:]
@@ -361,7 +928,16 @@ func goName(name string) string {
 	return strings.Join(parts, "")
 }
 
+// attrType is the type of the attribute's field, which is a pointer for
+// optional attributes.
 func attrType(a *er.Attribute) string {
+	if a.Optional {
+		return "*" + valueType(a)
+	}
+	return valueType(a)
+}
+
+func valueType(a *er.Attribute) string {
 	switch a.Type {
 	case er.IntType:
 		return "int"
@@ -369,10 +945,29 @@ func attrType(a *er.Attribute) string {
 		return "float64"
 	case er.StringType:
 		return "string"
+	case er.BoolType:
+		return "bool"
+	case er.TimeType, er.DateType:
+		return "time.Time"
+	case er.BytesType:
+		return "[]byte"
+	case er.DecimalType:
+		return "rtl.Decimal"
+	case er.EnumType:
+		return GoName(a.Enum.Name)
 	}
 	return "?"
 }
 
+func usesTime(t *er.EntityType) bool {
+	for _, a := range t.Attributes {
+		if valueType(a) == "time.Time" {
+			return true
+		}
+	}
+	return false
+}
+
 func columnType(a *er.Attribute) string {
 	var tn string
 	switch a.Type {
@@ -382,13 +977,51 @@ func columnType(a *er.Attribute) string {
 		tn = "rtl.Float64"
 	case er.StringType:
 		tn = "rtl.String"
+	case er.BoolType:
+		tn = "rtl.Bool"
+	case er.TimeType, er.DateType:
+		tn = "rtl.Time"
+	case er.BytesType:
+		tn = "rtl.Bytes"
+	case er.DecimalType:
+		tn = "rtl.Numeric"
+	case er.EnumType:
+		tn = "columnOf" + GoName(a.Enum.Name)
 	default:
 		return "?"
 	}
 	return tn
 }
 
-func attrParse(a *er.Attribute) string {
+// columnInit makes the column with the given ID for an attribute, whose value
+// in the row at idx is found by get.
+func columnInit(a *er.Attribute, id int, get string) string {
+	init := "Column"
+	if a.Identifying {
+		init = "Index"
+	}
+	collate := ""
+	if a.Collation != "" {
+		collate = ".Collate(" + collationVar(a) + ")"
+	}
+	ctor, typ, conv := columnType(a)+init, valueType(a), "%s"
+	if a.Type == er.EnumType {
+		ctor, typ, conv = "rtl.Int"+init, "int", "int(%s)"
+	}
+	val := fmt.Sprintf("return "+conv, get)
+	if a.Optional {
+		val = fmt.Sprintf("if p := %s; p != nil { v = "+conv+" }; return", get, "*p")
+		typ = "(v " + typ + ")"
+		collate += fmt.Sprintf(".Optional(func(idx int) bool { return %s == nil })", get)
+	}
+	col := fmt.Sprintf("%s(%d, func(idx int) %s { %s })%s", ctor, id, typ, val, collate)
+	if a.Type == er.EnumType {
+		col = columnType(a) + "{" + col + "}"
+	}
+	return col
+}
+
+func attrIO(a *er.Attribute) string {
 	var tn string
 	switch a.Type {
 	case er.IntType:
@@ -397,8 +1030,90 @@ func attrParse(a *er.Attribute) string {
 		tn = "FloatAttr"
 	case er.StringType:
 		tn = "StringAttr"
+	case er.BoolType:
+		tn = "BoolAttr"
+	case er.TimeType:
+		tn = "TimeAttr"
+	case er.DateType:
+		tn = "DateAttr"
+	case er.BytesType:
+		tn = "BytesAttr"
+	case er.DecimalType:
+		tn = "DecimalAttr"
 	default:
 		return "?"
 	}
 	return tn
 }
+
+// readAttr is a statement reading the attribute of e from the rtl.Reader p.
+func readAttr(a *er.Attribute) string {
+	val := fmt.Sprintf("p.%s()", attrIO(a))
+	if a.Type == er.EnumType {
+		val = fmt.Sprintf("%s(p.EnumAttr(valuesOf%[1]s))", GoName(a.Enum.Name))
+	}
+	if a.Optional {
+		return fmt.Sprintf("e.%s = new(%s); *e.%[1]s = %[3]s", GoName(a.Name), valueType(a), val)
+	}
+	return fmt.Sprintf("e.%s = %s", GoName(a.Name), val)
+}
+
+// writeAttr is a statement writing the attribute of e to the rtl.Writer w.
+// Optional attributes holding no value are left out.
+func writeAttr(a *er.Attribute) string {
+	val := "e." + GoName(a.Name)
+	if a.Optional {
+		val = "*" + val
+	}
+	res := fmt.Sprintf("w.%s(%q, %s)", attrIO(a), a.Name, val)
+	if a.Type == er.EnumType {
+		res = fmt.Sprintf("w.EnumAttr(%q, valuesOf%s, int(%s))", a.Name, GoName(a.Enum.Name), val)
+	}
+	if a.Optional {
+		return fmt.Sprintf("if e.%s != nil { %s }", GoName(a.Name), res)
+	}
+	return res
+}
+
+func compareFunc(a *er.Attribute) string {
+	if a.Optional {
+		return "compareOf" + GoName(a.Owner.Name) + GoName(a.Name)
+	}
+	return valueCompareFunc(a)
+}
+
+func valueCompareFunc(a *er.Attribute) string {
+	if a.Type == er.EnumType {
+		return "compare" + GoName(a.Enum.Name)
+	}
+	if a.Collation != "" {
+		return collationVar(a) + ".Compare"
+	}
+	if a.Type == er.DecimalType {
+		return "rtl.CompareDecimal"
+	}
+	return "rtl.Compare" + strings.TrimPrefix(columnType(a), "rtl.")
+}
+
+func collationVar(a *er.Attribute) string {
+	return "collationOf" + GoName(a.Owner.Name) + GoName(a.Name)
+}
+
+func key(t *er.EntityType) []*er.Attribute {
+	var res []*er.Attribute
+	for _, a := range t.Attributes {
+		if a.Identifying {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func attrIndex(t *er.EntityType, a *er.Attribute) int {
+	for i, b := range t.Attributes {
+		if a == b {
+			return i
+		}
+	}
+	return -1
+}
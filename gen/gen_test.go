@@ -1,6 +1,7 @@
 package gen
 
 import (
+	"bytes"
 	. "github.com/bobappleyard/er"
 	"github.com/bobappleyard/er/l2p"
 	"io/ioutil"
@@ -18,12 +19,39 @@ func TestGen(t *testing.T) {
 			{Name: "b"},
 			{Name: "c"},
 			{Name: "d"},
+			{Name: "e"},
+			{Name: "f"},
+			{Name: "g", Subtyping: Subtyping{Exclusive: true, Total: true}},
+			{Name: "h"},
+			{Name: "i"},
+			{Name: "j", Subtyping: Subtyping{Total: true, Mapping: SingleTable}},
+			{Name: "k"},
+			{Name: "q"},
+			{Name: "l", Subtyping: Subtyping{Exclusive: true, Mapping: ConcreteTypes}},
+			{Name: "n"},
+			{Name: "o"},
+			{Name: "p", Surrogate: SerialKey},
+			{Name: "r", Surrogate: UUID7Key},
+			{Name: "u"},
+			{Name: "v", Surrogate: SerialKey, Subtyping: Subtyping{Exclusive: true, Mapping: ConcreteTypes}},
+			{Name: "w"},
+			{Name: "x"},
+		},
+		Enums: []*Enum{
+			{Name: "status", Values: []string{"open", "on_hold", "closed"}},
 		},
 	}
 	a := m.Types[0]
 	b := m.Types[1]
 	c := m.Types[2]
 	d := m.Types[3]
+	e := m.Types[4]
+	f := m.Types[5]
+	g, h, i := m.Types[6], m.Types[7], m.Types[8]
+	j, k, q := m.Types[9], m.Types[10], m.Types[11]
+	l, n, o := m.Types[12], m.Types[13], m.Types[14]
+	p, r, u := m.Types[15], m.Types[16], m.Types[17]
+	v, w, x := m.Types[18], m.Types[19], m.Types[20]
 	for _, t := range m.Types {
 		t.Attributes = []*Attribute{
 			{
@@ -34,6 +62,75 @@ func TestGen(t *testing.T) {
 			},
 		}
 	}
+	e.Attributes[0].Collation = "nocase"
+	h.Supertype, i.Supertype = g, g
+	h.Attributes = []*Attribute{{Owner: h, Name: "wheels", Type: IntType}}
+	i.Attributes = []*Attribute{{Owner: i, Name: "sails", Type: IntType}}
+	k.Supertype, q.Supertype = j, j
+	k.Attributes = []*Attribute{{Owner: k, Name: "depth", Type: FloatType}}
+	q.Attributes = []*Attribute{{Owner: q, Name: "width", Type: FloatType}}
+	n.Supertype, o.Supertype = l, l
+	l.Attributes = append(l.Attributes, &Attribute{Owner: l, Name: "colour", Type: StringType})
+	n.Attributes = []*Attribute{{Owner: n, Name: "corners", Type: IntType}}
+	o.Attributes = []*Attribute{
+		{Owner: o, Name: "radius", Type: FloatType},
+		{Owner: o, Name: "edition", Type: IntType, Identifying: true},
+	}
+	p.Attributes = []*Attribute{{Owner: p, Name: "title", Type: StringType}}
+	w.Supertype, x.Supertype = v, v
+	v.Attributes = []*Attribute{{Owner: v, Name: "label", Type: StringType}}
+	w.Attributes = []*Attribute{{Owner: w, Name: "legs", Type: IntType}}
+	x.Attributes = []*Attribute{{Owner: x, Name: "wings", Type: IntType}}
+	r.Attributes = nil
+	r.Relationships = []*Relationship{{Name: "about", Source: r, Target: p}}
+	e.Attributes = append(e.Attributes,
+		&Attribute{Owner: e, Name: "size", Type: IntType},
+		&Attribute{Owner: e, Name: "weight", Type: FloatType},
+		&Attribute{Owner: e, Name: "label", Type: StringType, Collation: "sv"},
+		&Attribute{Owner: e, Name: "flag", Type: BoolType},
+		&Attribute{Owner: e, Name: "born", Type: DateType},
+		&Attribute{Owner: e, Name: "at", Type: TimeType},
+		&Attribute{Owner: e, Name: "blob", Type: BytesType},
+		&Attribute{Owner: e, Name: "price", Type: DecimalType},
+		&Attribute{Owner: e, Name: "status", Type: EnumType, Enum: m.Enums[0]},
+		&Attribute{Owner: e, Name: "note", Type: StringType, Optional: true},
+		&Attribute{Owner: e, Name: "score", Type: IntType, Optional: true},
+		&Attribute{Owner: e, Name: "mood", Type: EnumType, Enum: m.Enums[0], Optional: true},
+		&Attribute{Owner: e, Name: "rank", Type: IntType, Min: limit(0), Max: limit(10)},
+		&Attribute{Owner: e, Name: "ratio", Type: FloatType, Max: limit(1.5)},
+		&Attribute{Owner: e, Name: "code", Type: StringType, Optional: true, NonEmpty: true, MaxLength: 4, Pattern: "[A-Z]+"},
+	)
+	u.Attributes = append(u.Attributes,
+		&Attribute{Owner: u, Name: "number", Type: IntType, Default: "sequence()"},
+		&Attribute{Owner: u, Name: "size", Type: IntType, Default: "3"},
+		&Attribute{Owner: u, Name: "weight", Type: FloatType, Default: "2"},
+		&Attribute{Owner: u, Name: "label", Type: StringType, Default: "none"},
+		&Attribute{Owner: u, Name: "flag", Type: BoolType, Default: "true"},
+		&Attribute{Owner: u, Name: "born", Type: DateType, Default: "2020-02-01"},
+		&Attribute{Owner: u, Name: "opened", Type: DateType, Default: "now()"},
+		&Attribute{Owner: u, Name: "at", Type: TimeType, Default: "now()"},
+		&Attribute{Owner: u, Name: "blob", Type: BytesType, Default: "0x00ff"},
+		&Attribute{Owner: u, Name: "price", Type: DecimalType, Default: "1.50"},
+		&Attribute{Owner: u, Name: "status", Type: EnumType, Enum: m.Enums[0], Default: "on_hold"},
+		&Attribute{Owner: u, Name: "note", Type: StringType, Optional: true, Default: "blank"},
+		&Attribute{Owner: u, Name: "due", Type: TimeType, Optional: true, Default: "2020-02-01T12:30:00Z"},
+	)
+	b.Attributes = append(b.Attributes, &Attribute{Owner: b, Name: "code", Type: StringType, Optional: true})
+	b.Indexes = []*Index{
+		{Name: "by_code", AttributeNames: []string{"code"}, Unique: true},
+	}
+	f.Relationships = []*Relationship{
+		{
+			Name:   "owner",
+			Source: f,
+			Target: b,
+			Key:    b.Indexes[0],
+		},
+	}
+	e.Indexes = []*Index{
+		{Name: "by_label", AttributeNames: []string{"label"}},
+		{Name: "by_score", AttributeNames: []string{"score"}},
+	}
 	a.Relationships = []*Relationship{
 		{
 			Name:   "s",
@@ -72,15 +169,49 @@ func TestGen(t *testing.T) {
 			}},
 		},
 	}
+	c.Derived = []*DerivedAttribute{
+		{
+			Name:      "s_name",
+			Path:      []Component{{Rel: c.Relationships[0]}, {Rel: a.Relationships[0]}},
+			Attribute: b.Attributes[0],
+		},
+		{
+			Name:      "s_code",
+			Path:      []Component{{Rel: c.Relationships[0]}, {Rel: a.Relationships[0]}},
+			Attribute: b.Attributes[1],
+		},
+	}
+	a.Derived = []*DerivedAttribute{{Name: "children", Count: c.Relationships[0]}}
+	b.Derived = []*DerivedAttribute{{Name: "owned", Count: f.Relationships[0]}}
 	c.DependsOn = c.Relationships[0]
 	d.DependsOn = d.Relationships[0]
 
-	l2p.LogicalToPhysical(m)
+	m.Description = "Package square is generated for the tests."
+	m.Enums[0].Description = "Status says how far along e is."
+	b.Description = "B is a thing with a code.\n\nIts code is optional."
+	b.Attributes[1].Description = "Code is unique where given."
+	c.Relationships[0].Description = "Parent finds the a that c belongs to."
+	b.Indexes[0].Description = "FindByCode looks up a b by its code."
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
 	bs, err := generate(m)
 	if err != nil {
 		t.Error(err)
 		return
 	}
+	for _, doc := range []string{
+		"// Package square is generated for the tests.\npackage square\n",
+		"// Status says how far along e is.\ntype Status int\n",
+		"// B is a thing with a code.\n//\n// Its code is optional.\ntype B struct {\n",
+		"\t// Code is unique where given.\n\tCode *string\n",
+		"// Parent finds the a that c belongs to.\nfunc (e C) Parent() A {\n",
+		"// FindByCode looks up a b by its code.\nfunc (s setOfB) FindByCode(",
+	} {
+		if !bytes.Contains(bs, []byte(doc)) {
+			t.Errorf("missing %q", doc)
+		}
+	}
 	ioutil.WriteFile(path.Join("test", "pkg.go"), bs, 0777)
 	cmd := exec.Command("go", "test", "./test")
 	cmd.Stdout = os.Stdout
@@ -90,3 +221,7 @@ func TestGen(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func limit(v float64) *float64 {
+	return &v
+}
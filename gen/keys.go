@@ -0,0 +1,148 @@
+package gen
+
+import (
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+func (g *generator) generateKeys(t *er.EntityType) error {
+	g.generateCounters(t)
+	a := generatedKey(t)
+	if a == nil {
+		return nil
+	}
+	g.out("// newKey makes a key for an entity inserted without one.")
+	g.out("func (s *setOf%s) newKey() %s {", GoName(t.Name), valueType(a))
+	switch a.Generated {
+	case er.SerialKey:
+		g.out("return s.next%s()", GoName(a.Name))
+	case er.UUID4Key:
+		g.out("return rtl.NewUUID4()")
+	case er.UUID7Key:
+		g.out("return rtl.NewUUID7()")
+	default:
+		return er.ErrInvalidAttribute
+	}
+	g.out("}")
+	g.out("")
+
+	g.out("// InsertNew inserts e, giving it a new %s if it has none, and returns it as", a.Name)
+	g.out("// inserted.")
+	g.out("func (s *setOf%s) InsertNew(e %[1]s) (%[1]s, error) {", GoName(t.Name))
+	g.out("%s", assignKey(a))
+	if hasInsertDefaults(t) {
+		g.out("s.applyDefaults(&e)")
+	}
+	g.out("if err := s.Insert(e); err != nil { return e, err }")
+	g.out("e.model = s.model")
+	g.out("return e, nil")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+// generateCounters emits, for each attribute numbered from a counter, a
+// method giving the next number. The counter is the highest number stored so
+// far, which writeRow raises and nothing lowers, so that numbers are not issued
+// again once their entities are deleted. It reaches the log through the
+// entities holding the numbers, and snapshots through marshalCounters.
+func (g *generator) generateCounters(t *er.EntityType) {
+	cs := counters(t)
+	if len(cs) == 0 {
+		return
+	}
+	for _, a := range cs {
+		g.out("// next%s is one more than the highest %s stored so far.", GoName(a.Name), a.Name)
+		g.out("func (s setOf%s) next%s() int {", GoName(t.Name), GoName(a.Name))
+		g.out("n := s.model.%s.last%s", GoName(t.Name), GoName(a.Name))
+		for _, sib := range counterSiblings(t, a) {
+			g.out("if m := s.model.%s.last%s; m > n { n = m }", GoName(sib.Name), GoName(a.Name))
+		}
+		g.out("return n + 1")
+		g.out("}")
+		g.out("")
+	}
+
+	g.out("func (s *setOf%s) raiseCounters(d attrsOf%[1]s) {", GoName(t.Name))
+	for _, a := range cs {
+		if a.Optional {
+			g.out("if d.%s != nil && *d.%[1]s > s.last%[1]s { s.last%[1]s = *d.%[1]s }", GoName(a.Name))
+		} else {
+			g.out("if d.%s > s.last%[1]s { s.last%[1]s = d.%[1]s }", GoName(a.Name))
+		}
+	}
+	g.out("}")
+	g.out("")
+
+	g.out("func (s setOf%s) marshalCounters(w *rtl.Writer) {", GoName(t.Name))
+	g.out("w.Begin(%q)", t.Name)
+	for _, a := range cs {
+		g.out("w.IntAttr(%q, s.last%s)", a.Name, GoName(a.Name))
+	}
+	g.out("w.End()")
+	g.out("}")
+	g.out("")
+
+	g.out("func (s *setOf%s) parseCounters(p *rtl.Reader) {", GoName(t.Name))
+	g.out("for p.Next() { switch p.Name() {")
+	for _, a := range cs {
+		g.out("case %q: if n := p.IntAttr(); n > s.last%s { s.last%[2]s = n }", a.Name, GoName(a.Name))
+	}
+	g.out("default: p.SetErr(er.ErrInvalidAttribute)")
+	g.out("}}")
+	g.out("}")
+	g.out("")
+}
+
+// counters lists the attributes of t numbered from a counter: serial keys and
+// attributes defaulting to sequence().
+func counters(t *er.EntityType) []*er.Attribute {
+	var res []*er.Attribute
+	for _, a := range t.Attributes {
+		if a.Generated == er.SerialKey || a.Default == rtl.Sequence {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// counterSiblings lists the other subtypes in an exclusive ConcreteTypes
+// hierarchy that number an attribute of the same name as a. These share a
+// counter, so that sibling entities are not given the same key.
+func counterSiblings(t *er.EntityType, a *er.Attribute) []*er.EntityType {
+	p := t.Supertype
+	if p == nil || p.Subtyping.Mapping != er.ConcreteTypes || !p.Subtyping.Exclusive {
+		return nil
+	}
+	var res []*er.EntityType
+	for _, sib := range p.Subtypes {
+		if sib == t {
+			continue
+		}
+		for _, b := range counters(sib) {
+			if b.Name == a.Name {
+				res = append(res, sib)
+			}
+		}
+	}
+	return res
+}
+
+// generatedKey finds the surrogate key of t, or nil if it has none.
+func generatedKey(t *er.EntityType) *er.Attribute {
+	for _, a := range t.Attributes {
+		if a.Generated != er.NoKey {
+			return a
+		}
+	}
+	return nil
+}
+
+// assignKey is a statement giving e a new key if it lacks one.
+func assignKey(a *er.Attribute) string {
+	zero := `""`
+	if a.Type == er.IntType {
+		zero = "0"
+	}
+	return "if e." + GoName(a.Name) + " == " + zero + " { e." + GoName(a.Name) + " = s.newKey() }"
+}
@@ -0,0 +1,89 @@
+package gen
+
+import (
+	"fmt"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+func (g *generator) generateRules(t *er.EntityType) error {
+	for _, a := range t.Attributes {
+		if err := rtl.ValidateRules(a); err != nil {
+			return err
+		}
+		if a.Pattern != "" {
+			g.out("var %s = rtl.MustPattern(%q)", patternVar(a), a.Pattern)
+			g.out("")
+		}
+	}
+	g.out("// check tests the entity's attributes against their rules.")
+	g.out("func (e %s) check() error {", GoName(t.Name))
+	for _, a := range t.Attributes {
+		if !hasRules(a) {
+			continue
+		}
+		if a.Optional {
+			g.out("if e.%s != nil {", GoName(a.Name))
+			g.out("v := *e.%s", GoName(a.Name))
+		} else {
+			g.out("{")
+			g.out("v := e.%s", GoName(a.Name))
+		}
+		length := "len(v)"
+		if a.Type == er.StringType {
+			length = "utf8.RuneCountInString(v)"
+		}
+		if a.Min != nil {
+			g.rule(a, fmt.Sprintf("v < %s", bound(a, *a.Min)), fmt.Sprintf("min %v", *a.Min))
+		}
+		if a.Max != nil {
+			g.rule(a, fmt.Sprintf("v > %s", bound(a, *a.Max)), fmt.Sprintf("max %v", *a.Max))
+		}
+		if a.NonEmpty {
+			g.rule(a, "len(v) == 0", "non_empty")
+		}
+		if a.MaxLength != 0 {
+			g.rule(a, fmt.Sprintf("%s > %d", length, a.MaxLength), fmt.Sprintf("max_length %d", a.MaxLength))
+		}
+		if a.Pattern != "" {
+			g.rule(a, fmt.Sprintf("!%s.Match(v)", patternVar(a)), fmt.Sprintf("pattern %q", a.Pattern))
+		}
+		g.out("}")
+	}
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+// rule returns an error from check if the value v of the attribute breaks the
+// rule, that is if cond holds.
+func (g *generator) rule(a *er.Attribute, cond, rule string) {
+	g.out("if %s { return rtl.BrokenRule(%q, %q) }", cond, a.String(), rule)
+}
+
+func hasRules(a *er.Attribute) bool {
+	return a.Min != nil || a.Max != nil || a.Pattern != "" || a.MaxLength != 0 || a.NonEmpty
+}
+
+// bound writes a limit on the attribute's values as a Go constant.
+func bound(a *er.Attribute, b float64) string {
+	if a.Type == er.IntType {
+		return fmt.Sprintf("%d", int64(b))
+	}
+	return fmt.Sprintf("%v", b)
+}
+
+func usesLength(t *er.EntityType) bool {
+	for _, a := range t.Attributes {
+		if a.Type == er.StringType && a.MaxLength != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func patternVar(a *er.Attribute) string {
+	return "patternOf" + GoName(a.Owner.Name) + GoName(a.Name)
+}
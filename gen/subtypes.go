@@ -0,0 +1,148 @@
+package gen
+
+import (
+	"strings"
+
+	"github.com/bobappleyard/er"
+)
+
+// generateSubtypes gives the types in a hierarchy an interface that their
+// subtypes implement, so that an entity's subtypes can be told apart with a
+// type switch. Supertypes that remain in the model can find their subtypes.
+// Single table hierarchies have no types of their own, and are told apart by
+// the attributes recording their subtypes.
+func (g *generator) generateSubtypes(t *er.EntityType) error {
+	if p := t.Supertype; p != nil && (p.Subtyping.Mapping == er.SharedKey || p.Subtyping.Mapping == er.ConcreteTypes) {
+		if p.Subtyping.Mapping == er.ConcreteTypes && t == p.Subtypes[0] {
+			g.out("// %s is implemented by each of its subtypes.", GoName(p.Name))
+			g.out("type %s interface {", GoName(p.Name))
+			g.out("subtypeOf%s()", GoName(p.Name))
+			g.out("}")
+			g.out("")
+		}
+		g.out("func (%s) subtypeOf%s() {}", GoName(t.Name), GoName(p.Name))
+		g.out("")
+	}
+	if len(t.Subtypes) == 0 || t.Subtyping.Mapping != er.SharedKey {
+		return g.generateSubtypeChecks(t)
+	}
+
+	g.out("// %sSubtype is implemented by each of the subtypes of %[1]s.", GoName(t.Name))
+	g.out("type %sSubtype interface {", GoName(t.Name))
+	g.out("subtypeOf%s()", GoName(t.Name))
+	g.out("}")
+	g.out("")
+
+	g.out("// Subtypes finds the entities that specialise e.")
+	g.out("func (e %s) Subtypes() []%[1]sSubtype {", GoName(t.Name))
+	g.out("var res []%sSubtype", GoName(t.Name))
+	for _, s := range t.Subtypes {
+		r := s.SupertypeRelationship()
+		if r == nil {
+			return er.ErrInvalidRecord
+		}
+		g.out("{")
+		g.out("var q rtl.Query")
+		for _, k := range r.Implementation {
+			if len(k.BasePath) != 0 {
+				return er.ErrInvalidRecord
+			}
+			g.out("q = q.And(e.model.%s.%s.Eq(e.%s))", GoName(s.Name), GoName(k.Source.Name), GoName(k.Target.Name))
+		}
+		g.out("e.model.%s.Where(q).ForEach(func(x %[1]s) error {", GoName(s.Name))
+		g.out("res = append(res, x)")
+		g.out("return nil")
+		g.out("})")
+		g.out("}")
+	}
+	g.out("return res")
+	g.out("}")
+	g.out("")
+
+	if t.Subtyping.Exclusive {
+		g.out("// Subtype finds the entity that specialises e, or nil if there is none.")
+		g.out("func (e %s) Subtype() %[1]sSubtype {", GoName(t.Name))
+		g.out("if s := e.Subtypes(); len(s) != 0 { return s[0] }")
+		g.out("return nil")
+		g.out("}")
+		g.out("")
+	}
+	return g.generateSubtypeChecks(t)
+}
+
+// generateSubtypeChecks emits checkSubtypes, which makes sure that e has as
+// many subtypes as its hierarchy allows.
+func (g *generator) generateSubtypeChecks(t *er.EntityType) error {
+	if !hasSubtypeChecks(t) {
+		return nil
+	}
+	g.out("func (s setOf%s) checkSubtypes(e %[1]s) error {", GoName(t.Name))
+	if p := t.Supertype; p != nil && p.Subtyping.Mapping == er.ConcreteTypes && p.Subtyping.Exclusive {
+		for _, sib := range p.Subtypes {
+			if sib == t {
+				continue
+			}
+			g.out("{")
+			g.out("var q rtl.Query")
+			for _, a := range sharedKey(t, sib) {
+				g.out("q = q.And(s.model.%s.%s.Eq(e.%[2]s))", GoName(sib.Name), GoName(a.Name))
+			}
+			g.out("if s.model.%s.Where(q).Count() != 0 { return er.ErrDuplicateKey }", GoName(sib.Name))
+			g.out("}")
+		}
+	}
+	switch {
+	case len(t.Subtypes) == 0:
+	case t.Subtyping.Mapping == er.SharedKey:
+		g.out("n := len(e.Subtypes())")
+		if t.Subtyping.Exclusive {
+			g.out("if n > 1 { return er.ErrDuplicateKey }")
+		}
+		if t.Subtyping.Total {
+			g.out("if n == 0 { return er.ErrMissingEntity }")
+		}
+	case t.Subtyping.Mapping == er.SingleTable && t.Subtyping.Total && !t.Subtyping.Exclusive:
+		var none []string
+		for _, sub := range t.Subtypes {
+			none = append(none, "!e."+GoName("is_"+sub.Name))
+		}
+		g.out("if %s { return er.ErrMissingEntity }", strings.Join(none, " && "))
+	}
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+	return nil
+}
+
+// sharedKey lists the attributes of the key of t that sib also has in its key,
+// which are those copied from their supertype.
+func sharedKey(t, sib *er.EntityType) []*er.Attribute {
+	var res []*er.Attribute
+	for _, a := range key(t) {
+		for _, b := range key(sib) {
+			if a.Name == b.Name {
+				res = append(res, a)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// hasSubtypeChecks reports whether the hierarchies t belongs to restrict the
+// subtypes of its entities beyond what the implementation ensures.
+func hasSubtypeChecks(t *er.EntityType) bool {
+	if p := t.Supertype; p != nil && p.Subtyping.Mapping == er.ConcreteTypes && p.Subtyping.Exclusive {
+		return true
+	}
+	if len(t.Subtypes) == 0 {
+		return false
+	}
+	switch t.Subtyping.Mapping {
+	case er.SharedKey:
+		return t.Subtyping.Exclusive || t.Subtyping.Total
+	case er.SingleTable:
+		return t.Subtyping.Total && !t.Subtyping.Exclusive
+	}
+	return false
+}
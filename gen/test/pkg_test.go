@@ -1,7 +1,18 @@
 package square
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
 )
 
 type cIter interface {
@@ -52,6 +63,17 @@ func TestModelCRUD(t *testing.T) {
 		{Name: "C1", ParentName: "A1", FName: "D1"},
 		{Name: "C2", ParentName: "A1", FName: "D2"},
 	}))
+	t.Run("SelectNot", assertEntries(m.C.Where(rtl.Not(m.C.FName.Eq("D2"))), []C{
+		{Name: "C1", ParentName: "A1", FName: "D1"},
+	}))
+	t.Run("SelectIn", assertEntries(m.C.Where(m.C.Name.In("C2", "C9")), []C{
+		{Name: "C2", ParentName: "A1", FName: "D2"},
+	}))
+	t.Run("SelectPredicate", assertEntries(m.C.Where(m.C.Predicate(func(c C) bool {
+		return c.F().Name == "D2"
+	})), []C{
+		{Name: "C2", ParentName: "A1", FName: "D2"},
+	}))
 	t.Run("Select2", assertEntries(m.C.Where(m.C.ParentName.Eq("A1").And(m.C.FName.Eq("D2"))), []C{
 		{Name: "C2", ParentName: "A1", FName: "D2"},
 	}))
@@ -109,6 +131,17 @@ func TestModelParse(t *testing.T) {
 		t.Errorf("validation failed: %v", err)
 	}
 	assertEntries(m.C, []C{{Name: "C1", ParentName: "A1", FName: "D1"}})
+
+	// mandatory attributes left out were once read as their zero values
+	if err := m.Unmarshal([]byte(`a { name: "A2" }`)); !errors.Is(err, er.ErrMissingAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingAttribute)
+	}
+	if err := m.Unmarshal([]byte(`a { name: "A2" s_name: "" }`)); err != nil {
+		t.Errorf("got %v with the zero value given", err)
+	}
+	if err := m.Unmarshal([]byte(`a { name: "A3" s_name: "B1" colour: "red" }`)); !errors.Is(err, er.ErrInvalidAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
 }
 
 func assertEntries(s cIter, es []C) func(*testing.T) {
@@ -130,3 +163,1115 @@ func assertEntries(s cIter, es []C) func(*testing.T) {
 		}
 	}
 }
+
+func TestModelChanges(t *testing.T) {
+	m := New()
+	var got []Change
+	cancel := m.OnChange(func(c Change) {
+		got = append(got, c)
+	})
+
+	m.B.Insert(B{Name: "B1"})
+	m.B.Insert(B{Name: "B1"})
+	m.A.Insert(A{Name: "A1", SName: "B1"})
+	m.A.Update(A{Name: "A1", SName: "B2"})
+	m.A.Upsert(A{Name: "A2", SName: "B1"})
+	m.A.Delete(A{Name: "A1"})
+	cancel()
+	m.A.Delete(A{Name: "A2"})
+
+	expected := []Change{
+		ChangeOfB{Op: rtl.Inserted, After: B{Name: "B1"}},
+		ChangeOfA{Op: rtl.Inserted, After: A{Name: "A1", SName: "B1"}},
+		ChangeOfA{Op: rtl.Updated, Before: A{Name: "A1", SName: "B1"}, After: A{Name: "A1", SName: "B2"}},
+		ChangeOfA{Op: rtl.Inserted, After: A{Name: "A2", SName: "B1"}},
+		ChangeOfA{Op: rtl.Deleted, Before: A{Name: "A1", SName: "B2"}},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("got %d changes, expecting %d", len(got), len(expected))
+	}
+	for i, c := range got {
+		if stripModel(c) != expected[i] {
+			t.Errorf("[%d] got %v, expecting %v", i, c, expected[i])
+		}
+	}
+	if m.A.Count() != 0 {
+		t.Errorf("got %d entities after delete, expecting 0", m.A.Count())
+	}
+}
+
+func TestModelChangesCancel(t *testing.T) {
+	m := New()
+	var got []string
+	keep := m.OnChange(func(c Change) { got = append(got, "keep") })
+	for i := 0; i < 100; i++ {
+		cancel := m.OnChange(func(c Change) { got = append(got, "cancelled") })
+		cancel()
+		cancel()
+	}
+	if len(m.observers) != 1 {
+		t.Errorf("got %d observers, expecting 1", len(m.observers))
+	}
+	var cancelSelf func()
+	cancelSelf = m.OnChange(func(c Change) {
+		got = append(got, "self")
+		cancelSelf()
+	})
+	m.OnChange(func(c Change) { got = append(got, "last") })
+	m.B.Insert(B{Name: "B1"})
+	keep()
+	m.B.Insert(B{Name: "B2"})
+	if !reflect.DeepEqual(got, []string{"keep", "self", "last", "last"}) {
+		t.Errorf("got calls %v", got)
+	}
+	if len(m.observers) != 1 {
+		t.Errorf("got %d observers, expecting 1", len(m.observers))
+	}
+}
+
+func stripModel(c Change) Change {
+	switch c := c.(type) {
+	case ChangeOfA:
+		c.Before.model, c.After.model = nil, nil
+		return c
+	case ChangeOfB:
+		c.Before.model, c.After.model = nil, nil
+		return c
+	}
+	return c
+}
+
+func TestModelMarshal(t *testing.T) {
+	m := New()
+	m.A.Insert(A{Name: "A1", SName: "B1"})
+	m.B.Insert(B{Name: "B1"})
+	m.C.Insert(C{Name: "C1", ParentName: "A1", FName: "D1"})
+	m.C.Insert(C{Name: "C2", ParentName: "A1", FName: "D1"})
+	m.D.Insert(D{Name: "D1", ParentName: "B1"})
+	bs, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New()
+	if err := n.Unmarshal(bs); err != nil {
+		t.Fatalf("unmarshal failed: %v\n%s", err, bs)
+	}
+	if err := n.Validate(); err != nil {
+		t.Errorf("validation failed: %v", err)
+	}
+	t.Run("C", assertEntries(n.C, []C{
+		{Name: "C1", ParentName: "A1", FName: "D1"},
+		{Name: "C2", ParentName: "A1", FName: "D1"},
+	}))
+}
+
+func TestModelLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.A.Insert(A{Name: "A1", SName: "B1"})
+	m.C.Insert(C{Name: "C1", ParentName: "A1", FName: "D1"})
+	m.C.Insert(C{Name: "C2", ParentName: "A1", FName: "D1"})
+	m.C.Update(C{Name: "C2", ParentName: "A1", FName: "D2"})
+	m.C.Delete(C{Name: "C1", ParentName: "A1"})
+	m.Close()
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Replay", assertEntries(m.C, []C{
+		{Name: "C2", ParentName: "A1", FName: "D2"},
+	}))
+
+	if err := m.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	m.C.Insert(C{Name: "C3", ParentName: "A1", FName: "D1"})
+	m.Close()
+
+	// simulate a crash part way through appending an entry
+	f, err := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`insert { c { name: "C4" parent_na`)
+	f.Close()
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Truncated", assertEntries(m.C, []C{
+		{Name: "C2", ParentName: "A1", FName: "D2"},
+		{Name: "C3", ParentName: "A1", FName: "D1"},
+	}))
+	m.C.Insert(C{Name: "C5", ParentName: "A1", FName: "D1"})
+	m.P.InsertNew(P{Title: "first"})
+	m.P.InsertNew(P{Title: "second"})
+	m.P.Delete(P{Id: 2})
+	m.Close()
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("AfterTruncation", assertEntries(m.C, []C{
+		{Name: "C2", ParentName: "A1", FName: "D2"},
+		{Name: "C3", ParentName: "A1", FName: "D1"},
+		{Name: "C5", ParentName: "A1", FName: "D1"},
+	}))
+	if p, err := m.P.InsertNew(P{Title: "third"}); err != nil || p.Id != 3 {
+		t.Errorf("got %v, %v after replaying", p, err)
+	}
+	m.P.Delete(P{Id: 3})
+	if err := m.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if p, err := m.P.InsertNew(P{Title: "fourth"}); err != nil || p.Id != 4 {
+		t.Errorf("got %v, %v after compacting", p, err)
+	}
+}
+
+func TestModelCompactCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+
+	m, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.B.Insert(B{Name: "B1", Code: strPtr("x")})
+	m.B.Update(B{Name: "B1"})
+	m.B.Insert(B{Name: "B2", Code: strPtr("x")})
+	m.Close()
+	old, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	// simulate a crash after the snapshot is renamed into place but before the
+	// log is emptied
+	if err := os.WriteFile(path+".log", old, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if b, ok := m.B.FindByCode("x"); !ok || b.Name != "B2" || m.B.Count() != 2 {
+		t.Errorf("got %v in %d entities", b, m.B.Count())
+	}
+}
+
+func TestModelDiff(t *testing.T) {
+	m := New()
+	m.C.Insert(C{Name: "C1", ParentName: "A1", FName: "D1"})
+	m.C.Insert(C{Name: "C2", ParentName: "A1", FName: "D1"})
+	m.C.Insert(C{Name: "C3", ParentName: "A1", FName: "D1"})
+
+	n := New()
+	n.C.Insert(C{Name: "C0", ParentName: "A1", FName: "D1"})
+	n.C.Insert(C{Name: "C2", ParentName: "A1", FName: "D2"})
+	n.C.Insert(C{Name: "C3", ParentName: "A1", FName: "D1"})
+	n.C.Insert(C{Name: "C4", ParentName: "A1", FName: "D1"})
+
+	d := m.Diff(n)
+	names := func(es []C) (res []string) {
+		for _, e := range es {
+			res = append(res, e.Name)
+		}
+		return res
+	}
+	if got := names(d.C.Added); !reflect.DeepEqual(got, []string{"C0", "C4"}) {
+		t.Errorf("got added %v", got)
+	}
+	if got := names(d.C.Removed); !reflect.DeepEqual(got, []string{"C1"}) {
+		t.Errorf("got removed %v", got)
+	}
+	if len(d.C.Changed) != 1 || d.C.Changed[0].Before.FName != "D1" || d.C.Changed[0].After.FName != "D2" {
+		t.Errorf("got changed %v", d.C.Changed)
+	}
+	if len(d.A.Added)+len(d.A.Removed)+len(d.A.Changed) != 0 {
+		t.Errorf("got spurious changes %v", d.A)
+	}
+
+	if err := m.Apply(d); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Apply", assertEntries(m.C, []C{
+		{Name: "C0", ParentName: "A1", FName: "D1"},
+		{Name: "C2", ParentName: "A1", FName: "D2"},
+		{Name: "C3", ParentName: "A1", FName: "D1"},
+		{Name: "C4", ParentName: "A1", FName: "D1"},
+	}))
+	d = m.Diff(n)
+	if len(d.C.Added)+len(d.C.Removed)+len(d.C.Changed) != 0 {
+		t.Errorf("got changes %v after applying diff", d.C)
+	}
+}
+
+func TestModelMerge(t *testing.T) {
+	base := New()
+	base.A.Insert(A{Name: "A1", SName: "B1"})
+	base.B.Insert(B{Name: "B1"})
+	base.B.Insert(B{Name: "B2"})
+	base.D.Insert(D{Name: "D1", ParentName: "B1"})
+	base.D.Insert(D{Name: "D2", ParentName: "B1"})
+	base.C.Insert(C{Name: "C1", ParentName: "A1", FName: "D1"})
+	base.C.Insert(C{Name: "C2", ParentName: "A1", FName: "D1"})
+	base.C.Insert(C{Name: "C3", ParentName: "A1", FName: "D1"})
+
+	clone := func() *Model {
+		m := New()
+		m.Apply(New().Diff(base))
+		return m
+	}
+	ours, theirs := clone(), clone()
+	ours.C.Update(C{Name: "C1", ParentName: "A1", FName: "D2"})
+	ours.C.Insert(C{Name: "C4", ParentName: "A1", FName: "D1"})
+	ours.C.Update(C{Name: "C3", ParentName: "A1", FName: "D2"})
+	theirs.C.Delete(C{Name: "C2", ParentName: "A1"})
+	theirs.C.Insert(C{Name: "C5", ParentName: "A1", FName: "D2"})
+	theirs.C.Delete(C{Name: "C3", ParentName: "A1"})
+
+	m, cs, err := Merge(base, ours, theirs)
+	if err != nil {
+		t.Errorf("validation failed: %v", err)
+	}
+	t.Run("Clean", assertEntries(m.C, []C{
+		{Name: "C1", ParentName: "A1", FName: "D2"},
+		{Name: "C3", ParentName: "A1", FName: "D2"},
+		{Name: "C4", ParentName: "A1", FName: "D1"},
+		{Name: "C5", ParentName: "A1", FName: "D2"},
+	}))
+	if len(cs) != 1 || cs[0].Type != "c" || cs[0].Attribute != "" || cs[0].Theirs != nil {
+		t.Errorf("got conflicts %v, expecting delete/update of C3", cs)
+	}
+
+	ours, theirs = clone(), clone()
+	ours.C.Update(C{Name: "C1", ParentName: "A1", FName: "D2"})
+	theirs.C.Update(C{Name: "C1", ParentName: "A1", FName: "D3"})
+	theirs.A.Update(A{Name: "A1", SName: "B2"})
+
+	m, cs, err = Merge(base, ours, theirs)
+	if len(cs) != 1 || cs[0].Attribute != "f_name" {
+		t.Errorf("got conflicts %v, expecting c.f_name", cs)
+	}
+	if m.A.ExactlyOne().SName != "B2" {
+		t.Errorf("lost non-conflicting change to A1")
+	}
+	if err == nil {
+		t.Error("validation succeeded on broken merge")
+	}
+
+	ours, theirs = clone(), clone()
+	note := "note"
+	ours.E.Insert(E{Name: "E1", Note: &note, Blob: []byte("blob")})
+	theirs.E.Insert(E{Name: "E2", Note: &note, Blob: []byte("blob")})
+	m, _, _ = Merge(base, ours, theirs)
+	for _, x := range []*Model{ours, theirs} {
+		*x.E.rows[0].Note = "changed"
+		x.E.rows[0].Blob[0] = 'x'
+	}
+	m.E.ForEach(func(e E) error {
+		if *e.Note != "note" || string(e.Blob) != "blob" {
+			t.Errorf("%s: got note %q and blob %q after changing the inputs", e.Name, *e.Note, e.Blob)
+		}
+		return nil
+	})
+	if m.E.Count() != 2 {
+		t.Errorf("got %d merged entities, expecting 2", m.E.Count())
+	}
+}
+
+func TestModelIndexes(t *testing.T) {
+	m := New()
+	for i := 0; i < 100; i++ {
+		m.C.Insert(C{
+			Name:       fmt.Sprintf("C%03d", i),
+			ParentName: fmt.Sprintf("A%d", i%7),
+			FName:      fmt.Sprintf("D%d", i%3),
+		})
+	}
+	for i := 0; i < 100; i += 5 {
+		m.C.Delete(C{Name: fmt.Sprintf("C%03d", i)})
+	}
+	for i := 1; i < 100; i += 5 {
+		m.C.Update(C{Name: fmt.Sprintf("C%03d", i), ParentName: "A9", FName: "D9"})
+	}
+	var expected []C
+	m.C.ForEach(func(c C) error {
+		if c.ParentName == "A3" || c.FName == "D9" {
+			expected = append(expected, c)
+		}
+		return nil
+	})
+	t.Run("Parent", assertEntries(m.C.Where(m.C.ParentName.Eq("A3").Or(m.C.FName.Eq("D9"))), expected))
+	expected = expected[:0]
+	m.C.ForEach(func(c C) error {
+		if c.FName == "D9" {
+			expected = append(expected, c)
+		}
+		return nil
+	})
+	t.Run("F", assertEntries(m.C.Where(m.C.FName.Eq("D9")), expected))
+}
+
+func TestModelExplain(t *testing.T) {
+	m := New()
+	m.A.Insert(A{Name: "A1", SName: "B1"})
+	for i := 0; i < 10; i++ {
+		m.C.Insert(C{Name: fmt.Sprintf("C%d", i), ParentName: fmt.Sprintf("A%d", i%2), FName: "D1"})
+	}
+	for _, test := range []struct {
+		name, method, count string
+		s                   setOfC
+	}{
+		{"All", "full scan", "examines 10 of 10 rows", m.C},
+		{"Key", "key search", "examines 1 of 10 rows", m.C.Where(m.C.Name.Eq("C3"))},
+		{"Index", "index search", "examines 5 of 10 rows", m.C.Where(m.C.ParentName.Eq("A1"))},
+		{"Join", "join on index", "examines 5 of 10 rows", m.C.WhereParent(m.A.Name.Eq("A1"))},
+	} {
+		got := test.s.Explain()
+		if !strings.HasPrefix(got, test.method) || !strings.HasSuffix(got, test.count) {
+			t.Errorf("%s: got plan:\n%s\nexpecting %s that %s", test.name, got, test.method, test.count)
+		}
+	}
+}
+
+func TestModelPaging(t *testing.T) {
+	m := New()
+	for i := 0; i < 10; i++ {
+		m.C.Insert(C{Name: fmt.Sprintf("C%d", i), ParentName: fmt.Sprintf("A%d", i%2), FName: "D1"})
+	}
+	var all []C
+	m.C.ForEach(func(c C) error {
+		all = append(all, c)
+		return nil
+	})
+	t.Run("Limit", assertEntries(m.C.Limit(3), all[:3]))
+	t.Run("Offset", assertEntries(m.C.Offset(8), all[8:]))
+	t.Run("OffsetLimit", assertEntries(m.C.Offset(2).Limit(3), all[2:5]))
+	t.Run("LimitOffset", assertEntries(m.C.Limit(3).Offset(2), all[2:3]))
+	t.Run("OrderBy", assertEntries(m.C.OrderBy(m.C.Name, true).Limit(3), []C{
+		{Name: "C9", ParentName: "A1", FName: "D1"},
+		{Name: "C8", ParentName: "A0", FName: "D1"},
+		{Name: "C7", ParentName: "A1", FName: "D1"},
+	}))
+	t.Run("OrderByThen", assertEntries(m.C.OrderBy(m.C.ParentName, true).OrderBy(m.C.Name, false).Limit(2), []C{
+		{Name: "C1", ParentName: "A1", FName: "D1"},
+		{Name: "C3", ParentName: "A1", FName: "D1"},
+	}))
+	t.Run("After", func(t *testing.T) {
+		var got []C
+		page := m.C.Limit(3)
+		for {
+			n := 0
+			page.ForEach(func(c C) error {
+				got = append(got, c)
+				n++
+				return nil
+			})
+			if n == 0 {
+				break
+			}
+			page = m.C.After(got[len(got)-1]).Limit(3)
+		}
+		if len(got) != len(all) {
+			t.Fatalf("got %d entities, expecting %d", len(got), len(all))
+		}
+		for i := range got {
+			if got[i].Name != all[i].Name {
+				t.Errorf("[%d] got %v, expecting %v", i, got[i], all[i])
+			}
+		}
+	})
+	page := m.C.Limit(3)
+	if err := page.Insert(C{Name: "C10", ParentName: "A0", FName: "D1"}); err != er.ErrImmutableSet {
+		t.Errorf("got %v, expecting %v", err, er.ErrImmutableSet)
+	}
+}
+
+func TestModelAggregates(t *testing.T) {
+	m := New()
+	for i := 0; i < 10; i++ {
+		m.E.Insert(E{Name: fmt.Sprintf("E%d", i), Size: i % 3, Weight: float64(i) / 2})
+	}
+	if got := m.E.SumSize(); got != 9 {
+		t.Errorf("sum: got %d, expecting 9", got)
+	}
+	if got, ok := m.E.MaxWeight(); !ok || got != 4.5 {
+		t.Errorf("max: got %v, expecting 4.5", got)
+	}
+	if got, ok := m.E.Where(m.E.Size.Eq(2)).MinWeight(); !ok || got != 1 {
+		t.Errorf("min: got %v, expecting 1", got)
+	}
+	if got, ok := m.E.Where(m.E.Size.Eq(1)).AvgWeight(); !ok || got != 2 {
+		t.Errorf("avg: got %v, expecting 2", got)
+	}
+	if _, ok := m.E.Where(m.E.Size.Eq(3)).AvgSize(); ok {
+		t.Errorf("avg of no entities")
+	}
+	groups := m.E.GroupBy(m.E.Size)
+	if len(groups) != 3 {
+		t.Fatalf("got %d groups, expecting 3", len(groups))
+	}
+	for i, g := range groups {
+		size, _ := g.MinSize()
+		count := 3
+		if i == 0 {
+			count = 4
+		}
+		if size != i || g.Count() != count {
+			t.Errorf("group %d: got size %d and %d entities, expecting %d", i, size, g.Count(), count)
+		}
+	}
+	paged := m.E.OrderBy(m.E.Size, true).Offset(5).Limit(2).GroupBy(m.E.Size)
+	if len(paged) != 3 {
+		t.Fatalf("got %d paged groups, expecting 3", len(paged))
+	}
+	for i, g := range paged {
+		if g.Count() != groups[i].Count() {
+			t.Errorf("paged group %d: got %d entities, expecting %d", i, g.Count(), groups[i].Count())
+		}
+	}
+}
+
+func TestModelJoins(t *testing.T) {
+	m := New()
+	m.A.Insert(A{Name: "A1", SName: "B1"})
+	m.A.Insert(A{Name: "A2", SName: "B2"})
+	m.B.Insert(B{Name: "B1"})
+	m.B.Insert(B{Name: "B2"})
+	m.D.Insert(D{Name: "D1", ParentName: "B1"})
+	m.D.Insert(D{Name: "D1", ParentName: "B2"})
+	m.D.Insert(D{Name: "D2", ParentName: "B2"})
+	m.C.Insert(C{Name: "C1", ParentName: "A1", FName: "D1"})
+	m.C.Insert(C{Name: "C2", ParentName: "A2", FName: "D1"})
+	m.C.Insert(C{Name: "C3", ParentName: "A2", FName: "D2"})
+	t.Run("Parent", assertEntries(m.C.WhereParent(m.A.SName.Eq("B2")), []C{
+		{Name: "C2", ParentName: "A2", FName: "D1"},
+		{Name: "C3", ParentName: "A2", FName: "D2"},
+	}))
+	t.Run("Path", assertEntries(m.C.WhereF(m.D.ParentName.Eq("B1")), []C{
+		{Name: "C1", ParentName: "A1", FName: "D1"},
+	}))
+	t.Run("Or", assertEntries(m.C.Where(m.C.HasF(m.D.Name.Eq("D2")).Or(m.C.HasParent(m.A.Name.Eq("A1")))), []C{
+		{Name: "C1", ParentName: "A1", FName: "D1"},
+		{Name: "C3", ParentName: "A2", FName: "D2"},
+	}))
+	t.Run("None", assertEntries(m.C.WhereParent(m.A.Name.Eq("A3")), nil))
+}
+
+func TestModelAccessors(t *testing.T) {
+	m := New()
+	as := m.Accessors()
+	if err := as["e"].Insert(rtl.Row{"name": "E1", "size": 3, "weight": 1.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as["e"].Insert(rtl.Row{"name": "E2", "size": "big"}); err != er.ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+	if err := as["e"].Update(rtl.Row{"name": "E1", "size": 4}); err != nil {
+		t.Fatal(err)
+	}
+	row, ok := as["e"].Find(rtl.Row{"name": "E1"})
+	expected := rtl.Row{
+		"name": "E1", "size": 4, "weight": 0.0, "label": "",
+		"flag": false, "born": time.Time{}, "at": time.Time{}, "blob": []byte(nil), "price": rtl.Decimal{},
+		"status": "open", "rank": 0, "ratio": 0.0,
+	}
+	if !ok || !reflect.DeepEqual(row, expected) {
+		t.Errorf("got %v, expecting %v", row, expected)
+	}
+	if _, ok := as["e"].Find(rtl.Row{"name": "E2"}); ok {
+		t.Errorf("found missing entity")
+	}
+	if err := as["e"].Delete(rtl.Row{"name": "E1"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.E.Count(); n != 0 {
+		t.Errorf("got %d entities, expecting 0", n)
+	}
+}
+
+func TestModelCollation(t *testing.T) {
+	m := New()
+	m.E.Insert(E{Name: "b", Label: "ö"})
+	m.E.Insert(E{Name: "C", Label: "Z"})
+	m.E.Insert(E{Name: "a", Label: "a"})
+	if err := m.E.Insert(E{Name: "A"}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	names := func(s setOfE) string {
+		var res []string
+		s.ForEach(func(e E) error {
+			res = append(res, e.Name)
+			return nil
+		})
+		return strings.Join(res, " ")
+	}
+	for _, test := range []struct {
+		name     string
+		s        setOfE
+		expected string
+	}{
+		{"Key", m.E, "a b C"},
+		{"Eq", m.E.Where(m.E.Name.Eq("B")), "b"},
+		{"Range", m.E.Where(m.E.Name.Gt("A")), "b C"},
+		{"OrderBy", m.E.OrderBy(m.E.Label, false), "a C b"},
+		{"Index", m.E.Where(m.E.Label.Lt("b")), "a"},
+	} {
+		if got := names(test.s); got != test.expected {
+			t.Errorf("%s: got %q, expecting %q", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestModelTypes(t *testing.T) {
+	day := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	m := New()
+	m.E.Insert(E{Name: "E1", Flag: true, Born: day, At: day.Add(time.Hour), Blob: []byte{1, 2}, Price: rtl.NewDecimal(125, 2)})
+	m.E.Insert(E{Name: "E2", Born: day.AddDate(0, 0, 1), Blob: []byte{1}, Price: rtl.NewDecimal(-3, 0)})
+	m.E.Insert(E{Name: "E3", Flag: true, Price: rtl.NewDecimal(12500, 4)})
+	names := func(s setOfE) string {
+		var res []string
+		s.ForEach(func(e E) error {
+			res = append(res, e.Name)
+			return nil
+		})
+		return strings.Join(res, " ")
+	}
+	for _, test := range []struct {
+		name     string
+		s        setOfE
+		expected string
+	}{
+		{"Bool", m.E.Where(m.E.Flag.Eq(true)), "E1 E3"},
+		{"Date", m.E.Where(m.E.Born.Gt(day)), "E2"},
+		{"Time", m.E.Where(m.E.At.Lt(day.Add(time.Minute))), "E2 E3"},
+		{"Bytes", m.E.Where(m.E.Blob.Ge([]byte{1, 0})), "E1"},
+		{"Decimal", m.E.Where(m.E.Price.Eq(rtl.NewDecimal(125, 2))), "E1 E3"},
+		{"DecimalRange", m.E.Where(m.E.Price.Lt(rtl.NewDecimal(0, 0))), "E2"},
+	} {
+		if got := names(test.s); got != test.expected {
+			t.Errorf("%s: got %q, expecting %q", test.name, got, test.expected)
+		}
+	}
+
+	bs, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New()
+	if err := n.Unmarshal(bs); err != nil {
+		t.Fatal(err)
+	}
+	if d := m.Diff(n); len(d.E.Added)+len(d.E.Removed)+len(d.E.Changed) != 0 {
+		t.Errorf("%s: got changes %v", bs, d.E)
+	}
+	n.E.Update(E{Name: "E2", Born: day.AddDate(0, 0, 1), Blob: []byte{2}, Price: rtl.NewDecimal(-3, 0)})
+	if d := m.Diff(n); len(d.E.Changed) != 1 || d.E.Changed[0].After.Name != "E2" {
+		t.Errorf("got changed %v", d.E.Changed)
+	}
+}
+
+func TestModelEnums(t *testing.T) {
+	if StatusOnHold.String() != "on_hold" || Status(7).String() != "Status(7)" {
+		t.Errorf("got %s and %s", StatusOnHold, Status(7))
+	}
+	if s, err := ParseStatus("closed"); s != StatusClosed || err != nil {
+		t.Errorf("got %v, %v", s, err)
+	}
+	if _, err := ParseStatus("shut"); err != er.ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+
+	m := New()
+	m.E.Insert(E{Name: "E1", Status: StatusClosed})
+	m.E.Insert(E{Name: "E2", Status: StatusOnHold})
+	m.E.Insert(E{Name: "E3"})
+	var names []string
+	m.E.Where(m.E.Status.Gt(StatusOpen)).OrderBy(m.E.Status, false).ForEach(func(e E) error {
+		names = append(names, e.Name)
+		return nil
+	})
+	if !reflect.DeepEqual(names, []string{"E2", "E1"}) {
+		t.Errorf("got %v", names)
+	}
+	bs, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(bs), `status: "on_hold"`) {
+		t.Errorf("%s: missing status", bs)
+	}
+	if err := New().Unmarshal(bs); err != nil {
+		t.Error(err)
+	}
+	bad := strings.Replace(string(bs), `"on_hold"`, `"shut"`, 1)
+	if err := New().Unmarshal([]byte(bad)); !errors.Is(err, er.ErrInvalidAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+
+	if err := m.Accessors()["e"].Update(rtl.Row{"name": "E3", "status": "on_hold"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.E.Where(m.E.Status.Eq(StatusOnHold)).Count(); n != 2 {
+		t.Errorf("got %d on hold, expecting 2", n)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m.E.Update(E{Name: "E3", Status: Status(-1)})
+	if err := m.Validate(); err != er.ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+}
+
+func TestModelOptional(t *testing.T) {
+	note, score, mood := "", 0, StatusClosed
+	m := New()
+	m.E.Insert(E{Name: "E1"})
+	m.E.Insert(E{Name: "E2", Note: &note, Score: &score})
+	m.E.Insert(E{Name: "E3", Score: &score, Mood: &mood})
+	score = 5
+	m.E.Update(E{Name: "E3", Score: &score, Mood: &mood})
+	*m.E.Where(m.E.Name.Eq("E3")).ExactlyOne().Mood = StatusOpen
+	names := func(s setOfE) string {
+		var res []string
+		s.ForEach(func(e E) error {
+			res = append(res, e.Name)
+			return nil
+		})
+		return strings.Join(res, " ")
+	}
+	for _, test := range []struct {
+		name     string
+		s        setOfE
+		expected string
+	}{
+		{"IsNull", m.E.Where(m.E.Note.IsNull()), "E1 E3"},
+		{"IsNotNull", m.E.Where(m.E.Score.IsNotNull()), "E2 E3"},
+		{"EmptyString", m.E.Where(m.E.Note.Eq("")), "E2"},
+		{"Zero", m.E.Where(m.E.Score.Lt(1)), "E2"},
+		{"Ne", m.E.Where(m.E.Score.Ne(0)), "E3"},
+		{"Enum", m.E.Where(m.E.Mood.Eq(StatusOpen)), ""},
+		{"Order", m.E.OrderBy(m.E.Score, true), "E3 E2 E1"},
+	} {
+		if got := names(test.s); got != test.expected {
+			t.Errorf("%s: got %q, expecting %q", test.name, got, test.expected)
+		}
+	}
+	if sum := m.E.SumScore(); sum != 5 {
+		t.Errorf("got sum %d, expecting 5", sum)
+	}
+
+	bs, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New()
+	if err := n.Unmarshal(bs); err != nil {
+		t.Fatal(err)
+	}
+	if d := m.Diff(n); len(d.E.Added)+len(d.E.Removed)+len(d.E.Changed) != 0 {
+		t.Errorf("%s: got changes %v", bs, d.E)
+	}
+	e2 := n.E.Where(n.E.Name.Eq("E2")).ExactlyOne()
+	if e2.Note == nil || *e2.Note != "" || e2.Mood != nil {
+		t.Errorf("got %v", e2)
+	}
+
+	row, _ := m.Accessors()["e"].Find(rtl.Row{"name": "E3"})
+	if _, ok := row["note"]; ok || row["score"] != 5 || row["mood"] != "closed" {
+		t.Errorf("got row %v", row)
+	}
+	if err := m.Accessors()["e"].Update(rtl.Row{"name": "E3", "score": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.E.Where(m.E.Score.IsNull()).Count(); n != 2 {
+		t.Errorf("got %d without score, expecting 2", n)
+	}
+
+	err = New().Unmarshal([]byte(`e { name: "E4" }`))
+	if !errors.Is(err, er.ErrMissingAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingAttribute)
+	}
+}
+
+func TestModelRules(t *testing.T) {
+	m := New()
+	code := "AB"
+	if err := m.E.Insert(E{Name: "E1", Rank: 10, Ratio: 1.5, Code: &code}); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		e    E
+		rule string
+	}{
+		{"Min", E{Rank: -1}, "e.rank: min 0"},
+		{"Max", E{Rank: 11}, "e.rank: max 10"},
+		{"Float", E{Ratio: 1.6}, "e.ratio: max 1.5"},
+		{"NonEmpty", E{Code: new(string)}, "e.code: non_empty"},
+		{"MaxLength", E{Code: strPtr("ABCDE")}, "e.code: max_length 4"},
+		{"Pattern", E{Code: strPtr("A1")}, `e.code: pattern "[A-Z]+"`},
+		{"Anchored", E{Code: strPtr("aBc")}, `e.code: pattern "[A-Z]+"`},
+	} {
+		test.e.Name = "E1"
+		err := m.E.Update(test.e)
+		if !errors.Is(err, er.ErrBrokenRule) || !strings.HasPrefix(err.Error(), test.rule+":") {
+			t.Errorf("%s: got %v, expecting %s", test.name, err, test.rule)
+		}
+		test.e.Name = "E2"
+		if err := m.E.Insert(test.e); !errors.Is(err, er.ErrBrokenRule) {
+			t.Errorf("%s: got %v, expecting %v", test.name, err, er.ErrBrokenRule)
+		}
+	}
+	if n := m.E.Count(); n != 1 {
+		t.Errorf("got %d entities, expecting 1", n)
+	}
+
+	m.E.rows[0].Rank = 20
+	if err := m.Validate(); !errors.Is(err, er.ErrBrokenRule) {
+		t.Errorf("got %v, expecting %v", err, er.ErrBrokenRule)
+	}
+	bs, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New().Unmarshal(bs); !errors.Is(err, er.ErrBrokenRule) {
+		t.Errorf("got %v, expecting %v", err, er.ErrBrokenRule)
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestModelAlternateKeys(t *testing.T) {
+	m := New()
+	if err := m.B.Insert(B{Name: "B1", Code: strPtr("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.B.Insert(B{Name: "B2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.B.Insert(B{Name: "B3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.B.Insert(B{Name: "B4", Code: strPtr("x")}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	if err := m.B.Update(B{Name: "B2", Code: strPtr("x")}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	if err := m.B.Upsert(B{Name: "B1", Code: strPtr("x")}); err != nil {
+		t.Errorf("got %v updating an entity in place", err)
+	}
+	if b, ok := m.B.FindByCode("x"); !ok || b.Name != "B1" {
+		t.Errorf("got %v, %t", b, ok)
+	}
+	if _, ok := m.B.FindByCode("y"); ok {
+		t.Errorf("found missing entity")
+	}
+
+	if err := m.F.Insert(F{Name: "F1", OwnerCode: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if b := m.F.Where(m.F.Name.Eq("F1")).ExactlyOne().Owner(); b.Name != "B1" {
+		t.Errorf("got owner %v", b)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m.B.Update(B{Name: "B1", Code: strPtr("z")})
+	if err := m.Validate(); err != er.ErrMissingEntity {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingEntity)
+	}
+	m.B.rows[1].Code = strPtr("z")
+	if err := m.Validate(); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+}
+
+func TestModelSubtypes(t *testing.T) {
+	m := New()
+	if err := m.G.Insert(G{Name: "G1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != er.ErrMissingEntity {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingEntity)
+	}
+	if err := m.H.Insert(H{GName: "G1", Wheels: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	g := m.G.Where(m.G.Name.Eq("G1")).ExactlyOne()
+	switch s := g.Subtype().(type) {
+	case H:
+		if s.Wheels != 4 {
+			t.Errorf("got %v", s)
+		}
+	default:
+		t.Errorf("got subtype %v", s)
+	}
+	if err := m.I.Insert(I{GName: "G1", Sails: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if s := g.Subtypes(); len(s) != 2 {
+		t.Errorf("got subtypes %v", s)
+	}
+	if err := m.Validate(); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	m.I.Delete(I{GName: "G1"})
+
+	if err := m.J.Insert(J{Name: "J1", IsK: true, Depth: floatPtr(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.J.Insert(J{Name: "J2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != er.ErrMissingEntity {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingEntity)
+	}
+	m.J.Delete(J{Name: "J2"})
+
+	if err := m.N.Insert(N{Name: "X", Colour: "red", Corners: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.O.Insert(O{Name: "Y", Colour: "blue", Radius: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var shapes []L
+	m.N.ForEach(func(n N) error { shapes = append(shapes, n); return nil })
+	m.O.ForEach(func(o O) error { shapes = append(shapes, o); return nil })
+	if len(shapes) != 2 {
+		t.Errorf("got %v", shapes)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.O.Insert(O{Name: "X"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestModelSurrogateKeys(t *testing.T) {
+	m := New()
+	p1, err := m.P.InsertNew(P{Title: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := m.P.InsertNew(P{Title: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1.Id != 1 || p2.Id != 2 {
+		t.Errorf("got keys %d and %d", p1.Id, p2.Id)
+	}
+	if err := m.P.Insert(P{Id: 2}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	if err := m.Unmarshal([]byte(`p { title: "third" }`)); err != nil {
+		t.Fatal(err)
+	}
+	if p := m.P.Where(m.P.Title.Eq("third")).ExactlyOne(); p.Id != 3 {
+		t.Errorf("got %v", p)
+	}
+
+	r, err := m.R.InsertNew(R{AboutId: p2.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Id) != 36 || r.About().Title != "second" {
+		t.Errorf("got %v", r)
+	}
+	given := rtl.Row{"about_id": 1}
+	row, err := m.Accessors()["r"].InsertNew(given)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := row["id"].(string); id == "" || id == r.Id || len(given) != 1 {
+		t.Errorf("got key %q, leaving %v", id, given)
+	}
+	if err := m.Accessors()["r"].Insert(given); err != nil {
+		t.Fatalf("inserting the same row again: %v", err)
+	}
+	if m.R.Count() != 3 {
+		t.Errorf("got %d entities", m.R.Count())
+	}
+
+	if err := m.P.Delete(p2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.P.Delete(P{Id: 3}); err != nil {
+		t.Fatal(err)
+	}
+	p4, err := m.P.InsertNew(P{Title: "fourth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p4.Id != 4 {
+		t.Errorf("got key %d after deleting the highest", p4.Id)
+	}
+	m.P.Delete(p4)
+	bs, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := New()
+	if err := n.Unmarshal(bs); err != nil {
+		t.Fatalf("unmarshal failed: %v\n%s", err, bs)
+	}
+	if p5, err := n.P.InsertNew(P{Title: "fifth"}); err != nil || p5.Id != 5 {
+		t.Errorf("got %v, %v after unmarshalling", p5, err)
+	}
+}
+
+func TestModelSiblingKeys(t *testing.T) {
+	m := New()
+	w, err := m.W.InsertNew(W{Label: "ant", Legs: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, err := m.X.InsertNew(X{Label: "bee", Wings: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Id != 1 || x.Id != 2 {
+		t.Errorf("got keys %d and %d", w.Id, x.Id)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if w, err := m.W.InsertNew(W{Label: "spider", Legs: 8}); err != nil || w.Id != 3 {
+		t.Errorf("got %v, %v", w, err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModelDerived(t *testing.T) {
+	m := New()
+	m.B.Insert(B{Name: "B1", Code: strPtr("x")})
+	m.B.Insert(B{Name: "B2"})
+	m.A.Insert(A{Name: "A1", SName: "B1"})
+	m.A.Insert(A{Name: "A2", SName: "B2"})
+	m.C.Insert(C{Name: "C1", ParentName: "A1"})
+	m.C.Insert(C{Name: "C2", ParentName: "A1"})
+	m.C.Insert(C{Name: "C3", ParentName: "A2"})
+	m.F.Insert(F{Name: "F1", OwnerCode: "x"})
+
+	c := m.C.Where(m.C.Name.Eq("C1")).ExactlyOne()
+	if c.SName() != "B1" || c.SCode() == nil || *c.SCode() != "x" {
+		t.Errorf("got %q and %v", c.SName(), c.SCode())
+	}
+	if a := m.A.Where(m.A.Name.Eq("A1")).ExactlyOne(); a.Children() != 2 {
+		t.Errorf("got %d children", a.Children())
+	}
+	if b := m.B.Where(m.B.Name.Eq("B2")).ExactlyOne(); b.Owned() != 0 {
+		t.Errorf("got %d owned", b.Owned())
+	}
+
+	if n := m.C.Where(m.C.SName.Eq("B1")).Count(); n != 2 {
+		t.Errorf("got %d entities, expecting 2", n)
+	}
+	if n := m.C.Where(m.C.SCode.IsNull()).Count(); n != 1 {
+		t.Errorf("got %d entities, expecting 1", n)
+	}
+	var names []string
+	m.A.OrderBy(m.A.Children, false).ForEach(func(a A) error {
+		names = append(names, a.Name)
+		return nil
+	})
+	if !reflect.DeepEqual(names, []string{"A2", "A1"}) {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestModelDefaults(t *testing.T) {
+	m := New()
+	u := m.U.New()
+	u.Name = "U1"
+	if u.Number != 1 || u.Size != 3 || u.Weight != 2 || u.Label != "none" || !u.Flag ||
+		u.Status != StatusOnHold || *u.Note != "blank" || u.Price != rtl.NewDecimal(15, 1) ||
+		!bytes.Equal(u.Blob, []byte{0, 255}) || u.Opened != rtl.Today() || u.At.IsZero() ||
+		!u.Born.Equal(time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)) || u.Due.Hour() != 12 {
+		t.Errorf("got %v", u)
+	}
+	if err := m.U.Insert(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.U.Insert(U{Name: "U2"}); err != nil {
+		t.Fatal(err)
+	}
+	u2 := m.U.Where(m.U.Name.Eq("U2")).ExactlyOne()
+	if u2.Number != 2 || u2.Opened.IsZero() || u2.Note == nil || *u2.Note != "blank" || u2.Size != 0 {
+		t.Errorf("got %v", u2)
+	}
+	if err := m.Unmarshal([]byte(`u { name: "U3" size: 4 }`)); err != nil {
+		t.Fatal(err)
+	}
+	u3 := m.U.Where(m.U.Name.Eq("U3")).ExactlyOne()
+	if u3.Number != 3 || u3.Size != 4 || u3.Label != "none" || u3.Status != StatusOnHold {
+		t.Errorf("got %v", u3)
+	}
+	row := rtl.Row{"name": "U4"}
+	if err := m.Accessors()["u"].Insert(row); err != nil {
+		t.Fatal(err)
+	}
+	if u4, _ := m.Accessors()["u"].Find(row); u4["number"] != 4 || u4["size"] != 3 || u4["status"] != "on_hold" {
+		t.Errorf("got %v", u4)
+	}
+	if len(row) != 1 {
+		t.Errorf("insert changed the row given to %v", row)
+	}
+	if err := m.U.Upsert(U{Name: "U5"}); err != nil {
+		t.Fatal(err)
+	}
+	u5 := m.U.Where(m.U.Name.Eq("U5")).ExactlyOne()
+	if u5.Number != 5 || u5.Opened.IsZero() || u5.At.IsZero() || u5.Note == nil || *u5.Note != "blank" {
+		t.Errorf("got %v", u5)
+	}
+	u5.Note = nil
+	if err := m.U.Upsert(u5); err != nil {
+		t.Fatal(err)
+	}
+	if u5 = m.U.Where(m.U.Name.Eq("U5")).ExactlyOne(); u5.Number != 5 || u5.Note != nil {
+		t.Errorf("got %v after updating", u5)
+	}
+}
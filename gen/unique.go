@@ -0,0 +1,87 @@
+package gen
+
+import (
+	"go/token"
+	"strings"
+
+	"github.com/bobappleyard/er"
+)
+
+func (g *generator) generateUnique(t *er.EntityType) error {
+	g.out("// checkUnique fails if another entity shares the values of one of the")
+	g.out("// alternate keys with e.")
+	g.out("func (s setOf%s) checkUnique(e %[1]s) error {", GoName(t.Name))
+	for _, x := range t.Indexes {
+		if !x.Unique {
+			continue
+		}
+		if len(x.Attributes) == 0 {
+			return er.ErrInvalidAttribute
+		}
+		var present []string
+		for _, a := range x.Attributes {
+			if a.Optional {
+				present = append(present, "e."+GoName(a.Name)+" != nil")
+			}
+		}
+		if present != nil {
+			g.out("if %s {", strings.Join(present, " && "))
+		} else {
+			g.out("{")
+		}
+		g.out("var q rtl.Query")
+		for _, a := range x.Attributes {
+			val := "e." + GoName(a.Name)
+			if a.Optional {
+				val = "*" + val
+			}
+			g.out("q = q.And(s.%s.Eq(%s))", GoName(a.Name), val)
+		}
+		var differ []string
+		for _, a := range key(t) {
+			differ = append(differ, compareFunc(a)+"(d."+GoName(a.Name)+", e."+GoName(a.Name)+") != 0")
+		}
+		if differ == nil {
+			differ = []string{"true"}
+		}
+		g.out("for r := rtl.EvalQuery(q, len(s.rows), s.indexes...); r.Next(); {")
+		g.out("if d := s.rows[r.This()]; %s { return er.ErrDuplicateKey }", strings.Join(differ, " || "))
+		g.out("}")
+		g.out("}")
+	}
+	g.out("return nil")
+	g.out("}")
+	g.out("")
+
+	for _, x := range t.Indexes {
+		if !x.Unique {
+			continue
+		}
+		params := make([]string, len(x.Attributes))
+		var q []string
+		for i, a := range x.Attributes {
+			params[i] = paramName(a) + " " + valueType(a)
+			q = append(q, "s."+GoName(a.Name)+".Eq("+paramName(a)+")")
+		}
+		if !g.doc(x.Doc) {
+			g.out("// Find%s finds the %s with the given %s.", GoName(x.Name), GoName(t.Name), strings.Join(x.AttributeNames, " and "))
+		}
+		g.out("func (s setOf%s) Find%s(%s) (%[1]s, bool) {", GoName(t.Name), GoName(x.Name), strings.Join(params, ", "))
+		g.out("r := s.Where(%s).eval()", strings.Join(q, ".And(")+strings.Repeat(")", len(q)-1))
+		g.out("if !r.Next() { return %s{}, false }", GoName(t.Name))
+		g.out("return s.entity(s.rows[r.This()]), true")
+		g.out("}")
+		g.out("")
+	}
+	return nil
+}
+
+// paramName is the name of a parameter holding a value of the attribute.
+func paramName(a *er.Attribute) string {
+	name := GoName(a.Name)
+	name = strings.ToLower(name[:1]) + name[1:]
+	if token.IsKeyword(name) {
+		name += "_"
+	}
+	return name
+}
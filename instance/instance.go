@@ -0,0 +1,629 @@
+// Package instance holds the entities of a model that is only known at run
+// time, such as one read by package schema. It offers the same untyped
+// accessors and record format as generated models.
+//
+// Records must give every mandatory attribute; omitting one is
+// er.ErrMissingAttribute.
+package instance
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/query"
+	"github.com/bobappleyard/er/rtl"
+)
+
+// Instance holds the entities of each type in a model, sorted by key.
+type Instance struct {
+	m          *er.EntityModel
+	rows       map[*er.EntityType][]rtl.Row
+	collations map[*er.Attribute]rtl.Collation
+	patterns   map[*er.Attribute]rtl.Pattern
+	last       map[*er.Attribute]int
+	unique     map[*er.Index][]rtl.Row
+}
+
+// New creates an empty instance of a model, which must have been through
+// LogicalToPhysical.
+func New(m *er.EntityModel) *Instance {
+	i := &Instance{
+		m:          m,
+		rows:       map[*er.EntityType][]rtl.Row{},
+		collations: map[*er.Attribute]rtl.Collation{},
+		patterns:   map[*er.Attribute]rtl.Pattern{},
+		last:       map[*er.Attribute]int{},
+		unique:     map[*er.Index][]rtl.Row{},
+	}
+	for _, t := range m.Types {
+		for _, a := range t.Attributes {
+			i.collations[a] = rtl.MustCollation(a.Collation)
+			if a.Pattern != "" {
+				i.patterns[a] = rtl.MustPattern(a.Pattern)
+			}
+		}
+	}
+	return i
+}
+
+func (i *Instance) Model() *er.EntityModel {
+	return i.m
+}
+
+// Accessors gives access to the instance's entities, keyed by type name.
+func (i *Instance) Accessors() map[string]rtl.Accessor {
+	res := map[string]rtl.Accessor{}
+	for _, t := range i.m.Types {
+		res[t.Name] = i.accessor(t)
+	}
+	return res
+}
+
+func (i *Instance) accessor(t *er.EntityType) rtl.Accessor {
+	return rtl.Accessor{
+		ForEach: func(f func(rtl.Row) error) error {
+			for _, row := range i.rows[t] {
+				if err := f(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Find: func(key rtl.Row) (rtl.Row, bool) {
+			key, err := normalize(t, key)
+			if err != nil {
+				return nil, false
+			}
+			pos, found := i.search(t, key)
+			if !found {
+				return nil, false
+			}
+			return i.rows[t][pos], true
+		},
+		Count: func(match rtl.Row) int { return i.count(t, match) },
+		Insert: func(row rtl.Row) error {
+			_, err := i.insert(t, row)
+			return err
+		},
+		Update:    func(row rtl.Row) error { return i.update(t, row) },
+		Delete:    func(row rtl.Row) error { return i.delete(t, row) },
+		InsertNew: func(row rtl.Row) (rtl.Row, error) { return i.insert(t, row) },
+	}
+}
+
+// insert adds a copy of row, with its key and defaults filled in, and returns
+// it.
+func (i *Instance) insert(t *er.EntityType, row rtl.Row) (rtl.Row, error) {
+	given := row
+	row = rtl.Row{}
+	for k, v := range given {
+		row[k] = v
+	}
+	i.generateKey(t, row)
+	i.applyDefaults(t, row)
+	row, err := normalize(t, row)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.check(t, row); err != nil {
+		return nil, err
+	}
+	pos, found := i.search(t, row)
+	if found {
+		return nil, er.ErrDuplicateKey
+	}
+	if err := i.checkUnique(t, row); err != nil {
+		return nil, err
+	}
+	rows := append(i.rows[t], nil)
+	copy(rows[pos+1:], rows[pos:])
+	rows[pos] = row
+	i.rows[t] = rows
+	i.addUnique(t, row)
+	i.raiseCounters(t, row)
+	return row, nil
+}
+
+// generateKey gives row a new surrogate key if t has one and row lacks it.
+func (i *Instance) generateKey(t *er.EntityType, row rtl.Row) {
+	for _, a := range t.Attributes {
+		if v, ok := row[a.Name]; a.Generated == er.NoKey || ok && v != reflect.Zero(goType(a)).Interface() {
+			continue
+		}
+		switch a.Generated {
+		case er.SerialKey:
+			row[a.Name] = i.next(t, a)
+		case er.UUID4Key:
+			row[a.Name] = rtl.NewUUID4()
+		case er.UUID7Key:
+			row[a.Name] = rtl.NewUUID7()
+		}
+	}
+}
+
+// applyDefaults gives the attributes that row leaves out, or holds nil for,
+// their defaults. Generated defaults also replace zero values, as surrogate
+// keys do.
+func (i *Instance) applyDefaults(t *er.EntityType, row rtl.Row) {
+	for _, a := range t.Attributes {
+		v, ok := row[a.Name]
+		generated := a.Default == rtl.Now || a.Default == rtl.Sequence
+		if a.Default == "" || ok && v != nil && !(generated && v == reflect.Zero(goType(a)).Interface()) {
+			continue
+		}
+		switch {
+		case a.Default == rtl.Now && a.Type == er.DateType:
+			row[a.Name] = rtl.Today()
+		case a.Default == rtl.Now:
+			row[a.Name] = time.Now()
+		case a.Default == rtl.Sequence:
+			row[a.Name] = i.next(t, a)
+		default:
+			row[a.Name], _ = rtl.ParseDefault(a)
+		}
+	}
+}
+
+// next is one more than the highest value of a counted attribute stored so
+// far, including by the types sharing its counter. Deleting entities does not
+// lower the counter, so values are not issued twice.
+func (i *Instance) next(t *er.EntityType, a *er.Attribute) int {
+	n := i.last[a]
+	for _, b := range sharedCounters(t, a) {
+		if i.last[b] > n {
+			n = i.last[b]
+		}
+	}
+	return n + 1
+}
+
+// raiseCounters raises the counters of t to the values held by row.
+func (i *Instance) raiseCounters(t *er.EntityType, row rtl.Row) {
+	for _, a := range counters(t) {
+		if n, ok := row[a.Name].(int); ok && n > i.last[a] {
+			i.last[a] = n
+		}
+	}
+}
+
+// counters lists the attributes of t numbered from a counter: serial keys and
+// attributes defaulting to sequence().
+func counters(t *er.EntityType) []*er.Attribute {
+	var res []*er.Attribute
+	for _, a := range t.Attributes {
+		if a.Generated == er.SerialKey || a.Default == rtl.Sequence {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// sharedCounters lists the attributes of the same name as a numbered by the
+// other subtypes in an exclusive ConcreteTypes hierarchy, so that sibling
+// entities are not given the same key.
+func sharedCounters(t *er.EntityType, a *er.Attribute) []*er.Attribute {
+	p := t.Supertype
+	if p == nil || p.Subtyping.Mapping != er.ConcreteTypes || !p.Subtyping.Exclusive {
+		return nil
+	}
+	var res []*er.Attribute
+	for _, sib := range p.Subtypes {
+		if sib == t {
+			continue
+		}
+		for _, b := range counters(sib) {
+			if b.Name == a.Name {
+				res = append(res, b)
+			}
+		}
+	}
+	return res
+}
+
+func (i *Instance) update(t *er.EntityType, row rtl.Row) error {
+	row, err := normalize(t, row)
+	if err != nil {
+		return err
+	}
+	if err := i.check(t, row); err != nil {
+		return err
+	}
+	pos, found := i.search(t, row)
+	if !found {
+		return er.ErrMissingEntity
+	}
+	if err := i.checkUnique(t, row); err != nil {
+		return err
+	}
+	i.removeUnique(t, i.rows[t][pos])
+	i.rows[t][pos] = row
+	i.addUnique(t, row)
+	i.raiseCounters(t, row)
+	return nil
+}
+
+func (i *Instance) delete(t *er.EntityType, row rtl.Row) error {
+	row, err := normalize(t, row)
+	if err != nil {
+		return err
+	}
+	pos, found := i.search(t, row)
+	if !found {
+		return er.ErrMissingEntity
+	}
+	rows := i.rows[t]
+	i.removeUnique(t, rows[pos])
+	i.rows[t] = append(rows[:pos], rows[pos+1:]...)
+	return nil
+}
+
+// search finds where a row with the same key as row is or would be.
+func (i *Instance) search(t *er.EntityType, row rtl.Row) (int, bool) {
+	rows := i.rows[t]
+	pos := sort.Search(len(rows), func(j int) bool {
+		return i.compareKey(t, rows[j], row) >= 0
+	})
+	return pos, pos < len(rows) && i.compareKey(t, rows[pos], row) == 0
+}
+
+// Validate checks that every attribute follows its rules, that alternate keys
+// are unique, that entities have the subtypes their hierarchies call for, that
+// every relationship leads to an entity and that the relationships'
+// constraints hold.
+func (i *Instance) Validate() error {
+	as := i.Accessors()
+	for _, t := range i.m.Types {
+		for _, row := range i.rows[t] {
+			if err := i.check(t, row); err != nil {
+				return err
+			}
+			if err := i.checkUnique(t, row); err != nil {
+				return err
+			}
+			if err := i.checkSubtypes(t, row); err != nil {
+				return err
+			}
+			for _, r := range t.Relationships {
+				target, ok := query.Follow(as, r, row)
+				if !ok {
+					return er.ErrMissingEntity
+				}
+				for _, c := range r.Constraints {
+					d, ok := followPath(as, row, c.Diagonal.Components)
+					if !ok {
+						return er.ErrMissingEntity
+					}
+					u, ok := followPath(as, target, c.Riser.Components)
+					last := c.Riser.Components[len(c.Riser.Components)-1].Rel
+					if !ok || !i.same(targets(last), d, u) {
+						return er.ErrMissingEntity
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func followPath(as map[string]rtl.Accessor, row rtl.Row, path []er.Component) (rtl.Row, bool) {
+	for _, c := range path {
+		var ok bool
+		if row, ok = query.Follow(as, c.Rel, row); !ok {
+			return nil, false
+		}
+	}
+	return row, true
+}
+
+// normalize checks the values in row against the attributes of t, giving
+// absent attributes their zero value. Enum values are held as strings, with the
+// first value in the enumeration as their zero value. Optional attributes
+// holding no value are left out, and may be given as nil.
+func normalize(t *er.EntityType, row rtl.Row) (rtl.Row, error) {
+	res := rtl.Row{}
+	for _, a := range t.Attributes {
+		if a.Optional {
+			continue
+		}
+		res[a.Name] = reflect.Zero(goType(a)).Interface()
+		if a.Type == er.EnumType && len(a.Enum.Values) != 0 {
+			res[a.Name] = a.Enum.Values[0]
+		}
+	}
+	for k, v := range row {
+		a := findAttr(t, k)
+		if a != nil && a.Optional && v == nil {
+			continue
+		}
+		if a == nil || reflect.TypeOf(v) != goType(a) {
+			return nil, er.ErrInvalidAttribute
+		}
+		if a.Type == er.EnumType && a.Enum.Index(v.(string)) < 0 {
+			return nil, er.ErrInvalidAttribute
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+// check tests the values in a normalized row against the rules of their
+// attributes.
+func (i *Instance) check(t *er.EntityType, row rtl.Row) error {
+	for _, a := range t.Attributes {
+		v, ok := row[a.Name]
+		if !ok {
+			continue
+		}
+		var n float64
+		switch v := v.(type) {
+		case int:
+			n = float64(v)
+		case float64:
+			n = v
+		}
+		length := -1
+		switch v := v.(type) {
+		case string:
+			length = utf8.RuneCountInString(v)
+		case []byte:
+			length = len(v)
+		}
+		switch {
+		case a.Min != nil && n < *a.Min:
+			return rtl.BrokenRule(a.String(), fmt.Sprintf("min %v", *a.Min))
+		case a.Max != nil && n > *a.Max:
+			return rtl.BrokenRule(a.String(), fmt.Sprintf("max %v", *a.Max))
+		case a.NonEmpty && length == 0:
+			return rtl.BrokenRule(a.String(), "non_empty")
+		case a.MaxLength != 0 && length > a.MaxLength:
+			return rtl.BrokenRule(a.String(), fmt.Sprintf("max_length %d", a.MaxLength))
+		case a.Pattern != "" && !i.patterns[a].Match(v.(string)):
+			return rtl.BrokenRule(a.String(), fmt.Sprintf("pattern %q", a.Pattern))
+		}
+	}
+	return nil
+}
+
+// checkUnique fails if an entity with a different key shares the values of one
+// of the alternate keys with row. Rows lacking a value for an alternate key
+// share it with no other.
+func (i *Instance) checkUnique(t *er.EntityType, row rtl.Row) error {
+	for _, x := range t.Indexes {
+		if !x.Unique || !i.holds(x, row) {
+			continue
+		}
+		rows := i.unique[x]
+		for pos := i.searchUnique(x, row); pos < len(rows) && i.compareBy(x.Attributes, rows[pos], row) == 0; pos++ {
+			if i.compareKey(t, rows[pos], row) != 0 {
+				return er.ErrDuplicateKey
+			}
+		}
+	}
+	return nil
+}
+
+// addUnique records row under each of the alternate keys it holds values for.
+// The rows under an alternate key are sorted by its values, so that checkUnique
+// finds those sharing them with a search.
+func (i *Instance) addUnique(t *er.EntityType, row rtl.Row) {
+	for _, x := range t.Indexes {
+		if !x.Unique || !i.holds(x, row) {
+			continue
+		}
+		rows := append(i.unique[x], nil)
+		pos := i.searchUnique(x, row)
+		copy(rows[pos+1:], rows[pos:])
+		rows[pos] = row
+		i.unique[x] = rows
+	}
+}
+
+func (i *Instance) removeUnique(t *er.EntityType, row rtl.Row) {
+	for _, x := range t.Indexes {
+		if !x.Unique || !i.holds(x, row) {
+			continue
+		}
+		rows := i.unique[x]
+		for pos := i.searchUnique(x, row); pos < len(rows) && i.compareBy(x.Attributes, rows[pos], row) == 0; pos++ {
+			if i.compareKey(t, rows[pos], row) == 0 {
+				i.unique[x] = append(rows[:pos], rows[pos+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// searchUnique finds the first of the rows under an alternate key whose values
+// are not below those of row.
+func (i *Instance) searchUnique(x *er.Index, row rtl.Row) int {
+	rows := i.unique[x]
+	return sort.Search(len(rows), func(j int) bool {
+		return i.compareBy(x.Attributes, rows[j], row) >= 0
+	})
+}
+
+// holds reports whether row has a value for each attribute of the index.
+func (i *Instance) holds(x *er.Index, row rtl.Row) bool {
+	for _, a := range x.Attributes {
+		if row[a.Name] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSubtypes fails if row has more subtypes than an exclusive hierarchy
+// allows, or none in a total one.
+func (i *Instance) checkSubtypes(t *er.EntityType, row rtl.Row) error {
+	if p := t.Supertype; p != nil && p.Subtyping.Mapping == er.ConcreteTypes && p.Subtyping.Exclusive {
+		for _, s := range p.Subtypes {
+			if _, found := i.search(s, row); s != t && found {
+				return er.ErrDuplicateKey
+			}
+		}
+	}
+	if len(t.Subtypes) == 0 {
+		return nil
+	}
+	n := 0
+	switch t.Subtyping.Mapping {
+	case er.SharedKey:
+		for _, s := range t.Subtypes {
+			r := s.SupertypeRelationship()
+			if r == nil {
+				continue
+			}
+			for _, sub := range i.rows[s] {
+				if i.specialises(r, sub, row) {
+					n++
+				}
+			}
+		}
+	case er.SingleTable:
+		if t.Subtyping.Exclusive {
+			return nil
+		}
+		for _, s := range t.Subtypes {
+			if row["is_"+s.Name] == true {
+				n++
+			}
+		}
+	default:
+		return nil
+	}
+	if t.Subtyping.Exclusive && n > 1 {
+		return er.ErrDuplicateKey
+	}
+	if t.Subtyping.Total && n == 0 {
+		return er.ErrMissingEntity
+	}
+	return nil
+}
+
+// count counts the rows of t holding the values in match.
+func (i *Instance) count(t *er.EntityType, match rtl.Row) int {
+	n := 0
+	for _, row := range i.rows[t] {
+		if i.matches(t, match, row) {
+			n++
+		}
+	}
+	return n
+}
+
+func (i *Instance) matches(t *er.EntityType, match, row rtl.Row) bool {
+	for k, v := range match {
+		a := findAttr(t, k)
+		if a == nil || v == nil || row[k] == nil || i.compare(a, v, row[k]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// specialises reports whether sub leads to row through r.
+func (i *Instance) specialises(r *er.Relationship, sub, row rtl.Row) bool {
+	for _, k := range r.Implementation {
+		if i.compare(k.Target, sub[k.Source.Name], row[k.Target.Name]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *Instance) same(attrs []*er.Attribute, x, y rtl.Row) bool {
+	for _, a := range attrs {
+		u, ok := x[a.Name]
+		v, ok2 := y[a.Name]
+		if !ok || !ok2 || i.compare(a, u, v) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compareBy compares rows holding values for each of attrs.
+func (i *Instance) compareBy(attrs []*er.Attribute, x, y rtl.Row) int {
+	for _, a := range attrs {
+		if c := i.compare(a, x[a.Name], y[a.Name]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// targets lists the attributes that identify the target of r.
+func targets(r *er.Relationship) []*er.Attribute {
+	res := make([]*er.Attribute, len(r.Implementation))
+	for j, k := range r.Implementation {
+		res[j] = k.Target
+	}
+	return res
+}
+
+func (i *Instance) compareKey(t *er.EntityType, x, y rtl.Row) int {
+	for _, a := range t.Attributes {
+		if !a.Identifying {
+			continue
+		}
+		if c := i.compare(a, x[a.Name], y[a.Name]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (i *Instance) compare(a *er.Attribute, x, y interface{}) int {
+	switch a.Type {
+	case er.StringType:
+		return i.collations[a].Compare(x.(string), y.(string))
+	case er.IntType:
+		return rtl.CompareInt(x.(int), y.(int))
+	case er.FloatType:
+		return rtl.CompareFloat64(x.(float64), y.(float64))
+	case er.BoolType:
+		return rtl.CompareBool(x.(bool), y.(bool))
+	case er.TimeType, er.DateType:
+		return rtl.CompareTime(x.(time.Time), y.(time.Time))
+	case er.BytesType:
+		return rtl.CompareBytes(x.([]byte), y.([]byte))
+	case er.DecimalType:
+		return rtl.CompareDecimal(x.(rtl.Decimal), y.(rtl.Decimal))
+	case er.EnumType:
+		return rtl.CompareInt(a.Enum.Index(x.(string)), a.Enum.Index(y.(string)))
+	}
+	return 0
+}
+
+func goType(a *er.Attribute) reflect.Type {
+	switch a.Type {
+	case er.StringType, er.EnumType:
+		return reflect.TypeOf("")
+	case er.IntType:
+		return reflect.TypeOf(0)
+	case er.FloatType:
+		return reflect.TypeOf(0.0)
+	case er.BoolType:
+		return reflect.TypeOf(false)
+	case er.TimeType, er.DateType:
+		return reflect.TypeOf(time.Time{})
+	case er.BytesType:
+		return reflect.TypeOf([]byte(nil))
+	case er.DecimalType:
+		return reflect.TypeOf(rtl.Decimal{})
+	}
+	return nil
+}
+
+func findAttr(t *er.EntityType, name string) *er.Attribute {
+	for _, a := range t.Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
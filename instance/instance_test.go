@@ -0,0 +1,403 @@
+package instance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/l2p"
+	"github.com/bobappleyard/er/query"
+	"github.com/bobappleyard/er/rtl"
+	"github.com/bobappleyard/er/schema"
+)
+
+const square = `
+name: "square"
+type {
+	name: "a"
+	attribute { name: "name" type: "string" identifying: true }
+	relationship { name: "s" type_name: "b" }
+}
+type {
+	name: "b"
+	attribute { name: "name" type: "string" identifying: true }
+	attribute { name: "state" type: "enum" enum: "state" }
+	attribute { name: "code" type: "string" optional: true }
+	index { name: "by_code" attribute: "code" unique: true }
+}
+type {
+	name: "c"
+	attribute { name: "name" type: "string" identifying: true }
+	attribute { name: "size" type: "int" min: 0 }
+	attribute { name: "note" type: "string" optional: true pattern: "[a-z]+" }
+	relationship { name: "parent" type_name: "a" identifying: true }
+	relationship {
+		name: "f"
+		type_name: "d"
+		constraint {
+			diagonal {
+				component { rel_name: "parent" }
+				component { rel_name: "s" }
+			}
+			riser {
+				component { rel_name: "parent" }
+			}
+		}
+	}
+	depends_on: "parent"
+}
+type {
+	name: "d"
+	attribute { name: "name" type: "string" identifying: true }
+	relationship { name: "parent" type_name: "b" identifying: true }
+	depends_on: "parent"
+}
+enum { name: "state" value: "on" value: "off" }
+`
+
+const data = `a {
+	name: "A1"
+	s_name: "B1"
+
+	c {
+		name: "C1"
+		size: 3
+		f_name: "D1"
+	}
+}
+
+b {
+	name: "B1"
+	state: "off"
+
+	d {
+		name: "D1"
+	}
+}
+`
+
+func testInstance(t *testing.T) *Instance {
+	m, err := schema.Parse([]byte(square))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	i := New(m)
+	if err := i.Unmarshal([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestUnmarshal(t *testing.T) {
+	i := testInstance(t)
+	if err := i.Validate(); err != nil {
+		t.Errorf("validation failed: %v", err)
+	}
+	bs, err := i.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := New(i.Model())
+	if err := j.Unmarshal(bs); err != nil {
+		t.Fatal(err)
+	}
+	c, ok := j.Accessors()["c"].Find(rtl.Row{"name": "C1", "parent_name": "A1"})
+	if _, noted := c["note"]; !ok || c["size"] != 3 || c["f_name"] != "D1" || noted {
+		t.Errorf("got %v after round trip", c)
+	}
+	b, ok := j.Accessors()["b"].Find(rtl.Row{"name": "B1"})
+	if !ok || b["state"] != "off" {
+		t.Errorf("got %v after round trip", b)
+	}
+	if err := New(i.Model()).Unmarshal([]byte(`b { name: "B2" state: "dim" }`)); !errors.Is(err, er.ErrInvalidAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+	// mandatory attributes left out were once read as their zero values
+	if err := New(i.Model()).Unmarshal([]byte(`b { name: "B2" }`)); !errors.Is(err, er.ErrMissingAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingAttribute)
+	}
+	if err := New(i.Model()).Unmarshal([]byte(`b { name: "B2" state: "on" colour: "red" }`)); !errors.Is(err, er.ErrInvalidAttribute) {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	i := testInstance(t)
+	as := i.Accessors()
+	if err := as["b"].Insert(rtl.Row{"name": "B1"}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	if err := as["b"].Insert(rtl.Row{"name": 2}); err != er.ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+	if err := as["b"].Insert(rtl.Row{"name": "B0", "state": "dim"}); err != er.ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+	if err := as["b"].Insert(rtl.Row{"name": "B0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as["b"].Update(rtl.Row{"name": "B1", "code": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as["b"].Insert(rtl.Row{"name": "B2", "code": "x"}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	if err := as["b"].Update(rtl.Row{"name": "B0", "code": "x"}); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+	if b, _ := as["b"].Find(rtl.Row{"name": "B0"}); b["state"] != "on" {
+		t.Errorf("got %v, expecting the first state", b)
+	}
+	var names []string
+	as["b"].ForEach(func(r rtl.Row) error {
+		names = append(names, r["name"].(string))
+		return nil
+	})
+	if len(names) != 2 || names[0] != "B0" || names[1] != "B1" {
+		t.Errorf("got %v, expecting [B0 B1]", names)
+	}
+	for _, row := range []rtl.Row{
+		{"name": "C1", "parent_name": "A1", "size": -1},
+		{"name": "C1", "parent_name": "A1", "note": "C"},
+	} {
+		if err := as["c"].Update(row); !errors.Is(err, er.ErrBrokenRule) {
+			t.Errorf("%v: got %v, expecting %v", row, err, er.ErrBrokenRule)
+		}
+	}
+	if err := as["c"].Update(rtl.Row{"name": "C1", "parent_name": "A1", "size": 2, "f_name": "D1", "note": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as["a"].Update(rtl.Row{"name": "A1", "s_name": "B0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Validate(); err != er.ErrMissingEntity {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingEntity)
+	}
+	if err := as["b"].Delete(rtl.Row{"name": "B0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as["b"].Delete(rtl.Row{"name": "B0"}); err != er.ErrMissingEntity {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingEntity)
+	}
+}
+
+func TestAlternateKeys(t *testing.T) {
+	i := testInstance(t)
+	as := i.Accessors()
+	for _, row := range []rtl.Row{
+		{"name": "B2", "code": "x"},
+		{"name": "B3"},
+		{"name": "B4"},
+	} {
+		if err := as["b"].Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		op  func(rtl.Row) error
+		row rtl.Row
+		err error
+	}{
+		{as["b"].Update, rtl.Row{"name": "B3", "code": "x"}, er.ErrDuplicateKey},
+		{as["b"].Update, rtl.Row{"name": "B2", "code": "y"}, nil},
+		{as["b"].Update, rtl.Row{"name": "B3", "code": "x"}, nil},
+		{as["b"].Insert, rtl.Row{"name": "B5", "code": "y"}, er.ErrDuplicateKey},
+		{as["b"].Delete, rtl.Row{"name": "B2"}, nil},
+		{as["b"].Insert, rtl.Row{"name": "B5", "code": "y"}, nil},
+		{as["b"].Update, rtl.Row{"name": "B4", "code": "x"}, er.ErrDuplicateKey},
+		{as["b"].Update, rtl.Row{"name": "B3"}, nil},
+		{as["b"].Update, rtl.Row{"name": "B4", "code": "x"}, nil},
+	} {
+		if err := test.op(test.row); err != test.err {
+			t.Errorf("%v: got %v, expecting %v", test.row, err, test.err)
+		}
+	}
+	if err := i.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	i := testInstance(t)
+	q, err := query.Parse(i.Model(), `select c where parent.s.name = "B1" and size > 2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := q.Run(i.Accessors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "C1" {
+		t.Errorf("got %v", rows)
+	}
+}
+
+const vehicles = `
+name: "vehicles"
+type {
+	name: "vehicle"
+	attribute { name: "name" type: "string" identifying: true }
+	subtyping { exclusive: true total: true }
+}
+type {
+	name: "car"
+	supertype: "vehicle"
+	attribute { name: "wheels" type: "int" }
+}
+type {
+	name: "boat"
+	supertype: "vehicle"
+	attribute { name: "sails" type: "int" }
+}
+`
+
+func TestSubtypes(t *testing.T) {
+	m, err := schema.Parse([]byte(vehicles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	i := New(m)
+	as := i.Accessors()
+	if err := as["vehicle"].Insert(rtl.Row{"name": "V1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Validate(); err != er.ErrMissingEntity {
+		t.Errorf("got %v, expecting %v", err, er.ErrMissingEntity)
+	}
+	if err := as["car"].Insert(rtl.Row{"vehicle_name": "V1", "wheels": 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := as["boat"].Insert(rtl.Row{"vehicle_name": "V1", "sails": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Validate(); err != er.ErrDuplicateKey {
+		t.Errorf("got %v, expecting %v", err, er.ErrDuplicateKey)
+	}
+}
+
+func TestSurrogateKey(t *testing.T) {
+	m, err := schema.Parse([]byte(`
+		type { name: "ticket" surrogate: "serial" attribute { name: "title" type: "string" } }
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	i := New(m)
+	if err := i.Unmarshal([]byte(`ticket { title: "first" } ticket { id: 5 title: "fifth" }`)); err != nil {
+		t.Fatal(err)
+	}
+	given := rtl.Row{"title": "sixth"}
+	row, err := i.Accessors()["ticket"].InsertNew(given)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["id"] != 6 || len(given) != 1 {
+		t.Errorf("got key %v, leaving %v", row["id"], given)
+	}
+	if err := i.Accessors()["ticket"].Insert(given); err != nil {
+		t.Fatalf("inserting the same row again: %v", err)
+	}
+	if err := i.Accessors()["ticket"].Delete(rtl.Row{"id": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if first, ok := i.Accessors()["ticket"].Find(rtl.Row{"id": 1}); !ok || first["title"] != "first" {
+		t.Errorf("got %v", first)
+	}
+	if err := i.Accessors()["ticket"].Delete(row); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := i.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := New(m)
+	if err := j.Unmarshal(bs); err != nil {
+		t.Fatalf("unmarshal failed: %v\n%s", err, bs)
+	}
+	row, err = j.Accessors()["ticket"].InsertNew(rtl.Row{"title": "seventh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row["id"] != 8 {
+		t.Errorf("got key %v after deleting the highest", row["id"])
+	}
+}
+
+func TestSiblingKeys(t *testing.T) {
+	m, err := schema.Parse([]byte(`
+		type {
+			name: "animal"
+			surrogate: "serial"
+			subtyping { exclusive: true mapping: "concrete_types" }
+			attribute { name: "label" type: "string" }
+		}
+		type { name: "insect" supertype: "animal" attribute { name: "legs" type: "int" } }
+		type { name: "bird" supertype: "animal" attribute { name: "wings" type: "int" } }
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	i := New(m)
+	as := i.Accessors()
+	ant, err := as["insect"].InsertNew(rtl.Row{"label": "ant", "legs": 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	robin, err := as["bird"].InsertNew(rtl.Row{"label": "robin", "wings": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ant["id"] != 1 || robin["id"] != 2 {
+		t.Errorf("got keys %v and %v", ant["id"], robin["id"])
+	}
+	if err := i.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	m, err := schema.Parse([]byte(`
+		type {
+			name: "ticket"
+			attribute { name: "number" type: "int" identifying: true default: "sequence()" }
+			attribute { name: "state" type: "enum" enum: "state" default: "off" }
+			attribute { name: "title" type: "string" optional: true default: "untitled" }
+			attribute { name: "opened" type: "date" default: "now()" }
+		}
+		enum { name: "state" value: "on" value: "off" }
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l2p.LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	i := New(m)
+	if err := i.Unmarshal([]byte(`ticket { number: 3 state: "on" } ticket { title: "second" }`)); err != nil {
+		t.Fatal(err)
+	}
+	as := i.Accessors()
+	first, _ := as["ticket"].Find(rtl.Row{"number": 3})
+	if first["state"] != "on" || first["title"] != "untitled" || first["opened"] != rtl.Today() {
+		t.Errorf("got %v", first)
+	}
+	second, ok := as["ticket"].Find(rtl.Row{"number": 4})
+	if !ok || second["state"] != "off" || second["title"] != "second" {
+		t.Errorf("got %v", second)
+	}
+}
@@ -0,0 +1,265 @@
+package instance
+
+import (
+	"time"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+// Unmarshal adds the entities in the record format to the instance. As with
+// generated models, dependent entities are nested within the entity they
+// depend on.
+func (i *Instance) Unmarshal(bs []byte) error {
+	p := rtl.NewReader(bs)
+	for p.Next() {
+		if p.Name() == countersRecord {
+			i.parseCounters(p.Record())
+			continue
+		}
+		t := i.dependant(nil, p.Name())
+		if t == nil {
+			p.SetErr(er.ErrInvalidRecord)
+			continue
+		}
+		i.parse(t, p.Record(), nil)
+	}
+	p.ExpectEOF()
+	return p.Err()
+}
+
+func (i *Instance) parse(t *er.EntityType, p *rtl.Reader, parent rtl.Row) {
+	row := rtl.Row{}
+	omit := omitted(t)
+	for _, k := range omit {
+		row[k.Source.Name] = parent[k.Target.Name]
+	}
+	for p.Next() {
+		if a := findAttr(t, p.Name()); a != nil && !isOmitted(omit, a) {
+			row[a.Name] = ReadAttr(p, a)
+			continue
+		}
+		if d := i.dependant(t, p.Name()); d != nil {
+			i.parse(d, p.Record(), row)
+			continue
+		}
+		p.SetErr(er.ErrInvalidAttribute)
+	}
+	for _, a := range t.Attributes {
+		if !a.Optional && a.Generated == er.NoKey && a.Default == "" && !isOmitted(omit, a) {
+			p.Require(a.Name)
+		}
+	}
+	if p.Err() == nil {
+		_, err := i.insert(t, row)
+		p.SetErr(err)
+	}
+}
+
+// Marshal writes the instance's entities in the record format.
+func (i *Instance) Marshal() ([]byte, error) {
+	w := rtl.NewWriter("\t")
+	for _, t := range i.m.Types {
+		if t.DependsOn == nil {
+			i.marshal(w, t, i.rows[t])
+		}
+	}
+	i.marshalCounters(w)
+	return w.Bytes(), nil
+}
+
+// countersRecord holds the counters numbering attributes, which may be above
+// the numbers still held by entities, as generated models write it.
+const countersRecord = "_counters"
+
+func (i *Instance) marshalCounters(w *rtl.Writer) {
+	var ts []*er.EntityType
+	for _, t := range i.m.Types {
+		if len(counters(t)) != 0 {
+			ts = append(ts, t)
+		}
+	}
+	if len(ts) == 0 {
+		return
+	}
+	w.Begin(countersRecord)
+	for _, t := range ts {
+		w.Begin(t.Name)
+		for _, a := range counters(t) {
+			w.IntAttr(a.Name, i.last[a])
+		}
+		w.End()
+	}
+	w.End()
+}
+
+func (i *Instance) parseCounters(p *rtl.Reader) {
+	for p.Next() {
+		var cs []*er.Attribute
+		for _, t := range i.m.Types {
+			if t.Name == p.Name() {
+				cs = counters(t)
+			}
+		}
+		if len(cs) == 0 {
+			p.SetErr(er.ErrInvalidRecord)
+			continue
+		}
+		r := p.Record()
+		for r.Next() {
+			var a *er.Attribute
+			for _, c := range cs {
+				if c.Name == r.Name() {
+					a = c
+				}
+			}
+			if a == nil {
+				r.SetErr(er.ErrInvalidAttribute)
+				continue
+			}
+			if n := r.IntAttr(); n > i.last[a] {
+				i.last[a] = n
+			}
+		}
+	}
+}
+
+func (i *Instance) marshal(w *rtl.Writer, t *er.EntityType, rows []rtl.Row) {
+	omit := omitted(t)
+	for _, row := range rows {
+		w.Begin(t.Name)
+		for _, a := range t.Attributes {
+			if v, ok := row[a.Name]; ok && !isOmitted(omit, a) {
+				WriteAttr(w, a, v)
+			}
+		}
+		for _, d := range i.m.Types {
+			if d.DependsOn == nil || d.DependsOn.Target != t {
+				continue
+			}
+			var children []rtl.Row
+			for _, c := range i.rows[d] {
+				if i.dependsOn(d, c, row) {
+					children = append(children, c)
+				}
+			}
+			i.marshal(w, d, children)
+		}
+		w.End()
+	}
+}
+
+// ReadRow reads the attributes of an entity of type t.
+func ReadRow(p *rtl.Reader, t *er.EntityType) rtl.Row {
+	row := rtl.Row{}
+	for p.Next() {
+		a := findAttr(t, p.Name())
+		if a == nil {
+			p.SetErr(er.ErrInvalidAttribute)
+			continue
+		}
+		row[a.Name] = ReadAttr(p, a)
+	}
+	return row
+}
+
+// WriteRow writes an entity of type t as a record.
+func WriteRow(w *rtl.Writer, t *er.EntityType, row rtl.Row) {
+	w.Begin(t.Name)
+	for _, a := range t.Attributes {
+		if v, ok := row[a.Name]; ok {
+			WriteAttr(w, a, v)
+		}
+	}
+	w.End()
+}
+
+func ReadAttr(p *rtl.Reader, a *er.Attribute) interface{} {
+	switch a.Type {
+	case er.IntType:
+		return p.IntAttr()
+	case er.FloatType:
+		return p.FloatAttr()
+	case er.BoolType:
+		return p.BoolAttr()
+	case er.TimeType:
+		return p.TimeAttr()
+	case er.DateType:
+		return p.DateAttr()
+	case er.BytesType:
+		return p.BytesAttr()
+	case er.DecimalType:
+		return p.DecimalAttr()
+	case er.EnumType:
+		i := p.EnumAttr(a.Enum.Values)
+		if p.Err() != nil {
+			return ""
+		}
+		return a.Enum.Values[i]
+	}
+	return p.StringAttr()
+}
+
+func WriteAttr(w *rtl.Writer, a *er.Attribute, v interface{}) {
+	switch a.Type {
+	case er.IntType:
+		w.IntAttr(a.Name, v.(int))
+	case er.FloatType:
+		w.FloatAttr(a.Name, v.(float64))
+	case er.BoolType:
+		w.BoolAttr(a.Name, v.(bool))
+	case er.TimeType:
+		w.TimeAttr(a.Name, v.(time.Time))
+	case er.DateType:
+		w.DateAttr(a.Name, v.(time.Time))
+	case er.BytesType:
+		w.BytesAttr(a.Name, v.([]byte))
+	case er.DecimalType:
+		w.DecimalAttr(a.Name, v.(rtl.Decimal))
+	case er.EnumType:
+		w.EnumAttr(a.Name, a.Enum.Values, a.Enum.Index(v.(string)))
+	default:
+		w.StringAttr(a.Name, v.(string))
+	}
+}
+
+// dependant finds the type called name that depends on parent, or that depends
+// on nothing if parent is nil.
+func (i *Instance) dependant(parent *er.EntityType, name string) *er.EntityType {
+	for _, t := range i.m.Types {
+		if t.Name != name {
+			continue
+		}
+		if parent == nil && t.DependsOn == nil || t.DependsOn != nil && t.DependsOn.Target == parent {
+			return t
+		}
+	}
+	return nil
+}
+
+// omitted lists the attributes of t that are written implicitly, by nesting
+// within the entity it depends on.
+func omitted(t *er.EntityType) []er.Implementation {
+	if t.DependsOn == nil {
+		return nil
+	}
+	return t.DependsOn.Implementation
+}
+
+func isOmitted(omit []er.Implementation, a *er.Attribute) bool {
+	for _, k := range omit {
+		if k.Source == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Instance) dependsOn(t *er.EntityType, row, parent rtl.Row) bool {
+	for _, k := range omitted(t) {
+		if i.compare(k.Source, row[k.Source.Name], parent[k.Target.Name]) != 0 {
+			return false
+		}
+	}
+	return true
+}
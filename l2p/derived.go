@@ -0,0 +1,77 @@
+package l2p
+
+import (
+	"github.com/bobappleyard/er"
+)
+
+// checkDerived makes sure that the paths of t's derived attributes are
+// connected and lead to the attribute or relationship they name, and that
+// derived attributes do not share names with the rest of t. Counted
+// relationships must hold the whole of their key in their source, so that
+// counts can be looked up by key.
+func checkDerived(t *er.EntityType) error {
+	for _, d := range t.Derived {
+		d.Owner = t
+		if findAttr(t, d.Name) != nil || findRel(t, d.Name) != nil || findDerived(t, d.Name) != d {
+			return er.ErrInvalidAttribute
+		}
+		from := t
+		for _, c := range d.Path {
+			if c.Rel == nil || c.Rel.Source != from {
+				return er.ErrInvalidAttribute
+			}
+			from = c.Rel.Target
+		}
+		switch {
+		case (d.Attribute == nil) == (d.Count == nil):
+			return er.ErrInvalidAttribute
+		case d.Attribute != nil && d.Attribute.Owner != from:
+			return er.ErrInvalidAttribute
+		case d.Count != nil && d.Count.Target != from:
+			return er.ErrInvalidAttribute
+		}
+		if d.Count != nil && !holdsKey(d.Count) {
+			return er.ErrInvalidAttribute
+		}
+	}
+	return nil
+}
+
+// copyDerived gives s a copy of the supertype p's derived attributes, which
+// start from the copies of p's relationships and attributes.
+func copyDerived(p, s *er.EntityType) error {
+	for _, d := range p.Derived {
+		c := *d
+		c.Owner = s
+		c.Path = append([]er.Component(nil), d.Path...)
+		if len(c.Path) != 0 {
+			c.Path[0].Rel = findRel(s, c.Path[0].Rel.Name)
+		} else if c.Attribute != nil {
+			c.Attribute = findAttr(s, c.Attribute.Name)
+		}
+		if findDerived(s, c.Name) != nil {
+			return er.ErrInvalidAttribute
+		}
+		s.Derived = append(s.Derived, &c)
+	}
+	return nil
+}
+
+// holdsKey reports whether r's source holds every part of the key it leads to.
+func holdsKey(r *er.Relationship) bool {
+	for _, k := range r.Implementation {
+		if len(k.BasePath) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func findDerived(t *er.EntityType, name string) *er.DerivedAttribute {
+	for _, d := range t.Derived {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
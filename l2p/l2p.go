@@ -39,6 +39,14 @@ func sortRels(m *er.EntityModel) ([]*er.Relationship, error) {
 }
 
 func LogicalToPhysical(m *er.EntityModel) error {
+	if err := implementSubtypes(m); err != nil {
+		return err
+	}
+	for _, t := range m.Types {
+		if err := addSurrogateKey(t); err != nil {
+			return err
+		}
+	}
 	rs, err := sortRels(m)
 	if err != nil {
 		return err
@@ -51,9 +59,152 @@ func LogicalToPhysical(m *er.EntityModel) error {
 			return err
 		}
 	}
+	for _, t := range m.Types {
+		if err := implementIndexes(t); err != nil {
+			return err
+		}
+		if err := checkDerived(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSurrogateKey gives a type that asks for a surrogate key an identifying
+// attribute called "id" to hold it. Such types must have no identifying
+// attributes of their own, but may have identifying relationships.
+func addSurrogateKey(t *er.EntityType) error {
+	var typ er.AttributeType
+	switch t.Surrogate {
+	case er.NoKey:
+		return nil
+	case er.SerialKey:
+		typ = er.IntType
+	case er.UUID4Key, er.UUID7Key:
+		typ = er.StringType
+	default:
+		return er.ErrInvalidAttribute
+	}
+	for _, a := range t.Attributes {
+		if a.Generated != er.NoKey {
+			return nil
+		}
+		if a.Identifying || a.Name == "id" {
+			return er.ErrInvalidAttribute
+		}
+	}
+	t.Attributes = append([]*er.Attribute{{
+		Owner:       t,
+		Name:        "id",
+		Type:        typ,
+		Identifying: true,
+		Generated:   t.Surrogate,
+	}}, t.Attributes...)
+	return nil
+}
+
+// implementIndexes resolves the attributes of the indexes declared on an
+// entity type and declares an index for each relationship whose
+// implementation is not already covered by the key or another index.
+func implementIndexes(t *er.EntityType) error {
+	for _, x := range t.Indexes {
+		if err := resolveIndex(t, x); err != nil {
+			return err
+		}
+	}
+	key := key(t)
+	for _, r := range t.Relationships {
+		var attrs []*er.Attribute
+		var names []string
+		for _, i := range r.Implementation {
+			if len(i.BasePath) == 0 {
+				attrs = append(attrs, i.Source)
+				names = append(names, i.Source.Name)
+			}
+		}
+		if len(attrs) == 0 || hasPrefix(key, attrs) {
+			continue
+		}
+		covered := false
+		for _, x := range t.Indexes {
+			covered = covered || hasPrefix(x.Attributes, attrs)
+		}
+		if covered {
+			continue
+		}
+		t.Indexes = append(t.Indexes, &er.Index{
+			Name:           r.Name,
+			AttributeNames: names,
+			Attributes:     attrs,
+			Owner:          t,
+		})
+	}
 	return nil
 }
 
+func resolveIndex(t *er.EntityType, x *er.Index) error {
+	x.Owner = t
+	if x.Attributes != nil {
+		return nil
+	}
+	for _, name := range x.AttributeNames {
+		a := findAttr(t, name)
+		if a == nil {
+			return er.ErrInvalidAttribute
+		}
+		x.Attributes = append(x.Attributes, a)
+	}
+	return nil
+}
+
+// targetKey lists the attributes of the relationship's target that the
+// relationship leads to. Alternate keys may only use attributes that the
+// target declares or gains through identifying relationships, as the others
+// may not have been implemented yet.
+func targetKey(r *er.Relationship) ([]*er.Attribute, error) {
+	if r.Key == nil {
+		return key(r.Target), nil
+	}
+	if !r.Key.Unique {
+		return nil, er.ErrInvalidAttribute
+	}
+	if err := resolveIndex(r.Target, r.Key); err != nil {
+		return nil, err
+	}
+	return r.Key.Attributes, nil
+}
+
+func key(t *er.EntityType) []*er.Attribute {
+	var res []*er.Attribute
+	for _, a := range t.Attributes {
+		if a.Identifying {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func findAttr(t *er.EntityType, name string) *er.Attribute {
+	for _, a := range t.Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func hasPrefix(attrs, prefix []*er.Attribute) bool {
+	if len(prefix) > len(attrs) {
+		return false
+	}
+	for i, a := range prefix {
+		if attrs[i] != a {
+			return false
+		}
+	}
+	return true
+}
+
 type relationshipImplementation struct {
 	r    *er.Relationship
 	key  []unify.Var
@@ -75,30 +226,34 @@ func (r *relationshipImplementation) create() error {
 	return nil
 }
 
-func term(e *er.EntityType, f func(*er.Attribute) unify.Term) unify.Term {
+func term(e *er.EntityType, key []*er.Attribute, f func(*er.Attribute) unify.Term) unify.Term {
 	t := unify.Apply{Fn: e}
-	for _, a := range e.Attributes {
-		if !a.Identifying {
-			continue
-		}
+	for _, a := range key {
 		t.Args = append(t.Args, f(a))
 	}
 	return t
 }
 
 func (r *relationshipImplementation) initProblem() error {
+	attrs, err := targetKey(r.r)
+	if err != nil {
+		return err
+	}
 	var key []unify.Var
-	target := term(r.r.Target, func(a *er.Attribute) unify.Term {
+	target := term(r.r.Target, attrs, func(a *er.Attribute) unify.Term {
 		v := unify.Var{Of: a}
 		key = append(key, v)
 		return v
 	})
-	source := term(r.r.Target, func(a *er.Attribute) unify.Term {
+	source := term(r.r.Target, attrs, func(a *er.Attribute) unify.Term {
 		return unify.Var{Of: &er.Attribute{
 			Owner:       r.r.Source,
 			Name:        r.r.Name + "_" + a.Name,
 			Type:        a.Type,
 			Identifying: r.r.Identifying,
+			Collation:   a.Collation,
+			EnumName:    a.EnumName,
+			Enum:        a.Enum,
 		}}
 	})
 	subs, err := unify.Unify(target, source, nil)
@@ -172,7 +327,12 @@ func followPath(path []er.Component, subs unify.Subs, sourceAttr func(*er.Attrib
 		for i, a := range cr.Implementation {
 			source.Args[i] = sourceAttr(a.Source, path[:idx])
 		}
-		dest = term(cr.Target, func(a *er.Attribute) unify.Term {
+		var attrs []*er.Attribute
+		attrs, err = targetKey(cr)
+		if err != nil {
+			return nil, nil, err
+		}
+		dest = term(cr.Target, attrs, func(a *er.Attribute) unify.Term {
 			return unify.Var{Of: a}
 		})
 		subs, err = unify.Unify(source, dest, subs)
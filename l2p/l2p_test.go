@@ -1,8 +1,10 @@
 package l2p
 
 import (
+	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	. "github.com/bobappleyard/er"
@@ -518,3 +520,310 @@ func testAttrs(t *testing.T, attrs []*Attribute, names []string) {
 		t.Errorf("expected attrs: %s, got attrs: %s", names, anames)
 	}
 }
+
+func TestIndexes(t *testing.T) {
+	m := EntityModel{
+		Types: []*EntityType{
+			{Name: "a"},
+			{Name: "b"},
+			{Name: "c"},
+		},
+	}
+	for _, t := range m.Types {
+		t.Attributes = []*Attribute{
+			{
+				Owner:       t,
+				Name:        "name",
+				Type:        StringType,
+				Identifying: true,
+			},
+		}
+	}
+	a := m.Types[0]
+	b := m.Types[1]
+	c := m.Types[2]
+	a.Attributes = append(a.Attributes, &Attribute{Owner: a, Name: "size", Type: IntType})
+	a.Indexes = []*Index{
+		{Name: "by_size", AttributeNames: []string{"size", "name"}},
+	}
+	b.Relationships = []*Relationship{
+		{
+			Name:   "parent",
+			Source: b,
+			Target: a,
+		},
+		{
+			Name:   "f",
+			Source: b,
+			Target: c,
+		},
+	}
+	c.Relationships = []*Relationship{
+		{
+			Name:        "parent",
+			Source:      c,
+			Target:      a,
+			Identifying: true,
+		},
+	}
+	b.Relationships[1].Constraints = []Constraint{
+		{
+			Diagonal{Components: []Component{
+				{Rel: b.Relationships[0]},
+			}},
+			Riser{[]Component{
+				{Rel: c.Relationships[0]},
+			}},
+		},
+	}
+
+	if err := LogicalToPhysical(&m); err != nil {
+		t.Fatal(err)
+	}
+	testIndexes(t, a.Indexes, []string{"by_size(size,name)"})
+	testIndexes(t, b.Indexes, []string{"parent(parent_name)", "f(parent_name,f_name)"})
+	testIndexes(t, c.Indexes, nil)
+}
+
+func testIndexes(t *testing.T, indexes []*Index, expected []string) {
+	var got []string
+	for _, x := range indexes {
+		var names []string
+		for _, a := range x.Attributes {
+			if a.Owner != x.Owner {
+				t.Errorf("index %s includes attribute %s", x, a)
+			}
+			names = append(names, a.Name)
+		}
+		got = append(got, fmt.Sprintf("%s(%s)", x.Name, strings.Join(names, ",")))
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected indexes: %s, got indexes: %s", expected, got)
+	}
+}
+
+func TestAlternateKey(t *testing.T) {
+	m := EntityModel{
+		Types: []*EntityType{
+			{Name: "a"},
+			{Name: "b"},
+		},
+	}
+	for _, t := range m.Types {
+		t.Attributes = []*Attribute{
+			{
+				Owner:       t,
+				Name:        "name",
+				Type:        StringType,
+				Identifying: true,
+			},
+		}
+	}
+	a := m.Types[0]
+	b := m.Types[1]
+	a.Attributes = append(a.Attributes, &Attribute{Owner: a, Name: "email", Type: StringType, Collation: "nocase"})
+	a.Indexes = []*Index{
+		{Name: "by_email", AttributeNames: []string{"email"}, Unique: true},
+	}
+	b.Relationships = []*Relationship{
+		{
+			Name:   "owner",
+			Source: b,
+			Target: a,
+			Key:    a.Indexes[0],
+		},
+	}
+
+	if err := LogicalToPhysical(&m); err != nil {
+		t.Fatal(err)
+	}
+	testAttrs(t, b.Attributes, []string{"name", "owner_email"})
+	impl := b.Relationships[0].Implementation
+	if len(impl) != 1 || impl[0].Target != a.Attributes[1] || impl[0].Source.Collation != "nocase" {
+		t.Errorf("got implementation %v", impl)
+	}
+	testIndexes(t, b.Indexes, []string{"owner(owner_email)"})
+
+	a.Indexes[0].Unique = false
+	b.Relationships[0].Implementation = nil
+	if err := LogicalToPhysical(&m); err != ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, ErrInvalidAttribute)
+	}
+}
+
+func subtypeModel(mapping SubtypeMapping) *EntityModel {
+	m := &EntityModel{
+		Types: []*EntityType{
+			{Name: "vehicle"},
+			{Name: "car"},
+			{Name: "boat"},
+			{Name: "trip"},
+		},
+	}
+	v, c, b, t := m.Types[0], m.Types[1], m.Types[2], m.Types[3]
+	v.Subtyping = Subtyping{Exclusive: true, Mapping: mapping}
+	v.Attributes = []*Attribute{
+		{Owner: v, Name: "name", Type: StringType, Identifying: true},
+		{Owner: v, Name: "colour", Type: StringType},
+	}
+	c.Supertype = v
+	c.Attributes = []*Attribute{{Owner: c, Name: "wheels", Type: IntType, Default: "4"}}
+	b.Supertype = v
+	b.Attributes = []*Attribute{{Owner: b, Name: "sails", Type: IntType}}
+	t.Attributes = []*Attribute{{Owner: t, Name: "name", Type: StringType, Identifying: true}}
+	t.Relationships = []*Relationship{{Name: "by", Source: t, Target: c}}
+	return m
+}
+
+func TestSharedKey(t *testing.T) {
+	m := subtypeModel(SharedKey)
+	if err := LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	v, c, trip := m.Types[0], m.Types[1], m.Types[3]
+	if len(m.Types) != 4 || len(v.Subtypes) != 2 || v.Subtypes[0] != c {
+		t.Fatalf("got types %v with subtypes %v", m.Types, v.Subtypes)
+	}
+	r := c.SupertypeRelationship()
+	if r == nil || r.Name != "vehicle" || r.Target != v {
+		t.Fatalf("got supertype relationship %v", r)
+	}
+	testAttrs(t, c.Attributes, []string{"vehicle_name", "wheels"})
+	if !c.Attributes[0].Identifying {
+		t.Errorf("subtype key should be identifying")
+	}
+	testAttrs(t, trip.Attributes, []string{"name", "by_vehicle_name"})
+}
+
+func TestSingleTable(t *testing.T) {
+	m := subtypeModel(SingleTable)
+	if err := LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	v, trip := m.Types[0], m.Types[1]
+	if len(m.Types) != 2 || trip.Name != "trip" || trip.Relationships[0].Target != v {
+		t.Fatalf("got types %v", m.Types)
+	}
+	testAttrs(t, v.Attributes, []string{"name", "colour", "subtype", "wheels", "sails"})
+	tag := findAttr(v, "subtype")
+	if tag.Enum == nil || len(m.Enums) != 1 || tag.Enum != m.Enums[0] || !tag.Optional {
+		t.Errorf("got subtype attribute %v", tag)
+	}
+	if a := findAttr(v, "wheels"); !a.Optional || a.Owner != v || a.Default != "" {
+		t.Errorf("folded attributes should be optional, without defaults")
+	}
+
+	m = subtypeModel(SingleTable)
+	m.Types[0].Subtyping.Exclusive = false
+	if err := LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	testAttrs(t, m.Types[0].Attributes, []string{"name", "colour", "is_car", "is_boat", "wheels", "sails"})
+}
+
+func TestConcreteTypes(t *testing.T) {
+	m := subtypeModel(ConcreteTypes)
+	m.Types[0].Attributes[1].Description = "The paint job."
+	m.Types[2].Attributes = append(m.Types[2].Attributes, &Attribute{Owner: m.Types[2], Name: "hull", Type: IntType, Identifying: true})
+	if err := LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	c, b, trip := m.Types[0], m.Types[1], m.Types[2]
+	if d := findAttr(b, "colour").Description; d != "The paint job." {
+		t.Errorf("got description %q", d)
+	}
+	if len(m.Types) != 3 || c.Name != "car" {
+		t.Fatalf("got types %v", m.Types)
+	}
+	testAttrs(t, c.Attributes, []string{"name", "colour", "wheels"})
+	testAttrs(t, b.Attributes, []string{"name", "hull", "colour", "sails"})
+	if c.Attributes[0].Owner != c || !c.Attributes[0].Identifying {
+		t.Errorf("got attribute %v", c.Attributes[0])
+	}
+	testAttrs(t, trip.Attributes, []string{"name", "by_name"})
+
+	m = subtypeModel(ConcreteTypes)
+	m.Types[3].Relationships[0].Target = m.Types[0]
+	if err := LogicalToPhysical(m); err != ErrInvalidRecord {
+		t.Errorf("got %v, expecting %v", err, ErrInvalidRecord)
+	}
+}
+
+func TestSurrogateKey(t *testing.T) {
+	m := &EntityModel{
+		Types: []*EntityType{
+			{Name: "event", Surrogate: UUID7Key},
+			{Name: "ticket", Surrogate: SerialKey},
+		},
+	}
+	e, tk := m.Types[0], m.Types[1]
+	e.Attributes = []*Attribute{{Owner: e, Name: "title", Type: StringType}}
+	tk.Relationships = []*Relationship{{Name: "event", Source: tk, Target: e}}
+	if err := LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	testAttrs(t, e.Attributes, []string{"id", "title"})
+	testAttrs(t, tk.Attributes, []string{"id", "event_id"})
+	if id := findAttr(e, "id"); !id.Identifying || id.Type != StringType || id.Generated != UUID7Key {
+		t.Errorf("got key %v", id)
+	}
+	if id := findAttr(tk, "id"); id.Type != IntType || id.Generated != SerialKey {
+		t.Errorf("got key %v", id)
+	}
+	if ref := findAttr(tk, "event_id"); ref.Type != StringType || ref.Generated != NoKey || ref.Identifying {
+		t.Errorf("got reference %v", ref)
+	}
+
+	m = &EntityModel{Types: []*EntityType{{Name: "a", Surrogate: SerialKey}}}
+	a := m.Types[0]
+	a.Attributes = []*Attribute{{Owner: a, Name: "name", Type: StringType, Identifying: true}}
+	if err := LogicalToPhysical(m); err != ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, ErrInvalidAttribute)
+	}
+}
+
+func TestDerived(t *testing.T) {
+	m := &EntityModel{
+		Types: []*EntityType{
+			{Name: "region"},
+			{Name: "customer"},
+			{Name: "order"},
+		},
+	}
+	rg, cu, or := m.Types[0], m.Types[1], m.Types[2]
+	rg.Attributes = []*Attribute{{Owner: rg, Name: "name", Type: StringType, Identifying: true}}
+	cu.Attributes = []*Attribute{{Owner: cu, Name: "name", Type: StringType, Identifying: true}}
+	cu.Relationships = []*Relationship{{Name: "region", Source: cu, Target: rg}}
+	or.Attributes = []*Attribute{{Owner: or, Name: "number", Type: IntType, Identifying: true}}
+	or.Relationships = []*Relationship{{Name: "customer", Source: or, Target: cu}}
+	or.Derived = []*DerivedAttribute{{
+		Name:      "region_name",
+		Path:      []Component{{Rel: or.Relationships[0]}, {Rel: cu.Relationships[0]}},
+		Attribute: rg.Attributes[0],
+	}}
+	cu.Derived = []*DerivedAttribute{{Name: "orders", Count: or.Relationships[0]}}
+	if err := LogicalToPhysical(m); err != nil {
+		t.Fatal(err)
+	}
+	if d := or.Derived[0]; d.Owner != or || d.End() != rg || d.Value().Type != StringType || d.Value().Identifying {
+		t.Errorf("got derived attribute %v", d)
+	}
+	if v := cu.Derived[0].Value(); v.Type != IntType || v.Owner != cu {
+		t.Errorf("got count %v", v)
+	}
+
+	for _, d := range []*DerivedAttribute{
+		{Name: "number", Attribute: rg.Attributes[0]},
+		{Name: "x", Attribute: rg.Attributes[0]},
+		{Name: "x", Path: []Component{{Rel: cu.Relationships[0]}}, Attribute: rg.Attributes[0]},
+		{Name: "x", Path: []Component{{Rel: or.Relationships[0]}}, Count: cu.Relationships[0]},
+		{Name: "x", Count: &Relationship{Source: cu, Target: or, Implementation: []Implementation{
+			{BasePath: []Component{{Rel: cu.Relationships[0]}}},
+		}}},
+	} {
+		or.Derived = []*DerivedAttribute{d}
+		if err := checkDerived(or); err != ErrInvalidAttribute {
+			t.Errorf("%s: got %v, expecting %v", d.Name, err, ErrInvalidAttribute)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package l2p
+
+import (
+	"github.com/bobappleyard/er"
+)
+
+// implementSubtypes replaces the hierarchies in the model with the types,
+// attributes and relationships that implement them, according to each
+// supertype's mapping. Only SharedKey hierarchies may be nested.
+func implementSubtypes(m *er.EntityModel) error {
+	for _, t := range m.Types {
+		p := t.Supertype
+		if p == nil || hasType(p.Subtypes, t) {
+			continue
+		}
+		for q, n := p, 0; q != nil; q, n = q.Supertype, n+1 {
+			if q == t || n > len(m.Types) {
+				return er.ErrInvalidRecord
+			}
+		}
+		p.Subtypes = append(p.Subtypes, t)
+	}
+	for _, p := range append([]*er.EntityType(nil), m.Types...) {
+		if len(p.Subtypes) == 0 {
+			continue
+		}
+		if p.Subtyping.Mapping != er.SharedKey && !flat(p) {
+			return er.ErrInvalidRecord
+		}
+		var err error
+		switch p.Subtyping.Mapping {
+		case er.SharedKey:
+			err = shareKey(p)
+		case er.SingleTable:
+			err = foldSubtypes(m, p)
+		case er.ConcreteTypes:
+			err = copySupertype(m, p)
+		default:
+			err = er.ErrInvalidRecord
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flat reports whether p is the only level of its hierarchy.
+func flat(p *er.EntityType) bool {
+	if p.Supertype != nil {
+		return false
+	}
+	for _, s := range p.Subtypes {
+		if len(s.Subtypes) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// shareKey gives each subtype an identifying relationship to the supertype,
+// which is the whole of the subtype's key.
+func shareKey(p *er.EntityType) error {
+	for _, s := range p.Subtypes {
+		if s.SupertypeRelationship() != nil {
+			continue
+		}
+		for _, a := range s.Attributes {
+			if a.Identifying {
+				return er.ErrInvalidAttribute
+			}
+		}
+		for _, r := range s.Relationships {
+			if r.Identifying || r.Name == p.Name {
+				return er.ErrInvalidRecord
+			}
+		}
+		r := &er.Relationship{Name: p.Name, Source: s, Target: p, Identifying: true}
+		s.Relationships = append([]*er.Relationship{r}, s.Relationships...)
+	}
+	return nil
+}
+
+// foldSubtypes moves the attributes, indexes and derived attributes of the
+// subtypes into the supertype and adds attributes recording the subtypes of
+// each entity.
+func foldSubtypes(m *er.EntityModel, p *er.EntityType) error {
+	if !hasAnyType(m.Types, p.Subtypes) {
+		return nil
+	}
+	var attrs []*er.Attribute
+	if p.Subtyping.Exclusive {
+		e := &er.Enum{Name: p.Name + "_subtype"}
+		for _, s := range p.Subtypes {
+			e.Values = append(e.Values, s.Name)
+		}
+		m.Enums = append(m.Enums, e)
+		attrs = append(attrs, &er.Attribute{
+			Owner:    p,
+			Name:     "subtype",
+			Type:     er.EnumType,
+			EnumName: e.Name,
+			Enum:     e,
+			Optional: !p.Subtyping.Total,
+		})
+	} else {
+		for _, s := range p.Subtypes {
+			attrs = append(attrs, &er.Attribute{Owner: p, Name: "is_" + s.Name, Type: er.BoolType})
+		}
+	}
+	for _, s := range p.Subtypes {
+		if len(s.Relationships) != 0 {
+			return er.ErrInvalidRecord
+		}
+		for _, a := range s.Attributes {
+			if a.Identifying {
+				return er.ErrInvalidAttribute
+			}
+			a.Owner = p
+			a.Optional = true
+			a.Default = ""
+			attrs = append(attrs, a)
+		}
+		for _, x := range s.Indexes {
+			x.Owner = p
+			p.Indexes = append(p.Indexes, x)
+		}
+		for _, d := range s.Derived {
+			d.Owner = p
+			p.Derived = append(p.Derived, d)
+		}
+	}
+	for _, a := range attrs {
+		if findAttr(p, a.Name) != nil {
+			return er.ErrInvalidAttribute
+		}
+		p.Attributes = append(p.Attributes, a)
+	}
+	for _, t := range m.Types {
+		for _, r := range t.Relationships {
+			if hasType(p.Subtypes, r.Target) {
+				r.Target = p
+				r.TargetName = p.Name
+			}
+		}
+	}
+	removeTypes(m, p.Subtypes...)
+	return nil
+}
+
+// copySupertype gives each subtype a copy of the supertype's attributes,
+// relationships, indexes and derived attributes, and removes the supertype.
+// The identifying attributes of both come first.
+func copySupertype(m *er.EntityModel, p *er.EntityType) error {
+	if !hasType(m.Types, p) {
+		return nil
+	}
+	for _, t := range m.Types {
+		for _, r := range t.Relationships {
+			if r.Target == p {
+				return er.ErrInvalidRecord
+			}
+		}
+	}
+	for _, r := range p.Relationships {
+		if len(r.Constraints) != 0 {
+			return er.ErrInvalidRecord
+		}
+	}
+	for _, s := range p.Subtypes {
+		if s.Surrogate == er.NoKey {
+			s.Surrogate = p.Surrogate
+		}
+		attrs := make([]*er.Attribute, len(p.Attributes))
+		for i, a := range p.Attributes {
+			if findAttr(s, a.Name) != nil {
+				return er.ErrInvalidAttribute
+			}
+			c := *a
+			c.Owner = s
+			attrs[i] = &c
+		}
+		s.Attributes = keyFirst(append(attrs, s.Attributes...))
+		for _, r := range p.Relationships {
+			if findRel(s, r.Name) != nil {
+				return er.ErrInvalidRecord
+			}
+			c := *r
+			c.Source = s
+			s.Relationships = append(s.Relationships, &c)
+			if r == p.DependsOn {
+				s.DependsOn = &c
+			}
+		}
+		for _, x := range p.Indexes {
+			c := *x
+			c.Owner = s
+			c.Attributes = nil
+			if x.Attributes != nil {
+				c.AttributeNames = nil
+				for _, a := range x.Attributes {
+					c.AttributeNames = append(c.AttributeNames, a.Name)
+				}
+			}
+			s.Indexes = append(s.Indexes, &c)
+		}
+		if err := copyDerived(p, s); err != nil {
+			return err
+		}
+	}
+	removeTypes(m, p)
+	return nil
+}
+
+// keyFirst moves the identifying attributes to the front, keeping their order,
+// so that the key of a type is a prefix of its attributes.
+func keyFirst(attrs []*er.Attribute) []*er.Attribute {
+	res := make([]*er.Attribute, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Identifying {
+			res = append(res, a)
+		}
+	}
+	for _, a := range attrs {
+		if !a.Identifying {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func removeTypes(m *er.EntityModel, ts ...*er.EntityType) {
+	var res []*er.EntityType
+	for _, t := range m.Types {
+		if !hasType(ts, t) {
+			res = append(res, t)
+		}
+	}
+	m.Types = res
+}
+
+func hasType(ts []*er.EntityType, t *er.EntityType) bool {
+	for _, u := range ts {
+		if u == t {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyType(ts, us []*er.EntityType) bool {
+	for _, u := range us {
+		if hasType(ts, u) {
+			return true
+		}
+	}
+	return false
+}
+
+func findRel(t *er.EntityType, name string) *er.Relationship {
+	for _, r := range t.Relationships {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/gen"
+)
+
+// Go renders a skeleton data migration between the in-memory models generated
+// for each version of the model. The skeleton copies every entity whose type
+// survives, carrying over the attributes that are unchanged, and leaves TODO
+// comments where a decision is needed.
+func (p *Plan) Go(pkg, fromPath, toPath string) ([]byte, error) {
+	var buf bytes.Buffer
+	out := func(form string, args ...interface{}) {
+		fmt.Fprintf(&buf, form+"\n", args...)
+	}
+	out("package %s", pkg)
+	out("import (")
+	out("from %q", fromPath)
+	out("to %q", toPath)
+	out(")")
+	out("")
+	out("// Migrate copies the data held in src into dst.")
+	out("func Migrate(src *from.Model, dst *to.Model) error {")
+	for _, c := range p.Changes {
+		if c.Kind == RemoveType {
+			out("// TODO: %s has been removed", c.Type)
+		}
+	}
+	for _, t := range p.To.Types {
+		old := findType(p.From, t.Name)
+		if old == nil {
+			out("// TODO: populate %s, which has been added", t.Name)
+			continue
+		}
+		out("if err := src.%s.ForEach(func(e from.%[1]s) error {", gen.GoName(t.Name))
+		for _, a := range t.Attributes {
+			o := findAttr(old, a.Name)
+			if o == nil || o.Type != er.EnumType || a.Type != er.EnumType || o.Optional != a.Optional {
+				continue
+			}
+			if a.Optional {
+				out("var new%s *to.%s", gen.GoName(a.Name), gen.GoName(a.Enum.Name))
+				out("if e.%s != nil {", gen.GoName(a.Name))
+				out("v, err := to.Parse%s(e.%s.String())", gen.GoName(a.Enum.Name), gen.GoName(a.Name))
+				out("if err != nil {")
+				out("return err")
+				out("}")
+				out("new%s = &v", gen.GoName(a.Name))
+				out("}")
+				continue
+			}
+			out("new%s, err := to.Parse%s(e.%[1]s.String())", gen.GoName(a.Name), gen.GoName(a.Enum.Name))
+			out("if err != nil {")
+			out("return err")
+			out("}")
+		}
+		out("return dst.%s.Insert(to.%[1]s{", gen.GoName(t.Name))
+		for _, a := range t.Attributes {
+			o := findAttr(old, a.Name)
+			switch {
+			case o == nil:
+				out("// TODO: %s has been added", gen.GoName(a.Name))
+			case o.Type != a.Type:
+				out("// TODO: %s has changed type", gen.GoName(a.Name))
+			case o.Optional != a.Optional:
+				out("// TODO: %s has changed optionality", gen.GoName(a.Name))
+			case a.Type == er.EnumType:
+				out("%s: new%[1]s,", gen.GoName(a.Name))
+			default:
+				out("%s: e.%[1]s,", gen.GoName(a.Name))
+			}
+		}
+		out("})")
+		out("}); err != nil {")
+		out("return err")
+		out("}")
+	}
+	out("return dst.Validate()")
+	out("}")
+	return format.Source(buf.Bytes())
+}
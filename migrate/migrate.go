@@ -0,0 +1,348 @@
+// Package migrate compares versions of a physical entity model and produces
+// migrations between them.
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/bobappleyard/er"
+)
+
+// Plan holds the changes needed to move from one version of a model to
+// another. Both models should have been through LogicalToPhysical.
+type Plan struct {
+	From, To *er.EntityModel
+	Changes  []Change
+}
+
+// Change is a single difference between two versions of a model.
+type Change struct {
+	Kind      Kind
+	Type      string
+	Attribute string
+	Old, New  interface{}
+}
+
+// Kind identifies what sort of change has been made. For each kind, Old and
+// New hold the corresponding element in each version of the model, or nil if
+// it is absent.
+type Kind byte
+
+const (
+	AddType          Kind = iota + 1 // *er.EntityType
+	RemoveType                       // *er.EntityType
+	AddAttribute                     // *er.Attribute
+	RemoveAttribute                  // *er.Attribute
+	ChangeAttribute                  // *er.Attribute
+	ChangeKey                        // []*er.Attribute
+	AddForeignKey                    // ForeignKey
+	RemoveForeignKey                 // ForeignKey
+	AddUniqueKey                     // *er.Index
+	RemoveUniqueKey                  // *er.Index
+	AddIndex                         // *er.Index
+	RemoveIndex                      // *er.Index
+)
+
+// ForeignKey is the physical implementation of a relationship. Key lists the
+// attributes of Target that the relationship leads to, which are its
+// identifying attributes unless the relationship uses an alternate key.
+// Columns lists the attributes of Source that hold them, in the same order.
+// Where part of the key is derived through a constraint rather than held
+// directly, the corresponding entry is nil.
+type ForeignKey struct {
+	Name           string
+	Source, Target *er.EntityType
+	Key, Columns   []*er.Attribute
+}
+
+// Complete reports whether every part of the key is held in Source.
+func (k ForeignKey) Complete() bool {
+	for _, c := range k.Columns {
+		if c == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Change) String() string {
+	name := c.Type
+	if c.Attribute != "" {
+		name += "." + c.Attribute
+	}
+	return fmt.Sprintf("%s %s", c.Kind, name)
+}
+
+func (k Kind) String() string {
+	switch k {
+	case AddType:
+		return "add type"
+	case RemoveType:
+		return "remove type"
+	case AddAttribute:
+		return "add attribute"
+	case RemoveAttribute:
+		return "remove attribute"
+	case ChangeAttribute:
+		return "change attribute"
+	case ChangeKey:
+		return "change key"
+	case AddForeignKey:
+		return "add foreign key"
+	case RemoveForeignKey:
+		return "remove foreign key"
+	case AddUniqueKey:
+		return "add unique key"
+	case RemoveUniqueKey:
+		return "remove unique key"
+	case AddIndex:
+		return "add index"
+	case RemoveIndex:
+		return "remove index"
+	}
+	return "invalid change"
+}
+
+// Compare works out the changes between two versions of a model. Either may be
+// nil, standing for an empty model.
+func Compare(from, to *er.EntityModel) *Plan {
+	if from == nil {
+		from = &er.EntityModel{}
+	}
+	if to == nil {
+		to = &er.EntityModel{}
+	}
+	p := &Plan{From: from, To: to}
+	for _, t := range from.Types {
+		if findType(to, t.Name) == nil {
+			p.add(Change{Kind: RemoveType, Type: t.Name, Old: t})
+			for _, k := range ForeignKeys(t) {
+				p.add(Change{Kind: RemoveForeignKey, Type: t.Name, Attribute: k.Name, Old: k})
+			}
+		}
+	}
+	for _, t := range to.Types {
+		old := findType(from, t.Name)
+		if old == nil {
+			p.add(Change{Kind: AddType, Type: t.Name, New: t})
+			for _, x := range UniqueKeys(t) {
+				p.add(Change{Kind: AddUniqueKey, Type: t.Name, Attribute: x.Name, New: x})
+			}
+			for _, x := range Indexes(t) {
+				p.add(Change{Kind: AddIndex, Type: t.Name, Attribute: x.Name, New: x})
+			}
+			for _, k := range ForeignKeys(t) {
+				p.add(Change{Kind: AddForeignKey, Type: t.Name, Attribute: k.Name, New: k})
+			}
+			continue
+		}
+		p.compareType(old, t)
+	}
+	return p
+}
+
+func (p *Plan) add(c Change) {
+	p.Changes = append(p.Changes, c)
+}
+
+func (p *Plan) compareType(from, to *er.EntityType) {
+	for _, a := range from.Attributes {
+		if findAttr(to, a.Name) == nil {
+			p.add(Change{Kind: RemoveAttribute, Type: to.Name, Attribute: a.Name, Old: a})
+		}
+	}
+	for _, a := range to.Attributes {
+		old := findAttr(from, a.Name)
+		switch {
+		case old == nil:
+			p.add(Change{Kind: AddAttribute, Type: to.Name, Attribute: a.Name, New: a})
+		case old.Type != a.Type, old.Optional != a.Optional, old.Default != a.Default,
+			old.Collation != a.Collation, !sameValues(old, a), !sameRules(old, a):
+			p.add(Change{Kind: ChangeAttribute, Type: to.Name, Attribute: a.Name, Old: old, New: a})
+		}
+	}
+	oldKey, newKey := Key(from), Key(to)
+	if !sameAttrs(oldKey, newKey) {
+		p.add(Change{Kind: ChangeKey, Type: to.Name, Old: oldKey, New: newKey})
+	}
+	oldUKs, newUKs := UniqueKeys(from), UniqueKeys(to)
+	for _, x := range oldUKs {
+		if n := findIndex(newUKs, x.Name); n == nil || !sameAttrs(x.Attributes, n.Attributes) {
+			p.add(Change{Kind: RemoveUniqueKey, Type: to.Name, Attribute: x.Name, Old: x})
+		}
+	}
+	for _, x := range newUKs {
+		if o := findIndex(oldUKs, x.Name); o == nil || !sameAttrs(o.Attributes, x.Attributes) {
+			p.add(Change{Kind: AddUniqueKey, Type: to.Name, Attribute: x.Name, New: x})
+		}
+	}
+	oldXs, newXs := Indexes(from), Indexes(to)
+	for _, x := range oldXs {
+		if n := findIndex(newXs, x.Name); n == nil || !sameAttrs(x.Attributes, n.Attributes) {
+			p.add(Change{Kind: RemoveIndex, Type: to.Name, Attribute: x.Name, Old: x})
+		}
+	}
+	for _, x := range newXs {
+		if o := findIndex(oldXs, x.Name); o == nil || !sameAttrs(o.Attributes, x.Attributes) {
+			p.add(Change{Kind: AddIndex, Type: to.Name, Attribute: x.Name, New: x})
+		}
+	}
+	oldFKs, newFKs := ForeignKeys(from), ForeignKeys(to)
+	for _, k := range oldFKs {
+		if n, ok := findKey(newFKs, k.Name); !ok || !sameKey(k, n) {
+			p.add(Change{Kind: RemoveForeignKey, Type: to.Name, Attribute: k.Name, Old: k})
+		}
+	}
+	for _, k := range newFKs {
+		if o, ok := findKey(oldFKs, k.Name); !ok || !sameKey(o, k) {
+			p.add(Change{Kind: AddForeignKey, Type: to.Name, Attribute: k.Name, New: k})
+		}
+	}
+}
+
+// Key returns the identifying attributes of an entity type.
+func Key(t *er.EntityType) []*er.Attribute {
+	var res []*er.Attribute
+	for _, a := range t.Attributes {
+		if a.Identifying {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// UniqueKeys returns the unique indexes of an entity type, which hold its
+// alternate keys.
+func UniqueKeys(t *er.EntityType) []*er.Index {
+	var res []*er.Index
+	for _, x := range t.Indexes {
+		if x.Unique {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// Indexes returns the indexes of an entity type that are not unique.
+func Indexes(t *er.EntityType) []*er.Index {
+	var res []*er.Index
+	for _, x := range t.Indexes {
+		if !x.Unique {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+// ForeignKeys returns the foreign keys implementing the relationships of an
+// entity type.
+func ForeignKeys(t *er.EntityType) []ForeignKey {
+	var res []ForeignKey
+	for _, r := range t.Relationships {
+		k := ForeignKey{Name: r.Name, Source: t, Target: r.Target, Key: Key(r.Target)}
+		if r.Key != nil {
+			k.Key = r.Key.Attributes
+		}
+		for _, a := range k.Key {
+			var col *er.Attribute
+			for _, i := range r.Implementation {
+				if i.Target == a && len(i.BasePath) == 0 {
+					col = i.Source
+				}
+			}
+			k.Columns = append(k.Columns, col)
+		}
+		res = append(res, k)
+	}
+	return res
+}
+
+func findType(m *er.EntityModel, name string) *er.EntityType {
+	for _, t := range m.Types {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func findAttr(t *er.EntityType, name string) *er.Attribute {
+	for _, a := range t.Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func findIndex(xs []*er.Index, name string) *er.Index {
+	for _, x := range xs {
+		if x.Name == name {
+			return x
+		}
+	}
+	return nil
+}
+
+func findKey(ks []ForeignKey, name string) (ForeignKey, bool) {
+	for _, k := range ks {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return ForeignKey{}, false
+}
+
+func sameAttrs(xs, ys []*er.Attribute) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	for i := range xs {
+		if (xs[i] == nil) != (ys[i] == nil) {
+			return false
+		}
+		if xs[i] != nil && (xs[i].Name != ys[i].Name || xs[i].Type != ys[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameValues reports whether two attributes take the same enum values, in the
+// same order.
+func sameValues(x, y *er.Attribute) bool {
+	if (x.Enum == nil) != (y.Enum == nil) {
+		return false
+	}
+	if x.Enum == nil {
+		return true
+	}
+	if len(x.Enum.Values) != len(y.Enum.Values) {
+		return false
+	}
+	for i, v := range x.Enum.Values {
+		if y.Enum.Values[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sameRules reports whether two attributes have the same rules.
+func sameRules(x, y *er.Attribute) bool {
+	return sameBound(x.Min, y.Min) && sameBound(x.Max, y.Max) &&
+		x.Pattern == y.Pattern && x.MaxLength == y.MaxLength && x.NonEmpty == y.NonEmpty
+}
+
+func sameBound(x, y *float64) bool {
+	if x == nil || y == nil {
+		return x == y
+	}
+	return *x == *y
+}
+
+func sameKey(x, y ForeignKey) bool {
+	return x.Target.Name == y.Target.Name &&
+		sameAttrs(x.Key, y.Key) &&
+		sameAttrs(x.Columns, y.Columns)
+}
@@ -0,0 +1,358 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/l2p"
+)
+
+func testModel(version int) *EntityModel {
+	m := &EntityModel{
+		Name: "test",
+		Types: []*EntityType{
+			{Name: "a"},
+			{Name: "c"},
+		},
+		Enums: []*Enum{
+			{Name: "state", Values: []string{"on", "off"}},
+		},
+	}
+	if version == 1 {
+		m.Types = append(m.Types, &EntityType{Name: "old"})
+	} else {
+		m.Types = append(m.Types, &EntityType{Name: "new"})
+	}
+	for _, t := range m.Types {
+		t.Attributes = []*Attribute{
+			{
+				Owner:       t,
+				Name:        "name",
+				Type:        StringType,
+				Identifying: true,
+			},
+		}
+	}
+	a := m.Types[0]
+	c := m.Types[1]
+	c.Attributes = append(c.Attributes, &Attribute{Owner: c, Name: "state", Type: EnumType, Enum: m.Enums[0]})
+	if version != 1 {
+		c.Attributes[1].Default = "off"
+	}
+	c.Attributes = append(c.Attributes, &Attribute{Owner: c, Name: "note", Type: StringType, Optional: version != 1})
+	if version == 1 {
+		a.Attributes = append(a.Attributes, &Attribute{Owner: a, Name: "size", Type: StringType})
+		a.Attributes = append(a.Attributes, &Attribute{Owner: a, Name: "dropped", Type: IntType})
+		a.Indexes = []*Index{{Name: "by_dropped", AttributeNames: []string{"dropped"}, Unique: true}}
+	} else {
+		a.Attributes = append(a.Attributes, &Attribute{Owner: a, Name: "size", Type: IntType})
+		a.Attributes = append(a.Attributes, &Attribute{Owner: a, Name: "region", Type: StringType})
+		a.Indexes = []*Index{{Name: "by_region", AttributeNames: []string{"region"}, Unique: true}}
+		n := m.Types[2]
+		n.Relationships = []*Relationship{{Name: "owner", Source: n, Target: a, Key: a.Indexes[0]}}
+	}
+	c.Relationships = []*Relationship{
+		{
+			Name:        "parent",
+			Source:      c,
+			Target:      a,
+			Identifying: version != 1,
+		},
+	}
+	if version == 1 {
+		c.Relationships = append(c.Relationships, &Relationship{
+			Name:   "link",
+			Source: c,
+			Target: m.Types[2],
+		})
+	}
+	l2p.LogicalToPhysical(m)
+	return m
+}
+
+func TestCompare(t *testing.T) {
+	p := Compare(testModel(1), testModel(2))
+	var got []string
+	for _, c := range p.Changes {
+		got = append(got, c.String())
+	}
+	expected := []string{
+		"remove type old",
+		"remove attribute a.dropped",
+		"change attribute a.size",
+		"add attribute a.region",
+		"remove unique key a.by_dropped",
+		"add unique key a.by_region",
+		"remove attribute c.link_name",
+		"change attribute c.state",
+		"change attribute c.note",
+		"change key c",
+		"remove index c.parent",
+		"remove index c.link",
+		"remove foreign key c.link",
+		"add type new",
+		"add index new.owner",
+		"add foreign key new.owner",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got changes:\n%s\nexpecting:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestSQL(t *testing.T) {
+	got := string(Compare(testModel(1), testModel(2)).SQL())
+	expected := `ALTER TABLE "c" DROP CONSTRAINT "c_link_fkey";
+ALTER TABLE "a" DROP CONSTRAINT "a_by_dropped_key";
+ALTER TABLE "c" DROP CONSTRAINT "c_pkey";
+DROP INDEX "c_parent_idx";
+DROP INDEX "c_link_idx";
+DROP TABLE "old";
+ALTER TABLE "a" DROP COLUMN "dropped";
+ALTER TABLE "c" DROP COLUMN "link_name";
+ALTER TABLE "a" ALTER COLUMN "size" TYPE BIGINT;
+ALTER TABLE "a" ADD COLUMN "region" TEXT NOT NULL;
+ALTER TABLE "c" ALTER COLUMN "state" SET DEFAULT 'off';
+ALTER TABLE "c" ALTER COLUMN "note" DROP NOT NULL;
+ALTER TABLE "a" ADD CONSTRAINT "a_by_region_key" UNIQUE ("region");
+ALTER TABLE "c" ADD PRIMARY KEY ("parent_name", "name");
+CREATE TABLE "new" (
+	"name" TEXT NOT NULL,
+	"owner_region" TEXT NOT NULL,
+	PRIMARY KEY ("name")
+);
+CREATE INDEX "new_owner_idx" ON "new" ("owner_region");
+ALTER TABLE "new" ADD CONSTRAINT "new_owner_fkey" FOREIGN KEY ("owner_region") REFERENCES "a" ("region");
+`
+	if got != expected {
+		t.Errorf("got:\n%s\nexpecting:\n%s", got, expected)
+	}
+}
+
+func TestChangeAttribute(t *testing.T) {
+	model := func(collation string, values ...string) *EntityModel {
+		state := &Enum{Name: "state", Values: values}
+		u := &EntityType{Name: "user"}
+		u.Attributes = []*Attribute{
+			{Owner: u, Name: "name", Type: StringType, Identifying: true, Collation: collation},
+			{Owner: u, Name: "state", Type: EnumType, Enum: state},
+		}
+		return &EntityModel{Types: []*EntityType{u}, Enums: []*Enum{state}}
+	}
+	for _, test := range []struct {
+		name     string
+		from, to *EntityModel
+		expected string
+	}{
+		{"Same", model("sv", "on", "off"), model("sv", "on", "off"), ""},
+		{"Collation", model("", "on", "off"), model("sv", "on", "off"),
+			`ALTER TABLE "user" ALTER COLUMN "name" TYPE TEXT COLLATE "sv-x-icu";` + "\n"},
+		{"Binary", model("sv", "on", "off"), model("binary", "on", "off"),
+			`ALTER TABLE "user" ALTER COLUMN "name" TYPE TEXT COLLATE "C";` + "\n"},
+		{"Default", model("sv", "on", "off"), model("", "on", "off"),
+			`ALTER TABLE "user" ALTER COLUMN "name" TYPE TEXT COLLATE "default";` + "\n"},
+		{"NoCase", model("", "on", "off"), model("nocase", "on", "off"),
+			`ALTER TABLE "user" ALTER COLUMN "name" TYPE TEXT;` + "\n" +
+				"-- user.name compares strings as nocase, which needs a collation made for it\n"},
+		{"AddValue", model("", "on", "off"), model("", "on", "off", "dim"),
+			`ALTER TABLE "user" DROP CONSTRAINT "user_state_check";` + "\n" +
+				`ALTER TABLE "user" ADD CONSTRAINT "user_state_check" CHECK ("state" IN ('on', 'off', 'dim'));` + "\n"},
+		{"RemoveValue", model("", "on", "off"), model("", "on"),
+			`ALTER TABLE "user" DROP CONSTRAINT "user_state_check";` + "\n" +
+				`ALTER TABLE "user" ADD CONSTRAINT "user_state_check" CHECK ("state" IN ('on'));` + "\n"},
+	} {
+		p := Compare(test.from, test.to)
+		changes := 1
+		if test.expected == "" {
+			changes = 0
+		}
+		if len(p.Changes) != changes || changes != 0 && p.Changes[0].Kind != ChangeAttribute {
+			t.Errorf("%s: got changes %v", test.name, p.Changes)
+		}
+		if got := string(p.SQL()); got != test.expected {
+			t.Errorf("%s: got:\n%s\nexpecting:\n%s", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestChangeRules(t *testing.T) {
+	zero, ten, otherTen := 0.0, 10.0, 10.0
+	model := func(size, name Attribute) *EntityModel {
+		r := &EntityType{Name: "rule"}
+		size.Owner, size.Name, size.Type = r, "size", IntType
+		name.Owner, name.Name, name.Type = r, "name", StringType
+		r.Attributes = []*Attribute{&size, &name}
+		return &EntityModel{Types: []*EntityType{r}}
+	}
+	for _, test := range []struct {
+		name     string
+		from, to *EntityModel
+		expected string
+	}{
+		{"SameBounds", model(Attribute{Max: &ten}, Attribute{}), model(Attribute{Max: &otherTen}, Attribute{}), ""},
+		{"AddBounds", model(Attribute{}, Attribute{}), model(Attribute{Min: &zero, Max: &ten}, Attribute{}),
+			`ALTER TABLE "rule" ADD CONSTRAINT "rule_size_check" CHECK ("size" >= 0 AND "size" <= 10);` + "\n"},
+		{"RemoveBounds", model(Attribute{Min: &zero}, Attribute{}), model(Attribute{}, Attribute{}),
+			`ALTER TABLE "rule" DROP CONSTRAINT "rule_size_check";` + "\n"},
+		{"ChangeBound", model(Attribute{Max: &zero}, Attribute{}), model(Attribute{Max: &ten}, Attribute{}),
+			`ALTER TABLE "rule" DROP CONSTRAINT "rule_size_check";` + "\n" +
+				`ALTER TABLE "rule" ADD CONSTRAINT "rule_size_check" CHECK ("size" <= 10);` + "\n"},
+		{"Pattern", model(Attribute{}, Attribute{}), model(Attribute{}, Attribute{Pattern: "[a-z]+"}),
+			`ALTER TABLE "rule" ADD CONSTRAINT "rule_name_check" CHECK ("name" ~ '^(?:[a-z]+)$');` + "\n"},
+		{"MaxLength", model(Attribute{}, Attribute{MaxLength: 5, NonEmpty: true}), model(Attribute{}, Attribute{MaxLength: 8, NonEmpty: true}),
+			`ALTER TABLE "rule" DROP CONSTRAINT "rule_name_check";` + "\n" +
+				`ALTER TABLE "rule" ADD CONSTRAINT "rule_name_check" CHECK (char_length("name") > 0 AND char_length("name") <= 8);` + "\n"},
+		{"Create", nil, model(Attribute{Min: &zero}, Attribute{NonEmpty: true}), `CREATE TABLE "rule" (
+	"size" BIGINT NOT NULL CONSTRAINT "rule_size_check" CHECK ("size" >= 0),
+	"name" TEXT NOT NULL CONSTRAINT "rule_name_check" CHECK (char_length("name") > 0)
+);
+`},
+	} {
+		p := Compare(test.from, test.to)
+		if test.from != nil && (len(p.Changes) != 0) != (test.expected != "") {
+			t.Errorf("%s: got changes %v", test.name, p.Changes)
+		}
+		if got := string(p.SQL()); got != test.expected {
+			t.Errorf("%s: got:\n%s\nexpecting:\n%s", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	model := func(attrs ...string) *EntityModel {
+		o := &EntityType{Name: "order"}
+		o.Attributes = []*Attribute{
+			{Owner: o, Name: "number", Type: IntType, Identifying: true},
+			{Owner: o, Name: "group", Type: StringType},
+			{Owner: o, Name: "select", Type: StringType},
+		}
+		if len(attrs) != 0 {
+			o.Indexes = []*Index{{Name: "by_group", AttributeNames: attrs}}
+		}
+		m := &EntityModel{Types: []*EntityType{o}}
+		l2p.LogicalToPhysical(m)
+		return m
+	}
+	for _, test := range []struct {
+		name     string
+		from, to *EntityModel
+		expected string
+	}{
+		{"Create", nil, model("group"), `CREATE TABLE "order" (
+	"number" BIGINT NOT NULL,
+	"group" TEXT NOT NULL,
+	"select" TEXT NOT NULL,
+	PRIMARY KEY ("number")
+);
+CREATE INDEX "order_by_group_idx" ON "order" ("group");
+`},
+		{"Add", model(), model("group", "select"),
+			`CREATE INDEX "order_by_group_idx" ON "order" ("group", "select");` + "\n"},
+		{"Change", model("group"), model("group", "select"),
+			`DROP INDEX "order_by_group_idx";` + "\n" +
+				`CREATE INDEX "order_by_group_idx" ON "order" ("group", "select");` + "\n"},
+		{"Remove", model("group"), model(), `DROP INDEX "order_by_group_idx";` + "\n"},
+	} {
+		if got := string(Compare(test.from, test.to).SQL()); got != test.expected {
+			t.Errorf("%s: got:\n%s\nexpecting:\n%s", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestDDL(t *testing.T) {
+	got := string(DDL(testModel(1)))
+	for _, s := range []string{
+		"CREATE TABLE \"c\" (\n\t\"name\" TEXT NOT NULL,\n",
+		"\t\"parent_name\" TEXT NOT NULL,\n",
+		"\t\"state\" TEXT NOT NULL CONSTRAINT \"c_state_check\" CHECK (\"state\" IN ('on', 'off')),\n",
+		"\tPRIMARY KEY (\"name\")\n);\n",
+		"ALTER TABLE \"c\" ADD CONSTRAINT \"c_parent_fkey\" FOREIGN KEY (\"parent_name\") REFERENCES \"a\" (\"name\");\n",
+		"ALTER TABLE \"c\" ADD CONSTRAINT \"c_link_fkey\" FOREIGN KEY (\"link_name\") REFERENCES \"old\" (\"name\");\n",
+		"ALTER TABLE \"a\" ADD CONSTRAINT \"a_by_dropped_key\" UNIQUE (\"dropped\");\n",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("missing %q in:\n%s", s, got)
+		}
+	}
+	if got := string(DDL(testModel(2))); !strings.Contains(got, "\t\"note\" TEXT,\n") {
+		t.Errorf("missing optional column in:\n%s", got)
+	}
+	m := &EntityModel{Types: []*EntityType{{Name: "ticket", Surrogate: SerialKey, Doc: Doc{Description: "A customer's request."}}}}
+	l2p.LogicalToPhysical(m)
+	m.Types[0].Attributes[0].Description = "Counts up."
+	for _, s := range []string{
+		"COMMENT ON TABLE \"ticket\" IS 'A customer''s request.';\n",
+		"COMMENT ON COLUMN \"ticket\".\"id\" IS 'Counts up.';\n",
+	} {
+		if got := string(DDL(m)); !strings.Contains(got, s) {
+			t.Errorf("missing %q in:\n%s", s, got)
+		}
+	}
+	if got := string(DDL(m)); !strings.Contains(got, "\t\"id\" BIGINT NOT NULL GENERATED BY DEFAULT AS IDENTITY,\n") {
+		t.Errorf("missing serial column in:\n%s", got)
+	}
+	d := &EntityType{Name: "defaults"}
+	d.Attributes = []*Attribute{
+		{Owner: d, Name: "n", Type: IntType, Default: "sequence()"},
+		{Owner: d, Name: "size", Type: FloatType, Default: "1.5"},
+		{Owner: d, Name: "open", Type: BoolType, Default: "true"},
+		{Owner: d, Name: "title", Type: StringType, Optional: true, Default: "it's"},
+		{Owner: d, Name: "day", Type: DateType, Default: "now()"},
+		{Owner: d, Name: "data", Type: BytesType, Default: "0x00ff"},
+	}
+	got = string(DDL(&EntityModel{Types: []*EntityType{d}}))
+	for _, s := range []string{
+		"\t\"n\" BIGINT NOT NULL GENERATED BY DEFAULT AS IDENTITY,\n",
+		"\t\"size\" DOUBLE PRECISION NOT NULL DEFAULT 1.5,\n",
+		"\t\"open\" BOOLEAN NOT NULL DEFAULT TRUE,\n",
+		"\t\"title\" TEXT DEFAULT 'it''s',\n",
+		"\t\"day\" DATE NOT NULL DEFAULT CURRENT_DATE,\n",
+		"\t\"data\" BYTEA NOT NULL DEFAULT '\\x00ff'\n",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("missing %q in:\n%s", s, got)
+		}
+	}
+}
+
+func TestReservedWords(t *testing.T) {
+	o := &EntityType{Name: "order"}
+	o.Attributes = []*Attribute{
+		{Owner: o, Name: "group", Type: StringType, Identifying: true},
+		{Owner: o, Name: "say \"hi\"", Type: StringType, Doc: Doc{Description: "Quoted."}},
+	}
+	got := string(DDL(&EntityModel{Types: []*EntityType{o}}))
+	expected := `CREATE TABLE "order" (
+	"group" TEXT NOT NULL,
+	"say ""hi""" TEXT NOT NULL,
+	PRIMARY KEY ("group")
+);
+COMMENT ON COLUMN "order"."say ""hi""" IS 'Quoted.';
+`
+	if got != expected {
+		t.Errorf("got:\n%s\nexpecting:\n%s", got, expected)
+	}
+}
+
+func TestGo(t *testing.T) {
+	bs, err := Compare(testModel(1), testModel(2)).Go("migration", "example.com/v1", "example.com/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(bs)
+	for _, s := range []string{
+		"func Migrate(src *from.Model, dst *to.Model) error {",
+		"// TODO: old has been removed",
+		"// TODO: Size has changed type",
+		"// TODO: Region has been added",
+		"// TODO: Note has changed optionality",
+		"ParentName: e.ParentName,",
+		"newState, err := to.ParseState(e.State.String())",
+		"newState,\n",
+		"// TODO: populate new, which has been added",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("missing %q in:\n%s", s, got)
+		}
+	}
+}
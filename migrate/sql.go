@@ -0,0 +1,319 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+// DDL renders the statements needed to create a model from scratch.
+func DDL(m *er.EntityModel) []byte {
+	return Compare(nil, m).SQL()
+}
+
+// SQL renders the plan as a script of SQL statements. Foreign keys are dropped
+// before anything else and added after everything else, so that the order of
+// the changes within the model does not matter.
+func (p *Plan) SQL() []byte {
+	type statement struct {
+		phase int
+		text  string
+	}
+	var stmts []statement
+	out := func(phase int, form string, args ...interface{}) {
+		stmts = append(stmts, statement{phase, fmt.Sprintf(form, args...)})
+	}
+	for _, c := range p.Changes {
+		table, col := ident(c.Type), ident(c.Attribute)
+		switch c.Kind {
+		case RemoveForeignKey:
+			k := c.Old.(ForeignKey)
+			if k.Complete() {
+				out(0, "ALTER TABLE %s DROP CONSTRAINT %s;", table, fkeyName(k))
+			}
+		case RemoveUniqueKey:
+			out(1, "ALTER TABLE %s DROP CONSTRAINT %s;", table, ident(c.Type+"_"+c.Attribute+"_key"))
+		case RemoveIndex:
+			out(1, "DROP INDEX %s;", ident(c.Type+"_"+c.Attribute+"_idx"))
+		case ChangeKey:
+			if len(c.Old.([]*er.Attribute)) != 0 {
+				out(1, "ALTER TABLE %s DROP CONSTRAINT %s;", table, ident(c.Type+"_pkey"))
+			}
+			if key := c.New.([]*er.Attribute); len(key) != 0 {
+				out(4, "ALTER TABLE %s ADD PRIMARY KEY (%s);", table, columnList(key))
+			}
+		case RemoveType:
+			out(2, "DROP TABLE %s;", table)
+		case RemoveAttribute:
+			out(2, "ALTER TABLE %s DROP COLUMN %s;", table, col)
+		case ChangeAttribute:
+			o, a := c.Old.(*er.Attribute), c.New.(*er.Attribute)
+			oldCheck, newCheck := check(o), check(a)
+			if oldCheck != newCheck && oldCheck != "" {
+				out(3, "ALTER TABLE %s DROP CONSTRAINT %s;", table, checkName(c.Type, a))
+			}
+			if o.Type != a.Type || o.Collation != a.Collation {
+				typ := columnType(a)
+				if a.Collation == "" && o.Collation != "" {
+					typ += ` COLLATE "default"`
+				}
+				out(3, "ALTER TABLE %s ALTER COLUMN %s TYPE %s;", table, col, typ)
+				if note := collationNote(c.Type, a); note != "" && o.Collation != a.Collation {
+					out(3, "%s", note)
+				}
+			}
+			switch {
+			case a.Optional && !o.Optional:
+				out(3, "ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;", table, col)
+			case o.Optional && !a.Optional:
+				out(3, "ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;", table, col)
+			}
+			if o.Default != a.Default {
+				switch {
+				case o.Default == rtl.Sequence:
+					out(3, "ALTER TABLE %s ALTER COLUMN %s DROP IDENTITY;", table, col)
+				case o.Default != "":
+					out(3, "ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT;", table, col)
+				}
+				switch {
+				case a.Default == rtl.Sequence:
+					out(3, "ALTER TABLE %s ALTER COLUMN %s ADD GENERATED BY DEFAULT AS IDENTITY;", table, col)
+				case a.Default != "":
+					out(3, "ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s;", table, col, defaultValue(a))
+				}
+			}
+			if oldCheck != newCheck && newCheck != "" {
+				out(3, "ALTER TABLE %s ADD %s;", table, checkDef(c.Type, a))
+			}
+		case AddAttribute:
+			a := c.New.(*er.Attribute)
+			out(3, "ALTER TABLE %s ADD COLUMN %s;", table, columnDef(c.Type, a))
+			if note := collationNote(c.Type, a); note != "" {
+				out(3, "%s", note)
+			}
+			if a.Description != "" {
+				out(4, "COMMENT ON COLUMN %s.%s IS %s;", table, col, quote(a.Description))
+			}
+		case AddType:
+			t := c.New.(*er.EntityType)
+			out(4, "%s", createTable(t))
+			if t.Description != "" {
+				out(4, "COMMENT ON TABLE %s IS %s;", table, quote(t.Description))
+			}
+			for _, a := range t.Attributes {
+				if note := collationNote(t.Name, a); note != "" {
+					out(4, "%s", note)
+				}
+				if a.Description != "" {
+					out(4, "COMMENT ON COLUMN %s.%s IS %s;", table, ident(a.Name), quote(a.Description))
+				}
+			}
+		case AddUniqueKey:
+			x := c.New.(*er.Index)
+			out(4, "ALTER TABLE %s ADD CONSTRAINT %s UNIQUE (%s);", table, ident(c.Type+"_"+x.Name+"_key"), columnList(x.Attributes))
+		case AddIndex:
+			x := c.New.(*er.Index)
+			out(4, "CREATE INDEX %s ON %s (%s);", ident(c.Type+"_"+x.Name+"_idx"), table, columnList(x.Attributes))
+		case AddForeignKey:
+			k := c.New.(ForeignKey)
+			if !k.Complete() {
+				out(5, "-- %s.%s refers to %s through a constraint", c.Type, k.Name, k.Target.Name)
+				continue
+			}
+			out(5, "ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s);",
+				table, fkeyName(k), columnList(k.Columns), ident(k.Target.Name), columnList(k.Key))
+		}
+	}
+	sort.SliceStable(stmts, func(i, j int) bool {
+		return stmts[i].phase < stmts[j].phase
+	})
+	var buf bytes.Buffer
+	for _, s := range stmts {
+		buf.WriteString(s.text)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func createTable(t *er.EntityType) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "CREATE TABLE %s (", ident(t.Name))
+	for i, a := range t.Attributes {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "\n\t%s", columnDef(t.Name, a))
+	}
+	if key := Key(t); len(key) != 0 {
+		fmt.Fprintf(&buf, ",\n\tPRIMARY KEY (%s)", columnList(key))
+	}
+	buf.WriteString("\n);")
+	return buf.String()
+}
+
+// columnDef defines the column holding an attribute in the named table.
+func columnDef(table string, a *er.Attribute) string {
+	def := ident(a.Name) + " " + columnType(a)
+	if !a.Optional {
+		def += " NOT NULL"
+	}
+	switch {
+	case a.Generated == er.SerialKey || a.Default == rtl.Sequence:
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	case a.Default != "":
+		def += " DEFAULT " + defaultValue(a)
+	}
+	if check(a) != "" {
+		def += " " + checkDef(table, a)
+	}
+	return def
+}
+
+// checkDef is a named constraint holding the column of an attribute to its
+// check.
+func checkDef(table string, a *er.Attribute) string {
+	return fmt.Sprintf("CONSTRAINT %s CHECK (%s)", checkName(table, a), check(a))
+}
+
+// check is a condition limiting the column of an attribute to the values of
+// its enum, or to those that follow its rules. It is empty if there is no
+// limit.
+func check(a *er.Attribute) string {
+	col := ident(a.Name)
+	if a.Type == er.EnumType {
+		values := make([]string, len(a.Enum.Values))
+		for i, v := range a.Enum.Values {
+			values[i] = quote(v)
+		}
+		return fmt.Sprintf("%s IN (%s)", col, strings.Join(values, ", "))
+	}
+	var conds []string
+	if a.Min != nil {
+		conds = append(conds, col+" >= "+strconv.FormatFloat(*a.Min, 'g', -1, 64))
+	}
+	if a.Max != nil {
+		conds = append(conds, col+" <= "+strconv.FormatFloat(*a.Max, 'g', -1, 64))
+	}
+	if a.Pattern != "" {
+		conds = append(conds, col+" ~ "+quote("^(?:"+a.Pattern+")$"))
+	}
+	length := "char_length(" + col + ")"
+	if a.Type == er.BytesType {
+		length = "octet_length(" + col + ")"
+	}
+	if a.NonEmpty {
+		conds = append(conds, length+" > 0")
+	}
+	if a.MaxLength != 0 {
+		conds = append(conds, length+" <= "+strconv.Itoa(a.MaxLength))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func checkName(table string, a *er.Attribute) string {
+	return ident(table + "_" + a.Name + "_check")
+}
+
+// columnType is the type of the column holding an attribute, along with its
+// collation where the database has one to match.
+func columnType(a *er.Attribute) string {
+	if c := collation(a); c != "" {
+		return sqlType(a) + " COLLATE " + c
+	}
+	return sqlType(a)
+}
+
+// collation names the database collation matching the attribute's. Byte order
+// is "C" and languages use the ICU collations named after them. The database
+// default is left alone, and NFC and case-folded orders have no collation
+// built in.
+func collation(a *er.Attribute) string {
+	switch a.Collation {
+	case "", "nfc", "nocase":
+		return ""
+	case "binary":
+		return ident("C")
+	}
+	return ident(a.Collation + "-x-icu")
+}
+
+// collationNote is a comment for attributes whose collation the database
+// has nothing to match, or else empty.
+func collationNote(table string, a *er.Attribute) string {
+	if a.Collation != "nfc" && a.Collation != "nocase" {
+		return ""
+	}
+	return fmt.Sprintf("-- %s.%s compares strings as %s, which needs a collation made for it", table, a.Name, a.Collation)
+}
+
+// defaultValue writes the default of an attribute, other than sequence(), as
+// an SQL expression.
+func defaultValue(a *er.Attribute) string {
+	switch {
+	case a.Default == rtl.Now && a.Type == er.DateType:
+		return "CURRENT_DATE"
+	case a.Default == rtl.Now:
+		return "CURRENT_TIMESTAMP"
+	}
+	switch a.Type {
+	case er.IntType, er.FloatType, er.DecimalType:
+		return a.Default
+	case er.BoolType:
+		return strings.ToUpper(a.Default)
+	case er.BytesType:
+		return quote(`\x` + a.Default[2:])
+	}
+	return quote(a.Default)
+}
+
+// ident writes a name as an SQL identifier, quoted so that it can never be
+// taken for a keyword.
+func ident(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// quote writes s as an SQL string literal.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func columnList(as []*er.Attribute) string {
+	names := make([]string, len(as))
+	for i, a := range as {
+		names[i] = ident(a.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func fkeyName(k ForeignKey) string {
+	return ident(k.Source.Name + "_" + k.Name + "_fkey")
+}
+
+func sqlType(a *er.Attribute) string {
+	switch a.Type {
+	case er.StringType:
+		return "TEXT"
+	case er.IntType:
+		return "BIGINT"
+	case er.FloatType:
+		return "DOUBLE PRECISION"
+	case er.BoolType:
+		return "BOOLEAN"
+	case er.TimeType:
+		return "TIMESTAMP WITH TIME ZONE"
+	case er.DateType:
+		return "DATE"
+	case er.BytesType:
+		return "BYTEA"
+	case er.DecimalType:
+		return "NUMERIC"
+	case er.EnumType:
+		return "TEXT"
+	}
+	return "?"
+}
@@ -0,0 +1,533 @@
+// Package query implements a small language for asking questions of model
+// instances, such as
+//
+//	select c where c.parent.name = "A1" and not (f_name = "D2" or name < "C3")
+//
+// Optional attributes may be tested with "is null" and "is not null". Other
+// tests are false where the attribute has no value. Derived attributes may be
+// tested like any other.
+//
+// Queries are checked against an entity model and run against the untyped
+// accessors of any generated model.
+package query
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/scanner"
+	"time"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+// Query selects the entities of a type that satisfy a condition.
+type Query struct {
+	Type  *er.EntityType
+	where expr
+}
+
+type expr interface {
+	eval(as map[string]rtl.Accessor, row rtl.Row) (bool, error)
+}
+
+type and struct{ left, right expr }
+type or struct{ left, right expr }
+type not struct{ e expr }
+
+// test compares an attribute, reached by following a path of relationships,
+// with a literal value. Null tests have no value. Tests of derived counts have
+// the relationship to count.
+type test struct {
+	path  []*er.Relationship
+	attr  *er.Attribute
+	count *er.Relationship
+	coll  rtl.Collation
+	op    string
+	val   interface{}
+}
+
+// Parse checks a query against the entity model, which must have been through
+// LogicalToPhysical.
+func Parse(m *er.EntityModel, src string) (*Query, error) {
+	p := &parser{m: m}
+	p.s.Init(strings.NewReader(src))
+	p.s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats | scanner.ScanStrings
+	p.s.Error = func(*scanner.Scanner, string) { p.fail(er.ErrBadSyntax) }
+	p.next()
+	q := p.query()
+	if p.tok != scanner.EOF {
+		p.fail(er.ErrBadSyntax)
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return q, nil
+}
+
+// Run finds the entities matching the query.
+func (q *Query) Run(as map[string]rtl.Accessor) ([]rtl.Row, error) {
+	a, ok := as[q.Type.Name]
+	if !ok {
+		return nil, er.ErrInvalidRecord
+	}
+	var res []rtl.Row
+	err := a.ForEach(func(row rtl.Row) error {
+		if q.where != nil {
+			ok, err := q.where.eval(as, row)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		res = append(res, row)
+		return nil
+	})
+	return res, err
+}
+
+// Follow finds the entity that row, an entity of r's source type, is related
+// to by r. Relationships to an alternate key search through the target's
+// entities.
+func Follow(as map[string]rtl.Accessor, r *er.Relationship, row rtl.Row) (rtl.Row, bool) {
+	a, ok := as[r.Target.Name]
+	if !ok {
+		return nil, false
+	}
+	key := rtl.Row{}
+	for _, i := range r.Implementation {
+		from := row
+		for _, c := range i.BasePath {
+			if from, ok = Follow(as, c.Rel, from); !ok {
+				return nil, false
+			}
+		}
+		key[i.Target.Name] = from[i.Source.Name]
+	}
+	if r.Key == nil {
+		return a.Find(key)
+	}
+	colls := make([]rtl.Collation, len(r.Implementation))
+	for j, i := range r.Implementation {
+		colls[j] = rtl.MustCollation(i.Target.Collation)
+	}
+	var res rtl.Row
+	a.ForEach(func(row rtl.Row) error {
+		for j, i := range r.Implementation {
+			c, err := compare(colls[j], row[i.Target.Name], key[i.Target.Name])
+			if err != nil || c != 0 {
+				return nil
+			}
+		}
+		res = row
+		return nil
+	})
+	return res, res != nil
+}
+
+func (e and) eval(as map[string]rtl.Accessor, row rtl.Row) (bool, error) {
+	ok, err := e.left.eval(as, row)
+	if err != nil || !ok {
+		return false, err
+	}
+	return e.right.eval(as, row)
+}
+
+func (e or) eval(as map[string]rtl.Accessor, row rtl.Row) (bool, error) {
+	ok, err := e.left.eval(as, row)
+	if err != nil || ok {
+		return ok, err
+	}
+	return e.right.eval(as, row)
+}
+
+func (e not) eval(as map[string]rtl.Accessor, row rtl.Row) (bool, error) {
+	ok, err := e.e.eval(as, row)
+	return !ok, err
+}
+
+func (e test) eval(as map[string]rtl.Accessor, row rtl.Row) (bool, error) {
+	for _, r := range e.path {
+		var ok bool
+		if row, ok = Follow(as, r, row); !ok {
+			return false, nil
+		}
+	}
+	x, y := row[e.attr.Name], e.val
+	if e.count != nil {
+		n, err := count(as, e.count, row)
+		if err != nil {
+			return false, err
+		}
+		x = n
+	}
+	switch e.op {
+	case "is":
+		return x == nil, nil
+	case "is not":
+		return x != nil, nil
+	}
+	if x == nil {
+		return false, nil
+	}
+	if e.attr.Type == er.EnumType {
+		x, y = enumIndex(e.attr.Enum, x), enumIndex(e.attr.Enum, y)
+	}
+	c, err := compare(e.coll, x, y)
+	if err != nil {
+		return false, err
+	}
+	switch e.op {
+	case "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, er.ErrBadSyntax
+}
+
+// count finds how many entities r leads from to row, using the Count of the
+// source type's accessor.
+func count(as map[string]rtl.Accessor, r *er.Relationship, row rtl.Row) (int, error) {
+	a, ok := as[r.Source.Name]
+	if !ok || a.Count == nil {
+		return 0, er.ErrInvalidRecord
+	}
+	match := rtl.Row{}
+	for _, i := range r.Implementation {
+		if len(i.BasePath) != 0 {
+			return 0, er.ErrInvalidRecord
+		}
+		v := row[i.Target.Name]
+		if v == nil {
+			return 0, nil
+		}
+		match[i.Source.Name] = v
+	}
+	return a.Count(match), nil
+}
+
+// enumIndex replaces an enum value with its position in the enumeration, so
+// that enum values are ordered as they are declared.
+func enumIndex(e *er.Enum, v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		return e.Index(s)
+	}
+	return v
+}
+
+func compare(coll rtl.Collation, a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return coll.Compare(a, b), nil
+		}
+	case int:
+		if b, ok := b.(int); ok {
+			return rtl.CompareInt(a, b), nil
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return rtl.CompareFloat64(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			return rtl.CompareBool(a, b), nil
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return rtl.CompareTime(a, b), nil
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return rtl.CompareBytes(a, b), nil
+		}
+	case rtl.Decimal:
+		if b, ok := b.(rtl.Decimal); ok {
+			return rtl.CompareDecimal(a, b), nil
+		}
+	}
+	return 0, er.ErrInvalidAttribute
+}
+
+type parser struct {
+	s   scanner.Scanner
+	m   *er.EntityModel
+	tok rune
+	err error
+}
+
+func (p *parser) next() {
+	p.tok = p.s.Scan()
+}
+
+func (p *parser) fail(err error) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%s: %w", p.s.Position, err)
+	}
+}
+
+func (p *parser) keyword(k string) bool {
+	if p.tok == scanner.Ident && p.s.TokenText() == k {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) ident() string {
+	if p.tok != scanner.Ident {
+		p.fail(er.ErrBadSyntax)
+		return ""
+	}
+	res := p.s.TokenText()
+	p.next()
+	return res
+}
+
+func (p *parser) query() *Query {
+	if !p.keyword("select") {
+		p.fail(er.ErrBadSyntax)
+		return nil
+	}
+	name := p.ident()
+	q := &Query{}
+	for _, t := range p.m.Types {
+		if t.Name == name {
+			q.Type = t
+		}
+	}
+	if q.Type == nil {
+		p.fail(er.ErrInvalidRecord)
+		return nil
+	}
+	if p.keyword("where") {
+		q.where = p.or(q.Type)
+	}
+	return q
+}
+
+func (p *parser) or(t *er.EntityType) expr {
+	e := p.and(t)
+	for p.err == nil && p.keyword("or") {
+		e = or{e, p.and(t)}
+	}
+	return e
+}
+
+func (p *parser) and(t *er.EntityType) expr {
+	e := p.not(t)
+	for p.err == nil && p.keyword("and") {
+		e = and{e, p.not(t)}
+	}
+	return e
+}
+
+func (p *parser) not(t *er.EntityType) expr {
+	if p.keyword("not") {
+		return not{p.not(t)}
+	}
+	if p.tok == '(' {
+		p.next()
+		e := p.or(t)
+		if p.tok != ')' {
+			p.fail(er.ErrBadSyntax)
+		}
+		p.next()
+		return e
+	}
+	return p.test(t)
+}
+
+func (p *parser) test(t *er.EntityType) expr {
+	names := []string{p.ident()}
+	for p.tok == '.' {
+		p.next()
+		names = append(names, p.ident())
+	}
+	if p.err != nil {
+		return nil
+	}
+	if len(names) > 1 && names[0] == t.Name && findRel(t, names[0]) == nil {
+		names = names[1:]
+	}
+	var e test
+	for _, name := range names[:len(names)-1] {
+		r := findRel(t, name)
+		if r == nil {
+			p.fail(er.ErrInvalidAttribute)
+			return nil
+		}
+		e.path = append(e.path, r)
+		t = r.Target
+	}
+	e.attr = findAttr(t, names[len(names)-1])
+	if d := findDerived(t, names[len(names)-1]); e.attr == nil && d != nil {
+		for _, c := range d.Path {
+			e.path = append(e.path, c.Rel)
+		}
+		e.attr, e.count = d.Attribute, d.Count
+		if d.Count != nil {
+			e.attr = d.Value()
+		}
+	}
+	if e.attr == nil {
+		p.fail(er.ErrInvalidAttribute)
+		return nil
+	}
+	coll, err := rtl.NewCollation(e.attr.Collation)
+	if err != nil {
+		p.fail(err)
+		return nil
+	}
+	e.coll = coll
+	if p.keyword("is") {
+		e.op = "is"
+		if p.keyword("not") {
+			e.op = "is not"
+		}
+		if !p.keyword("null") {
+			p.fail(er.ErrBadSyntax)
+		}
+		return e
+	}
+	e.op = p.op()
+	e.val = p.literal(e.attr)
+	return e
+}
+
+func (p *parser) op() string {
+	op := string(p.tok)
+	switch p.tok {
+	case '=':
+		p.next()
+		return op
+	case '!', '<', '>':
+		p.next()
+		if p.tok == '=' {
+			op += "="
+			p.next()
+		}
+		if op != "!" {
+			return op
+		}
+	}
+	p.fail(er.ErrBadSyntax)
+	return ""
+}
+
+// literal reads a value for the attribute. Strings are quoted, as are times
+// and dates, which are written as in the record format. Bytes are written in
+// hexadecimal after 0x.
+func (p *parser) literal(a *er.Attribute) interface{} {
+	if a.Type == er.BoolType && p.tok == scanner.Ident {
+		text := p.s.TokenText()
+		p.next()
+		if text == "true" || text == "false" {
+			return text == "true"
+		}
+		p.fail(er.ErrBadSyntax)
+		return nil
+	}
+	if a.Type == er.EnumType && (p.tok == scanner.Ident || p.tok == scanner.String) {
+		text := p.s.TokenText()
+		if p.tok == scanner.String {
+			text, _ = strconv.Unquote(text)
+		}
+		p.next()
+		if a.Enum.Index(text) < 0 {
+			p.fail(er.ErrInvalidAttribute)
+			return nil
+		}
+		return text
+	}
+	neg := ""
+	if p.tok == '-' {
+		neg = "-"
+		p.next()
+	}
+	text := neg + p.s.TokenText()
+	tok := p.tok
+	p.next()
+	switch {
+	case tok == scanner.String && neg == "" && a.Type == er.StringType:
+		s, err := strconv.Unquote(text)
+		if err == nil {
+			return s
+		}
+	case tok == scanner.Int && a.Type == er.IntType:
+		n, err := strconv.Atoi(text)
+		if err == nil {
+			return n
+		}
+	case (tok == scanner.Int || tok == scanner.Float) && a.Type == er.FloatType:
+		f, err := strconv.ParseFloat(text, 64)
+		if err == nil {
+			return f
+		}
+	case (tok == scanner.Int || tok == scanner.Float) && a.Type == er.DecimalType:
+		d, err := rtl.ParseDecimal(text)
+		if err == nil {
+			return d
+		}
+	case tok == scanner.Int && neg == "" && a.Type == er.BytesType && strings.HasPrefix(text, "0x"):
+		bs, err := hex.DecodeString(text[2:])
+		if err == nil {
+			return bs
+		}
+	case tok == scanner.String && neg == "" && (a.Type == er.TimeType || a.Type == er.DateType):
+		format := time.RFC3339Nano
+		if a.Type == er.DateType {
+			format = "2006-01-02"
+		}
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			break
+		}
+		t, err := time.Parse(format, s)
+		if err == nil {
+			return t
+		}
+	case tok == scanner.String, tok == scanner.Int, tok == scanner.Float:
+		p.fail(er.ErrInvalidAttribute)
+		return nil
+	}
+	p.fail(er.ErrBadSyntax)
+	return nil
+}
+
+func findRel(t *er.EntityType, name string) *er.Relationship {
+	for _, r := range t.Relationships {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func findAttr(t *er.EntityType, name string) *er.Attribute {
+	for _, a := range t.Attributes {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func findDerived(t *er.EntityType, name string) *er.DerivedAttribute {
+	for _, d := range t.Derived {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
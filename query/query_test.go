@@ -0,0 +1,181 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+func testModel() *er.EntityModel {
+	a := &er.EntityType{Name: "a"}
+	c := &er.EntityType{Name: "c"}
+	a.Attributes = []*er.Attribute{
+		{Name: "name", Type: er.StringType, Identifying: true, Owner: a},
+		{Name: "size", Type: er.IntType, Owner: a},
+		{Name: "open", Type: er.BoolType, Owner: a},
+		{Name: "opened", Type: er.DateType, Owner: a},
+		{Name: "budget", Type: er.DecimalType, Owner: a},
+		{Name: "state", Type: er.EnumType, Owner: a, Enum: &er.Enum{Name: "state", Values: []string{"on", "off"}}},
+		{Name: "code", Type: er.StringType, Owner: a},
+	}
+	a.Indexes = []*er.Index{{Name: "by_code", Unique: true, Attributes: a.Attributes[6:], Owner: a}}
+	c.Attributes = []*er.Attribute{
+		{Name: "name", Type: er.StringType, Identifying: true, Owner: c},
+		{Name: "parent_name", Type: er.StringType, Owner: c, Collation: "nocase"},
+		{Name: "weight", Type: er.FloatType, Owner: c},
+		{Name: "tag", Type: er.StringType, Owner: c, Optional: true},
+		{Name: "owner_code", Type: er.StringType, Owner: c},
+	}
+	c.Relationships = []*er.Relationship{{
+		Name:   "parent",
+		Source: c,
+		Target: a,
+		Implementation: []er.Implementation{
+			{Source: c.Attributes[1], Target: a.Attributes[0]},
+		},
+	}, {
+		Name:   "owner",
+		Source: c,
+		Target: a,
+		Key:    a.Indexes[0],
+		Implementation: []er.Implementation{
+			{Source: c.Attributes[4], Target: a.Attributes[6]},
+		},
+	}}
+	c.Derived = []*er.DerivedAttribute{{
+		Name:      "parent_size",
+		Path:      []er.Component{{Rel: c.Relationships[0]}},
+		Attribute: a.Attributes[1],
+		Owner:     c,
+	}}
+	a.Derived = []*er.DerivedAttribute{{Name: "children", Count: c.Relationships[0], Owner: a}}
+	return &er.EntityModel{Name: "test", Types: []*er.EntityType{a, c}}
+}
+
+// accessor serves rows held in a slice.
+func accessor(rows []rtl.Row) rtl.Accessor {
+	return rtl.Accessor{
+		ForEach: func(f func(rtl.Row) error) error {
+			for _, r := range rows {
+				if err := f(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Find: func(key rtl.Row) (rtl.Row, bool) {
+			for _, r := range rows {
+				if r["name"] == key["name"] {
+					return r, true
+				}
+			}
+			return nil, false
+		},
+		Count: func(match rtl.Row) int {
+			n := 0
+			for _, r := range rows {
+				found := true
+				for k, v := range match {
+					found = found && r[k] == v
+				}
+				if found {
+					n++
+				}
+			}
+			return n
+		},
+	}
+}
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRun(t *testing.T) {
+	m := testModel()
+	as := map[string]rtl.Accessor{
+		"a": accessor([]rtl.Row{
+			{"name": "A1", "size": 1, "open": true, "opened": day(2024, 1, 31), "budget": rtl.NewDecimal(1050, 2), "state": "off", "code": "x"},
+			{"name": "A2", "size": 20, "open": false, "opened": day(2024, 6, 1), "budget": rtl.NewDecimal(-5, 0), "state": "on", "code": "y"},
+		}),
+		"c": accessor([]rtl.Row{
+			{"name": "C1", "parent_name": "A1", "weight": 0.5, "owner_code": "y"},
+			{"name": "C2", "parent_name": "A2", "weight": 1.5, "tag": "x"},
+			{"name": "C3", "parent_name": "A2", "weight": -2.0, "tag": "y"},
+			{"name": "C4", "parent_name": "A3", "weight": 0.0},
+		}),
+	}
+	for _, test := range []struct {
+		name, src string
+		names     []string
+	}{
+		{"All", `select a`, []string{"A1", "A2"}},
+		{"Eq", `select c where name = "C2"`, []string{"C2"}},
+		{"Qualified", `select c where c.name != "C2"`, []string{"C1", "C3", "C4"}},
+		{"Int", `select a where size >= 2`, []string{"A2"}},
+		{"Float", `select c where weight < -1`, []string{"C3"}},
+		{"Path", `select c where c.parent.size > 10`, []string{"C2", "C3"}},
+		{"Derived", `select c where parent_size > 10`, []string{"C2", "C3"}},
+		{"DerivedCount", `select a where children = 2`, []string{"A2"}},
+		{"AlternateKey", `select c where owner.size > 10`, []string{"C1"}},
+		{"Collation", `select c where parent_name = "a2"`, []string{"C2", "C3"}},
+		{"BoolLiteral", `select a where open = true`, []string{"A1"}},
+		{"Date", `select a where opened > "2024-02-01"`, []string{"A2"}},
+		{"Decimal", `select a where budget = 10.5`, []string{"A1"}},
+		{"Enum", `select a where state = on`, []string{"A2"}},
+		{"EnumOrder", `select a where state > "on"`, []string{"A1"}},
+		{"Null", `select c where tag is null`, []string{"C1", "C4"}},
+		{"NotNull", `select c where tag is not null`, []string{"C2", "C3"}},
+		{"Absent", `select c where tag != "x"`, []string{"C3"}},
+		{"Bool", `select c where not (parent.name = "A2" or name = "C1") and weight <= 0`, []string{"C4"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := Parse(m, test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := q.Run(as)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range rows {
+				names = append(names, r["name"].(string))
+			}
+			if !reflect.DeepEqual(names, test.names) {
+				t.Errorf("got %v, expecting %v", names, test.names)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	m := testModel()
+	for _, test := range []struct {
+		src string
+		err error
+	}{
+		{`select`, er.ErrBadSyntax},
+		{`select b`, er.ErrInvalidRecord},
+		{`select c where`, er.ErrBadSyntax},
+		{`select c where colour = "red"`, er.ErrInvalidAttribute},
+		{`select c where parent.colour = "red"`, er.ErrInvalidAttribute},
+		{`select c where weight = "heavy"`, er.ErrInvalidAttribute},
+		{`select a where open = 1`, er.ErrInvalidAttribute},
+		{`select a where state = dim`, er.ErrInvalidAttribute},
+		{`select a where opened > "tomorrow"`, er.ErrBadSyntax},
+		{`select c where name ! "C1"`, er.ErrBadSyntax},
+		{`select c where tag is "x"`, er.ErrBadSyntax},
+		{`select c where (name = "C1"`, er.ErrBadSyntax},
+		{`select c where name = "C1" extra`, er.ErrBadSyntax},
+	} {
+		_, err := Parse(m, test.src)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expecting %v", test.src, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package rtl
+
+// Row holds the attribute values of an entity, keyed by attribute name.
+type Row map[string]interface{}
+
+// Accessor gives untyped access to the entities of one type in a model, for
+// tools that work against any model.
+type Accessor struct {
+	// ForEach visits each entity in key order.
+	ForEach func(f func(Row) error) error
+	// Find looks up an entity by the values of its identifying attributes.
+	Find func(key Row) (Row, bool)
+	// Count counts the entities whose attributes hold the values in match. A
+	// nil value matches nothing.
+	Count func(match Row) int
+	// Insert, Update and Delete modify the entities as the typed methods do.
+	// They leave the row they are given as it is.
+	Insert, Update, Delete func(Row) error
+	// InsertNew inserts as Insert does, returning the entity with its defaults
+	// and any surrogate key it generates.
+	InsertNew func(Row) (Row, error)
+}
@@ -0,0 +1,132 @@
+package rtl
+
+import (
+	"sort"
+)
+
+// Sum adds up the values of the column in the rows of r.
+func (c Int) Sum(r *QueryResult) int {
+	res := 0
+	for c.null.next(r) {
+		res += c.val(r.This())
+	}
+	return res
+}
+
+// Min finds the smallest value of the column in the rows of r. It reports
+// false if there are no values.
+func (c Int) Min(r *QueryResult) (int, bool) {
+	return c.best(r, -1)
+}
+
+// Max finds the largest value of the column in the rows of r. It reports
+// false if there are no values.
+func (c Int) Max(r *QueryResult) (int, bool) {
+	return c.best(r, 1)
+}
+
+func (c Int) best(r *QueryResult, dir int) (int, bool) {
+	if !c.null.next(r) {
+		return 0, false
+	}
+	res := c.val(r.This())
+	for c.null.next(r) {
+		if val := c.val(r.This()); CompareInt(val, res) == dir {
+			res = val
+		}
+	}
+	return res, true
+}
+
+// Avg finds the mean value of the column in the rows of r. It reports false
+// if there are no values.
+func (c Int) Avg(r *QueryResult) (float64, bool) {
+	sum, n := 0.0, 0
+	for c.null.next(r) {
+		sum += float64(c.val(r.This()))
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// Sum adds up the values of the column in the rows of r.
+func (c Float64) Sum(r *QueryResult) float64 {
+	res := 0.0
+	for c.null.next(r) {
+		res += c.val(r.This())
+	}
+	return res
+}
+
+// Min finds the smallest value of the column in the rows of r. It reports
+// false if there are no values.
+func (c Float64) Min(r *QueryResult) (float64, bool) {
+	return c.best(r, -1)
+}
+
+// Max finds the largest value of the column in the rows of r. It reports
+// false if there are no values.
+func (c Float64) Max(r *QueryResult) (float64, bool) {
+	return c.best(r, 1)
+}
+
+func (c Float64) best(r *QueryResult, dir int) (float64, bool) {
+	if !c.null.next(r) {
+		return 0, false
+	}
+	res := c.val(r.This())
+	for c.null.next(r) {
+		if val := c.val(r.This()); CompareFloat64(val, res) == dir {
+			res = val
+		}
+	}
+	return res, true
+}
+
+// Avg finds the mean value of the column in the rows of r. It reports false
+// if there are no values.
+func (c Float64) Avg(r *QueryResult) (float64, bool) {
+	sum, n := 0.0, 0
+	for c.null.next(r) {
+		sum += c.val(r.This())
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// next advances r to the next row holding a value. The aggregates skip rows
+// holding no value.
+func (n nulls) next(r *QueryResult) bool {
+	for r.Next() {
+		if !n.isNull(r.This()) {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupBy splits the rows of r into groups that share a value of c. There is
+// a query for each group, matching the rows with that value, in order of the
+// value.
+func GroupBy(r *QueryResult, c Column) []Query {
+	var rows []int
+	for r.Next() {
+		rows = append(rows, r.This())
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return c.compare(rows[i], rows[j]) < 0
+	})
+	var res []Query
+	for i, row := range rows {
+		if i == 0 || c.compare(rows[i-1], row) != 0 {
+			res = append(res, c.same(row))
+		}
+	}
+	return res
+}
@@ -0,0 +1,49 @@
+package rtl
+
+import (
+	"testing"
+)
+
+func TestAggregates(t *testing.T) {
+	rows := []struct {
+		a int
+		b float64
+	}{
+		{3, 1.5},
+		{1, 2.5},
+		{3, -1},
+		{2, 0},
+	}
+	a := IntColumn(0, func(idx int) int { return rows[idx].a })
+	b := Float64Column(1, func(idx int) float64 { return rows[idx].b })
+	all := func() *QueryResult { return All(len(rows)) }
+	if got := a.Sum(all()); got != 9 {
+		t.Errorf("sum: got %d, expecting 9", got)
+	}
+	if got, _ := a.Min(all()); got != 1 {
+		t.Errorf("min: got %d, expecting 1", got)
+	}
+	if got, _ := b.Max(all()); got != 2.5 {
+		t.Errorf("max: got %v, expecting 2.5", got)
+	}
+	if got, _ := b.Avg(all()); got != 0.75 {
+		t.Errorf("avg: got %v, expecting 0.75", got)
+	}
+	if _, ok := a.Max(EvalQuery(a.Gt(3), len(rows))); ok {
+		t.Errorf("max of no rows")
+	}
+	groups := GroupBy(all(), a)
+	expected := [][]int{{1}, {3}, {0, 2}}
+	if len(groups) != len(expected) {
+		t.Fatalf("got %d groups, expecting %d", len(groups), len(expected))
+	}
+	for i, q := range groups {
+		var got []int
+		for r := EvalQuery(q, len(rows)); r.Next(); {
+			got = append(got, r.This())
+		}
+		if len(got) != len(expected[i]) || got[0] != expected[i][0] {
+			t.Errorf("group %d: got %v, expecting %v", i, got, expected[i])
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package rtl
+
+import (
+	"fmt"
+)
+
+// ChangeOp describes what happened to an entity in a change event.
+type ChangeOp byte
+
+const (
+	Inserted ChangeOp = iota + 1
+	Updated
+	Deleted
+)
+
+func (op ChangeOp) String() string {
+	switch op {
+	case Inserted:
+		return "insert"
+	case Updated:
+		return "update"
+	case Deleted:
+		return "delete"
+	}
+	return "invalid"
+}
+
+// Conflict records an entity that was changed in incompatible ways on either
+// side of a merge. Attribute names the attribute that both sides changed, or
+// is empty if one side deleted the entity while the other changed it or both
+// sides added differing entities with the same key. Base, Ours and Theirs
+// hold the versions of the entity, or nil where it is absent.
+type Conflict struct {
+	Type, Attribute    string
+	Base, Ours, Theirs interface{}
+}
+
+func (c Conflict) String() string {
+	if c.Attribute == "" {
+		return fmt.Sprintf("conflicting changes to %s: %v, %v", c.Type, c.Ours, c.Theirs)
+	}
+	return fmt.Sprintf("conflicting changes to %s.%s: %v, %v", c.Type, c.Attribute, c.Ours, c.Theirs)
+}
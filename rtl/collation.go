@@ -0,0 +1,74 @@
+package rtl
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/bobappleyard/er"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Collation determines how strings are ordered and which strings are equal.
+// The zero value compares strings byte by byte.
+type Collation struct {
+	// key maps strings to a form where byte by byte comparison gives the
+	// collation's order.
+	key func(string) string
+	// prefixes is set if a string has a prefix exactly when its key has the
+	// prefix's key as a prefix.
+	prefixes bool
+}
+
+// NewCollation finds a collation by name. The names are
+//
+//	""/"binary"  compare bytes
+//	"nfc"        compare Unicode canonical (NFC) forms
+//	"nocase"     compare case-folded NFC forms
+//
+// Any other name is taken to be a BCP 47 language tag, such as "de" or "sv",
+// and compares strings in the order used by that language.
+func NewCollation(name string) (Collation, error) {
+	switch name {
+	case "", "binary":
+		return Collation{}, nil
+	case "nfc":
+		return Collation{key: norm.NFC.String, prefixes: true}, nil
+	case "nocase":
+		return Collation{key: func(s string) string {
+			return norm.NFC.String(cases.Fold().String(s))
+		}, prefixes: true}, nil
+	}
+	tag, err := language.Parse(name)
+	if err != nil {
+		return Collation{}, er.ErrInvalidAttribute
+	}
+	var lock sync.Mutex
+	var buf collate.Buffer
+	c := collate.New(tag)
+	return Collation{key: func(s string) string {
+		lock.Lock()
+		defer lock.Unlock()
+		res := string(c.KeyFromString(&buf, s))
+		buf.Reset()
+		return res
+	}}, nil
+}
+
+// MustCollation is like NewCollation but panics if the name is not known.
+func MustCollation(name string) Collation {
+	c, err := NewCollation(name)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c Collation) Compare(a, b string) int {
+	if c.key == nil {
+		return strings.Compare(a, b)
+	}
+	return strings.Compare(c.key(a), c.key(b))
+}
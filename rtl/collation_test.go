@@ -0,0 +1,73 @@
+package rtl
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCollations(t *testing.T) {
+	for _, test := range []struct {
+		name, coll string
+		in, out    []string
+	}{
+		{"Binary", "", []string{"b", "B", "a", "Ä"}, []string{"B", "a", "b", "Ä"}},
+		{"NoCase", "nocase", []string{"b", "C", "a"}, []string{"a", "b", "C"}},
+		{"Swedish", "sv", []string{"ö", "z", "o", "ä"}, []string{"o", "z", "ä", "ö"}},
+		{"German", "de", []string{"ö", "z", "o", "ä"}, []string{"ä", "o", "ö", "z"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewCollation(test.coll)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := append([]string(nil), test.in...)
+			sort.Slice(got, func(i, j int) bool { return c.Compare(got[i], got[j]) < 0 })
+			if !reflect.DeepEqual(got, test.out) {
+				t.Errorf("got %q, expecting %q", got, test.out)
+			}
+		})
+	}
+	if c := MustCollation("nfc"); c.Compare("é", "é") != 0 {
+		t.Errorf("NFC forms should be equal")
+	}
+	if c := MustCollation("nocase"); c.Compare("Straße", "STRASSE") != 0 {
+		t.Errorf("case folded forms should be equal")
+	}
+	if _, err := NewCollation("not a language"); err == nil {
+		t.Errorf("expecting an error")
+	}
+}
+
+func TestCollatedColumn(t *testing.T) {
+	rows := []string{"apple", "Banana", "bandana", "Cherry"}
+	col := StringIndex(0, func(idx int) string { return rows[idx] }).Collate(MustCollation("nocase"))
+	for _, test := range []struct {
+		name string
+		q    Query
+		rows []int
+	}{
+		{"Eq", col.Eq("BANANA"), []int{1}},
+		{"Lt", col.Lt("b"), []int{0}},
+		{"Prefix", col.HasPrefix("BAN"), []int{1, 2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int
+			for r := EvalQuery(test.q, len(rows)); r.Next(); {
+				got = append(got, r.This())
+			}
+			if !reflect.DeepEqual(got, test.rows) {
+				t.Errorf("got %v, expecting %v", got, test.rows)
+			}
+			if _, max, _ := test.q.keyRange(0, len(rows)); max == len(rows) && test.name != "Lt" {
+				t.Errorf("search was not narrowed")
+			}
+		})
+	}
+	sv := StringIndex(0, func(idx int) string { return rows[idx] }).Collate(MustCollation("sv"))
+	for r := EvalQuery(sv.HasPrefix("B"), len(rows)); r.Next(); {
+		if r.This() != 1 {
+			t.Errorf("got row %d, expecting 1", r.This())
+		}
+	}
+}
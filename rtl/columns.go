@@ -1,40 +1,169 @@
 package rtl
 
 import (
+	"bytes"
+	"math"
 	"strings"
+	"time"
 )
 
-type String struct {
+// Column is implemented by each of the column types.
+type Column interface {
+	compare(i, j int) int
+	same(idx int) Query
+	// compareWith compares the value at i with that of a column of the same
+	// type at j.
+	compareWith(d Column, i, j int) int
+	desc() column
+}
+
+// nulls reports which rows of an optional column hold no value. It is nil for
+// columns that always hold a value.
+type nulls func(idx int) bool
+
+func (n nulls) isNull(idx int) bool {
+	return n != nil && n(idx)
+}
+
+// clause makes c fail on rows holding no value. Those rows compare below any
+// value, so that they come first in an index and do not upset its order.
+func (n nulls) clause(c clause) clause {
+	if n == nil {
+		return c
+	}
+	cmp := c.cmp
+	c.cmp = func(idx int) int {
+		if n(idx) {
+			return -1
+		}
+		return cmp(idx)
+	}
+	c.null = n
+	return c
+}
+
+// compare orders rows holding no value first, reporting whether either row
+// does.
+func (n nulls) compare(i, j int) (int, bool) {
+	a, b := n.isNull(i), n.isNull(j)
+	if !a && !b {
+		return 0, false
+	}
+	return CompareBool(b, a), true
+}
+
+func (n nulls) query(want bool) Query {
+	return Where(func(idx int) bool {
+		return n.isNull(idx) == want
+	})
+}
+
+// column holds what the column types share, and builds their queries.
+type column struct {
 	columnID int
 	key      bool
-	val      func(idx int) string
+	null     nulls
+}
+
+// test builds a query testing the column with op, where cmp compares the value
+// at a row with the value tested against. Equality on a key column can narrow
+// a search.
+func (c column) test(op test, cmp func(idx int) int) Query {
+	if op == eq && c.key {
+		op = key
+	}
+	return queryForClause(c.null.clause(clause{
+		columnID: c.columnID,
+		indexed:  c.key,
+		op:       op,
+		cmp:      cmp,
+	}))
+}
+
+// order compares the rows at i and j, where cmp compares their values.
+func (c column) order(i, j int, cmp func(i, j int) int) int {
+	if n, ok := c.null.compare(i, j); ok {
+		return n
+	}
+	return cmp(i, j)
+}
+
+func (c column) desc() column { return c }
+
+// IsNull matches the rows holding no value.
+func (c column) IsNull() Query { return c.null.query(true) }
+
+// IsNotNull matches the rows holding a value.
+func (c column) IsNotNull() Query { return c.null.query(false) }
+
+// in matches the rows matching any of n queries, or none if n is 0.
+func in(n int, q func(i int) Query) Query {
+	if n == 0 {
+		return none()
+	}
+	qs := make([]Query, n)
+	for i := range qs {
+		qs[i] = q(i)
+	}
+	return anyOf(qs)
+}
+
+// notIn matches the rows matching all of n queries.
+func notIn(n int, q func(i int) Query) Query {
+	var res Query
+	for i := 0; i < n; i++ {
+		res = res.And(q(i))
+	}
+	return res
+}
+
+type String struct {
+	column
+	val  func(idx int) string
+	coll Collation
 }
 
 func StringColumn(id int, val func(int) string) String {
-	return String{columnID: id, val: val}
+	return String{column: column{columnID: id}, val: val}
 }
 
 func StringIndex(id int, val func(int) string) String {
-	return String{columnID: id, key: true, val: val}
+	return String{column: column{columnID: id, key: true}, val: val}
+}
+
+// Collate makes the column compare values using coll.
+func (c String) Collate(coll Collation) String {
+	c.coll = coll
+	return c
 }
 
 func (c String) query(val string, op test) Query {
-	return queryForClause(clause{
-		columnID: c.columnID,
-		op:       op,
-		cmp: func(idx int) int {
-			return strings.Compare(c.val(idx), val)
-		},
-	})
+	return c.test(op, func(idx int) int { return c.coll.Compare(c.val(idx), val) })
+}
+
+func (c String) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return c.coll.Compare(c.val(i), c.val(j)) })
+}
+
+func (c String) compareWith(d Column, i, j int) int {
+	return c.coll.Compare(c.val(i), d.(String).val(j))
+}
+
+// Optional makes the column hold no value for the rows where null holds.
+// Such rows fail every test except IsNull, and sort before every value.
+func (c String) Optional(null func(idx int) bool) String {
+	c.null = null
+	return c
 }
 
-func (c String) Eq(val string) Query {
-	if c.key {
-		return c.query(val, key)
+func (c String) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
 	}
-	return c.query(val, eq)
+	return c.Eq(c.val(idx))
 }
 
+func (c String) Eq(val string) Query { return c.query(val, eq) }
 func (c String) Lt(val string) Query { return c.query(val, lt) }
 func (c String) Le(val string) Query { return c.query(val, le) }
 func (c String) Gt(val string) Query { return c.query(val, gt) }
@@ -45,37 +174,75 @@ func (c String) Range(from, to string) Query {
 	return c.Ge(from).And(c.Le(to))
 }
 
+func (c String) In(vals ...string) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c String) NotIn(vals ...string) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+// HasPrefix matches values starting with p. Under collations where prefixes
+// are not preserved by the ordering, such as those for languages, the values
+// are tested byte by byte and the test cannot narrow a search.
+func (c String) HasPrefix(p string) Query {
+	if c.coll.key != nil && !c.coll.prefixes {
+		return Where(func(idx int) bool {
+			return !c.null.isNull(idx) && strings.HasPrefix(c.val(idx), p)
+		})
+	}
+	key := func(s string) string { return s }
+	if c.coll.key != nil {
+		key = c.coll.key
+	}
+	p = key(p)
+	return c.test(prefix, func(idx int) int {
+		val := key(c.val(idx))
+		if strings.HasPrefix(val, p) {
+			return 0
+		}
+		return strings.Compare(val, p)
+	})
+}
+
 type Int struct {
-	columnID int
-	key      bool
-	val      func(idx int) int
+	column
+	val func(idx int) int
 }
 
 func IntColumn(id int, val func(int) int) Int {
-	return Int{columnID: id, val: val}
+	return Int{column: column{columnID: id}, val: val}
 }
 
 func IntIndex(id int, val func(int) int) Int {
-	return Int{columnID: id, key: true, val: val}
+	return Int{column: column{columnID: id, key: true}, val: val}
 }
 
 func (c Int) query(val int, op test) Query {
-	return queryForClause(clause{
-		columnID: c.columnID,
-		op:       op,
-		cmp: func(idx int) int {
-			return c.val(idx) - val
-		},
-	})
+	return c.test(op, func(idx int) int { return CompareInt(c.val(idx), val) })
+}
+
+func (c Int) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return CompareInt(c.val(i), c.val(j)) })
+}
+
+func (c Int) compareWith(d Column, i, j int) int {
+	return CompareInt(c.val(i), d.(Int).val(j))
+}
+
+func (c Int) Optional(null func(idx int) bool) Int {
+	c.null = null
+	return c
 }
 
-func (c Int) Eq(val int) Query {
-	if c.key {
-		return c.query(val, key)
+func (c Int) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
 	}
-	return c.query(val, eq)
+	return c.Eq(c.val(idx))
 }
 
+func (c Int) Eq(val int) Query { return c.query(val, eq) }
 func (c Int) Lt(val int) Query { return c.query(val, lt) }
 func (c Int) Le(val int) Query { return c.query(val, le) }
 func (c Int) Gt(val int) Query { return c.query(val, gt) }
@@ -85,3 +252,343 @@ func (c Int) Ne(val int) Query { return c.query(val, ne) }
 func (c Int) Range(from, to int) Query {
 	return c.Ge(from).And(c.Le(to))
 }
+
+func (c Int) In(vals ...int) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c Int) NotIn(vals ...int) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+type Float64 struct {
+	column
+	val func(idx int) float64
+}
+
+func Float64Column(id int, val func(int) float64) Float64 {
+	return Float64{column: column{columnID: id}, val: val}
+}
+
+func Float64Index(id int, val func(int) float64) Float64 {
+	return Float64{column: column{columnID: id, key: true}, val: val}
+}
+
+func (c Float64) query(val float64, op test) Query {
+	return c.test(op, func(idx int) int { return CompareFloat64(c.val(idx), val) })
+}
+
+func (c Float64) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return CompareFloat64(c.val(i), c.val(j)) })
+}
+
+func (c Float64) compareWith(d Column, i, j int) int {
+	return CompareFloat64(c.val(i), d.(Float64).val(j))
+}
+
+func (c Float64) Optional(null func(idx int) bool) Float64 {
+	c.null = null
+	return c
+}
+
+func (c Float64) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
+	}
+	return c.Eq(c.val(idx))
+}
+
+func (c Float64) Eq(val float64) Query { return c.query(val, eq) }
+func (c Float64) Lt(val float64) Query { return c.query(val, lt) }
+func (c Float64) Le(val float64) Query { return c.query(val, le) }
+func (c Float64) Gt(val float64) Query { return c.query(val, gt) }
+func (c Float64) Ge(val float64) Query { return c.query(val, ge) }
+func (c Float64) Ne(val float64) Query { return c.query(val, ne) }
+
+func (c Float64) Range(from, to float64) Query {
+	return c.Ge(from).And(c.Le(to))
+}
+
+func (c Float64) In(vals ...float64) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c Float64) NotIn(vals ...float64) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+type Bool struct {
+	column
+	val func(idx int) bool
+}
+
+func BoolColumn(id int, val func(int) bool) Bool {
+	return Bool{column: column{columnID: id}, val: val}
+}
+
+func BoolIndex(id int, val func(int) bool) Bool {
+	return Bool{column: column{columnID: id, key: true}, val: val}
+}
+
+func (c Bool) query(val bool, op test) Query {
+	return c.test(op, func(idx int) int { return CompareBool(c.val(idx), val) })
+}
+
+func (c Bool) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return CompareBool(c.val(i), c.val(j)) })
+}
+
+func (c Bool) compareWith(d Column, i, j int) int {
+	return CompareBool(c.val(i), d.(Bool).val(j))
+}
+
+func (c Bool) Optional(null func(idx int) bool) Bool {
+	c.null = null
+	return c
+}
+
+func (c Bool) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
+	}
+	return c.Eq(c.val(idx))
+}
+
+func (c Bool) Eq(val bool) Query { return c.query(val, eq) }
+func (c Bool) Lt(val bool) Query { return c.query(val, lt) }
+func (c Bool) Le(val bool) Query { return c.query(val, le) }
+func (c Bool) Gt(val bool) Query { return c.query(val, gt) }
+func (c Bool) Ge(val bool) Query { return c.query(val, ge) }
+func (c Bool) Ne(val bool) Query { return c.query(val, ne) }
+
+func (c Bool) Range(from, to bool) Query {
+	return c.Ge(from).And(c.Le(to))
+}
+
+func (c Bool) In(vals ...bool) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c Bool) NotIn(vals ...bool) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+type Time struct {
+	column
+	val func(idx int) time.Time
+}
+
+func TimeColumn(id int, val func(int) time.Time) Time {
+	return Time{column: column{columnID: id}, val: val}
+}
+
+func TimeIndex(id int, val func(int) time.Time) Time {
+	return Time{column: column{columnID: id, key: true}, val: val}
+}
+
+func (c Time) query(val time.Time, op test) Query {
+	return c.test(op, func(idx int) int { return CompareTime(c.val(idx), val) })
+}
+
+func (c Time) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return CompareTime(c.val(i), c.val(j)) })
+}
+
+func (c Time) compareWith(d Column, i, j int) int {
+	return CompareTime(c.val(i), d.(Time).val(j))
+}
+
+func (c Time) Optional(null func(idx int) bool) Time {
+	c.null = null
+	return c
+}
+
+func (c Time) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
+	}
+	return c.Eq(c.val(idx))
+}
+
+func (c Time) Eq(val time.Time) Query { return c.query(val, eq) }
+func (c Time) Lt(val time.Time) Query { return c.query(val, lt) }
+func (c Time) Le(val time.Time) Query { return c.query(val, le) }
+func (c Time) Gt(val time.Time) Query { return c.query(val, gt) }
+func (c Time) Ge(val time.Time) Query { return c.query(val, ge) }
+func (c Time) Ne(val time.Time) Query { return c.query(val, ne) }
+
+func (c Time) Range(from, to time.Time) Query {
+	return c.Ge(from).And(c.Le(to))
+}
+
+func (c Time) In(vals ...time.Time) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c Time) NotIn(vals ...time.Time) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+type Bytes struct {
+	column
+	val func(idx int) []byte
+}
+
+func BytesColumn(id int, val func(int) []byte) Bytes {
+	return Bytes{column: column{columnID: id}, val: val}
+}
+
+func BytesIndex(id int, val func(int) []byte) Bytes {
+	return Bytes{column: column{columnID: id, key: true}, val: val}
+}
+
+func (c Bytes) query(val []byte, op test) Query {
+	return c.test(op, func(idx int) int { return CompareBytes(c.val(idx), val) })
+}
+
+func (c Bytes) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return CompareBytes(c.val(i), c.val(j)) })
+}
+
+func (c Bytes) compareWith(d Column, i, j int) int {
+	return CompareBytes(c.val(i), d.(Bytes).val(j))
+}
+
+func (c Bytes) Optional(null func(idx int) bool) Bytes {
+	c.null = null
+	return c
+}
+
+func (c Bytes) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
+	}
+	return c.Eq(c.val(idx))
+}
+
+func (c Bytes) Eq(val []byte) Query { return c.query(val, eq) }
+func (c Bytes) Lt(val []byte) Query { return c.query(val, lt) }
+func (c Bytes) Le(val []byte) Query { return c.query(val, le) }
+func (c Bytes) Gt(val []byte) Query { return c.query(val, gt) }
+func (c Bytes) Ge(val []byte) Query { return c.query(val, ge) }
+func (c Bytes) Ne(val []byte) Query { return c.query(val, ne) }
+
+func (c Bytes) Range(from, to []byte) Query {
+	return c.Ge(from).And(c.Le(to))
+}
+
+func (c Bytes) In(vals ...[]byte) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c Bytes) NotIn(vals ...[]byte) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+type Numeric struct {
+	column
+	val func(idx int) Decimal
+}
+
+func NumericColumn(id int, val func(int) Decimal) Numeric {
+	return Numeric{column: column{columnID: id}, val: val}
+}
+
+func NumericIndex(id int, val func(int) Decimal) Numeric {
+	return Numeric{column: column{columnID: id, key: true}, val: val}
+}
+
+func (c Numeric) query(val Decimal, op test) Query {
+	return c.test(op, func(idx int) int { return CompareDecimal(c.val(idx), val) })
+}
+
+func (c Numeric) compare(i, j int) int {
+	return c.order(i, j, func(i, j int) int { return CompareDecimal(c.val(i), c.val(j)) })
+}
+
+func (c Numeric) compareWith(d Column, i, j int) int {
+	return CompareDecimal(c.val(i), d.(Numeric).val(j))
+}
+
+func (c Numeric) Optional(null func(idx int) bool) Numeric {
+	c.null = null
+	return c
+}
+
+func (c Numeric) same(idx int) Query {
+	if c.null.isNull(idx) {
+		return c.IsNull()
+	}
+	return c.Eq(c.val(idx))
+}
+
+func (c Numeric) Eq(val Decimal) Query { return c.query(val, eq) }
+func (c Numeric) Lt(val Decimal) Query { return c.query(val, lt) }
+func (c Numeric) Le(val Decimal) Query { return c.query(val, le) }
+func (c Numeric) Gt(val Decimal) Query { return c.query(val, gt) }
+func (c Numeric) Ge(val Decimal) Query { return c.query(val, ge) }
+func (c Numeric) Ne(val Decimal) Query { return c.query(val, ne) }
+
+func (c Numeric) Range(from, to Decimal) Query {
+	return c.Ge(from).And(c.Le(to))
+}
+
+func (c Numeric) In(vals ...Decimal) Query {
+	return in(len(vals), func(i int) Query { return c.Eq(vals[i]) })
+}
+
+func (c Numeric) NotIn(vals ...Decimal) Query {
+	return notIn(len(vals), func(i int) Query { return c.Ne(vals[i]) })
+}
+
+func CompareString(a, b string) int {
+	return strings.Compare(a, b)
+}
+
+func CompareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// CompareFloat64 orders NaN before every other value, and equal to itself, so
+// that floats can be sorted and searched.
+func CompareFloat64(a, b float64) int {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return CompareBool(!math.IsNaN(a), !math.IsNaN(b))
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func CompareBool(a, b bool) int {
+	switch {
+	case !a && b:
+		return -1
+	case a && !b:
+		return 1
+	}
+	return 0
+}
+
+func CompareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func CompareBytes(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
@@ -0,0 +1,90 @@
+package rtl
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/bobappleyard/er"
+)
+
+// Decimal is an exact decimal number, such as an amount of money. Decimals are
+// kept in their shortest form, so equal values are ==.
+type Decimal struct {
+	unscaled int64
+	scale    int
+}
+
+// NewDecimal makes the decimal unscaled × 10^-scale.
+func NewDecimal(unscaled int64, scale int) Decimal {
+	for ; scale < 0; scale++ {
+		unscaled *= 10
+	}
+	for scale > 0 && unscaled%10 == 0 {
+		unscaled /= 10
+		scale--
+	}
+	return Decimal{unscaled, scale}
+}
+
+// ParseDecimal reads a decimal written as an optionally signed number with an
+// optional fractional part, such as -12.50.
+func ParseDecimal(s string) (Decimal, error) {
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return Decimal{}, er.ErrBadSyntax
+	}
+	whole, frac := digits, ""
+	if dot := strings.IndexByte(digits, '.'); dot >= 0 {
+		whole, frac = digits[:dot], digits[dot+1:]
+	}
+	if whole+frac == "" || strings.Trim(whole+frac, "0123456789") != "" {
+		return Decimal{}, er.ErrBadSyntax
+	}
+	n, err := strconv.ParseInt(s[:len(s)-len(digits)]+whole+frac, 10, 64)
+	if err != nil {
+		return Decimal{}, err
+	}
+	return NewDecimal(n, len(frac)), nil
+}
+
+func (d Decimal) String() string {
+	digits := strconv.FormatInt(d.unscaled, 10)
+	sign := ""
+	if d.unscaled < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+	point := len(digits) - d.scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func (d Decimal) big(scale int) *big.Int {
+	res := big.NewInt(d.unscaled)
+	ten := big.NewInt(10)
+	for i := d.scale; i < scale; i++ {
+		res.Mul(res, ten)
+	}
+	return res
+}
+
+func CompareDecimal(a, b Decimal) int {
+	if a.scale == b.scale {
+		return CompareInt(int(a.unscaled), int(b.unscaled))
+	}
+	scale := a.scale
+	if b.scale > scale {
+		scale = b.scale
+	}
+	return a.big(scale).Cmp(b.big(scale))
+}
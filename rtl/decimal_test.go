@@ -0,0 +1,50 @@
+package rtl
+
+import (
+	"testing"
+)
+
+func TestDecimal(t *testing.T) {
+	for _, test := range []struct {
+		in, out string
+	}{
+		{"0", "0"},
+		{"12.50", "12.5"},
+		{"-0.05", "-0.05"},
+		{"+100", "100"},
+		{"100.000", "100"},
+		{".5", "0.5"},
+	} {
+		d, err := ParseDecimal(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if d.String() != test.out {
+			t.Errorf("%s: got %s, expecting %s", test.in, d, test.out)
+		}
+	}
+	for _, bad := range []string{"", "-", "1.2.3", "1e5", "--1", "99999999999999999999"} {
+		if _, err := ParseDecimal(bad); err == nil {
+			t.Errorf("%q: expecting an error", bad)
+		}
+	}
+	if NewDecimal(1200, 3) != NewDecimal(12, 1) {
+		t.Errorf("equal decimals should be ==")
+	}
+	for _, test := range []struct {
+		a, b string
+		c    int
+	}{
+		{"1.5", "1.50", 0},
+		{"1.05", "1.5", -1},
+		{"-2", "-10.5", 1},
+		{"9223372036854775807", "9223372036854775806.9", 1},
+	} {
+		a, _ := ParseDecimal(test.a)
+		b, _ := ParseDecimal(test.b)
+		if c := CompareDecimal(a, b); c != test.c {
+			t.Errorf("%s <=> %s: got %d, expecting %d", test.a, test.b, c, test.c)
+		}
+	}
+}
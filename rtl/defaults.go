@@ -0,0 +1,86 @@
+package rtl
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bobappleyard/er"
+)
+
+// Default generators, which make a new value each time a default is applied.
+const (
+	Now      = "now()"
+	Sequence = "sequence()"
+)
+
+// Today is the value of now() for date attributes: the current date, as
+// midnight UTC.
+func Today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// ParseDefault reads the default value of an attribute, as held in a Row. It
+// returns nil if the attribute has no default or its default is made by a
+// generator. Defaults that do not suit the attribute's type are invalid
+// attributes.
+func ParseDefault(a *er.Attribute) (interface{}, error) {
+	var (
+		res interface{}
+		err error
+	)
+	switch s := a.Default; {
+	case s == "":
+		return nil, nil
+	case s == Now:
+		if a.Type != er.TimeType && a.Type != er.DateType {
+			return nil, er.ErrInvalidAttribute
+		}
+		return nil, nil
+	case s == Sequence:
+		if a.Type != er.IntType {
+			return nil, er.ErrInvalidAttribute
+		}
+		return nil, nil
+	case a.Type == er.StringType:
+		res = s
+	case a.Type == er.IntType:
+		res, err = strconv.Atoi(s)
+	case a.Type == er.FloatType:
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, er.ErrInvalidAttribute
+		}
+		res = f
+	case a.Type == er.BoolType:
+		res, err = s == "true", nil
+		if s != "true" && s != "false" {
+			err = er.ErrBadSyntax
+		}
+	case a.Type == er.TimeType:
+		res, err = time.Parse(time.RFC3339Nano, s)
+	case a.Type == er.DateType:
+		res, err = time.Parse(dateFormat, s)
+	case a.Type == er.BytesType:
+		if !strings.HasPrefix(s, "0x") {
+			return nil, er.ErrInvalidAttribute
+		}
+		res, err = hex.DecodeString(s[2:])
+	case a.Type == er.DecimalType:
+		res, err = ParseDecimal(s)
+	case a.Type == er.EnumType:
+		if a.Enum == nil || a.Enum.Index(s) < 0 {
+			return nil, er.ErrInvalidAttribute
+		}
+		res = s
+	default:
+		return nil, er.ErrInvalidAttribute
+	}
+	if err != nil {
+		return nil, er.ErrInvalidAttribute
+	}
+	return res, nil
+}
@@ -0,0 +1,47 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bobappleyard/er"
+)
+
+func TestParseDefault(t *testing.T) {
+	state := &er.Enum{Name: "state", Values: []string{"on", "off"}}
+	for _, test := range []struct {
+		name  string
+		a     er.Attribute
+		value interface{}
+		valid bool
+	}{
+		{"None", er.Attribute{Type: er.IntType}, nil, true},
+		{"String", er.Attribute{Type: er.StringType, Default: "x y"}, "x y", true},
+		{"Int", er.Attribute{Type: er.IntType, Default: "-3"}, -3, true},
+		{"BadInt", er.Attribute{Type: er.IntType, Default: "1.5"}, nil, false},
+		{"Float", er.Attribute{Type: er.FloatType, Default: "1.5"}, 1.5, true},
+		{"Infinite", er.Attribute{Type: er.FloatType, Default: "inf"}, nil, false},
+		{"Bool", er.Attribute{Type: er.BoolType, Default: "true"}, true, true},
+		{"BadBool", er.Attribute{Type: er.BoolType, Default: "1"}, nil, false},
+		{"Date", er.Attribute{Type: er.DateType, Default: "2020-02-01"}, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC), true},
+		{"Time", er.Attribute{Type: er.TimeType, Default: "2020-02-01T10:00:00Z"}, time.Date(2020, 2, 1, 10, 0, 0, 0, time.UTC), true},
+		{"Bytes", er.Attribute{Type: er.BytesType, Default: "0x00ff"}, []byte{0, 255}, true},
+		{"BadBytes", er.Attribute{Type: er.BytesType, Default: "00ff"}, nil, false},
+		{"Decimal", er.Attribute{Type: er.DecimalType, Default: "1.50"}, NewDecimal(15, 1), true},
+		{"Enum", er.Attribute{Type: er.EnumType, Enum: state, Default: "off"}, "off", true},
+		{"BadEnum", er.Attribute{Type: er.EnumType, Enum: state, Default: "dim"}, nil, false},
+		{"Now", er.Attribute{Type: er.TimeType, Default: Now}, nil, true},
+		{"NowType", er.Attribute{Type: er.IntType, Default: Now}, nil, false},
+		{"Sequence", er.Attribute{Type: er.IntType, Default: Sequence}, nil, true},
+		{"SequenceType", er.Attribute{Type: er.DateType, Default: Sequence}, nil, false},
+	} {
+		v, err := ParseDefault(&test.a)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+		if !reflect.DeepEqual(v, test.value) {
+			t.Errorf("%s: got %#v, expecting %#v", test.name, v, test.value)
+		}
+	}
+}
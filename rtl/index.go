@@ -0,0 +1,93 @@
+package rtl
+
+import (
+	"sort"
+)
+
+// Index is a secondary index over a set of rows: the positions of the rows
+// ordered by some of their columns. Rows that compare equal are ordered by
+// position. The owner of the rows must report every change to the index.
+type Index struct {
+	columns []int
+	rows    []int
+	cmp     func(i, j int) int
+}
+
+// NewIndex creates an index over the columns with the given IDs. cmp compares
+// the rows at two positions by those columns, in order.
+func NewIndex(cmp func(i, j int) int, columns ...int) *Index {
+	return &Index{columns: columns, cmp: cmp}
+}
+
+// Insert records that a row has been inserted at pos, moving the rows after it
+// along by one.
+func (x *Index) Insert(pos int) {
+	for i, r := range x.rows {
+		if r >= pos {
+			x.rows[i] = r + 1
+		}
+	}
+	x.add(pos)
+}
+
+// Update records that the row at pos has changed.
+func (x *Index) Update(pos int) {
+	x.remove(pos)
+	x.add(pos)
+}
+
+// Delete records that the row at pos has been removed, moving the rows after
+// it back by one.
+func (x *Index) Delete(pos int) {
+	x.remove(pos)
+	for i, r := range x.rows {
+		if r > pos {
+			x.rows[i] = r - 1
+		}
+	}
+}
+
+// Reset rebuilds the index over n rows.
+func (x *Index) Reset(n int) {
+	x.rows = make([]int, n)
+	for i := range x.rows {
+		x.rows[i] = i
+	}
+	sort.SliceStable(x.rows, func(i, j int) bool {
+		return x.cmp(x.rows[i], x.rows[j]) < 0
+	})
+}
+
+func (x *Index) add(pos int) {
+	i := sort.Search(len(x.rows), func(i int) bool {
+		c := x.cmp(x.rows[i], pos)
+		return c > 0 || c == 0 && x.rows[i] > pos
+	})
+	x.rows = append(x.rows, 0)
+	copy(x.rows[i+1:], x.rows[i:])
+	x.rows[i] = pos
+}
+
+func (x *Index) remove(pos int) {
+	for i, r := range x.rows {
+		if r == pos {
+			copy(x.rows[i:], x.rows[i+1:])
+			x.rows = x.rows[:len(x.rows)-1]
+			return
+		}
+	}
+}
+
+// Reindex reports a change to the row at pos to each of the indexes.
+func Reindex(indexes []*Index, op ChangeOp, pos int) {
+	for _, x := range indexes {
+		switch op {
+		case Inserted:
+			x.Insert(pos)
+		case Updated:
+			x.Update(pos)
+		case Deleted:
+			x.Delete(pos)
+		}
+	}
+}
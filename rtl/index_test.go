@@ -0,0 +1,65 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	type row struct {
+		id    int
+		group string
+	}
+	var rows []row
+	group := StringColumn(1, func(idx int) string { return rows[idx].group })
+	x := NewIndex(func(i, j int) int {
+		return CompareString(rows[i].group, rows[j].group)
+	}, 1)
+	insert := func(pos int, r row) {
+		rows = append(rows, row{})
+		copy(rows[pos+1:], rows[pos:])
+		rows[pos] = r
+		x.Insert(pos)
+	}
+	remove := func(pos int) {
+		copy(rows[pos:], rows[pos+1:])
+		rows = rows[:len(rows)-1]
+		x.Delete(pos)
+	}
+	query := func(q Query) (res []int) {
+		r := EvalQuery(q, len(rows), x)
+		for r.Next() {
+			res = append(res, rows[r.This()].id)
+		}
+		return res
+	}
+
+	insert(0, row{1, "b"})
+	insert(1, row{3, "a"})
+	insert(1, row{2, "b"})
+	insert(3, row{4, "a"})
+	if !reflect.DeepEqual(x.rows, []int{2, 3, 0, 1}) {
+		t.Errorf("got index %v after insert", x.rows)
+	}
+	if got := query(group.Eq("b")); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v for b", got)
+	}
+
+	rows[0].group = "c"
+	x.Update(0)
+	remove(2)
+	if !reflect.DeepEqual(x.rows, []int{2, 1, 0}) {
+		t.Errorf("got index %v after update and delete", x.rows)
+	}
+	if got := query(group.Lt("c")); !reflect.DeepEqual(got, []int{2, 4}) {
+		t.Errorf("got %v for < c", got)
+	}
+	if got := query(group.Eq("b").Or(group.Eq("c"))); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("got %v for b or c", got)
+	}
+
+	x.Reset(len(rows))
+	if !reflect.DeepEqual(x.rows, []int{2, 1, 0}) {
+		t.Errorf("got index %v after reset", x.rows)
+	}
+}
@@ -0,0 +1,129 @@
+package rtl
+
+import (
+	"sort"
+)
+
+// join finds the rows whose values of cols equal those of one of the found
+// rows in targets.
+type join struct {
+	cols, targets []Column
+	found         []int
+}
+
+// Join matches the rows whose values of cols equal, column by column, those of
+// one of the rows found by r in targets, which must be columns of the same
+// types. The rows found are sorted by targets once, so that each row is
+// looked up rather than tested against every row found, and where cols lead
+// the key or an index the matching rows are found by a search for each row
+// found. Rows holding no value in any of the columns never match.
+func Join(cols, targets []Column, r *QueryResult) Query {
+	j := &join{cols: cols, targets: targets}
+	for r.Next() {
+		if !j.null(targets, r.This()) {
+			j.found = append(j.found, r.This())
+		}
+	}
+	sort.SliceStable(j.found, func(a, b int) bool {
+		return j.order(j.found[a], j.found[b]) < 0
+	})
+	unique := j.found[:0]
+	for i, t := range j.found {
+		if i == 0 || j.order(j.found[i-1], t) != 0 {
+			unique = append(unique, t)
+		}
+	}
+	j.found = unique
+	return queryForClause(clause{
+		columnID: -1,
+		op:       eq,
+		join:     j,
+		cmp: func(idx int) int {
+			if j.null(cols, idx) {
+				return 1
+			}
+			pos := sort.Search(len(j.found), func(i int) bool {
+				return j.compare(idx, j.found[i], len(cols)) <= 0
+			})
+			if pos < len(j.found) && j.compare(idx, j.found[pos], len(cols)) == 0 {
+				return 0
+			}
+			return 1
+		},
+	})
+}
+
+func (j *join) null(cols []Column, idx int) bool {
+	for _, c := range cols {
+		if c.desc().null.isNull(idx) {
+			return true
+		}
+	}
+	return false
+}
+
+// order compares two found rows by targets.
+func (j *join) order(t, u int) int {
+	for _, c := range j.targets {
+		if n := c.compare(t, u); n != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// compare compares the first n of cols at idx with targets at t. Rows holding
+// no value compare below every row found, as they do in an index.
+func (j *join) compare(idx, t, n int) int {
+	for k, c := range j.cols[:n] {
+		if c.desc().null.isNull(idx) {
+			return -1
+		}
+		if n := c.compareWith(j.targets[k], idx, t); n != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// keyLead counts the columns that lead the key, in order.
+func (j *join) keyLead() int {
+	n := 0
+	for n < len(j.cols) {
+		d := j.cols[n].desc()
+		if !d.key || d.columnID != n {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// indexLead counts the columns that lead the index, in order.
+func (j *join) indexLead(x *Index) int {
+	n := 0
+	for n < len(j.cols) && n < len(x.columns) && j.cols[n].desc().columnID == x.columns[n] {
+		n++
+	}
+	return n
+}
+
+// rows finds the rows whose first lead columns equal those of a found row,
+// over the window [0, n) of a sequence of rows sorted by those columns. row
+// maps positions in the sequence to row positions. The found rows are in the
+// same order, so each search starts where the last left off.
+func (j *join) rows(lead, n int, row func(int) int) []int {
+	res := []int{}
+	min := 0
+	for _, t := range j.found {
+		cmp := func(pos int) int { return j.compare(row(pos), t, lead) }
+		min = search(min, n, func(c int) bool { return c >= 0 }, cmp)
+		max := search(min, n, func(c int) bool { return c > 0 }, cmp)
+		for pos := min; pos < max; pos++ {
+			res = append(res, row(pos))
+		}
+		min = max
+	}
+	sort.Ints(res)
+	return res
+}
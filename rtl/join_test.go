@@ -0,0 +1,109 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	groups := []struct {
+		name string
+		open bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	rows := []struct {
+		id    int
+		group string
+	}{
+		{1, "a"},
+		{2, "b"},
+		{3, "a"},
+		{4, "c"},
+		{5, "b"},
+		{6, "a"},
+	}
+	name := StringIndex(0, func(idx int) string { return groups[idx].name })
+	open := BoolColumn(1, func(idx int) bool { return groups[idx].open })
+	id := IntIndex(0, func(idx int) int { return rows[idx].id })
+	group := StringColumn(1, func(idx int) string { return rows[idx].group })
+	x := NewIndex(func(i, j int) int {
+		return CompareString(rows[i].group, rows[j].group)
+	}, 1)
+	x.Reset(len(rows))
+
+	for _, test := range []struct {
+		name       string
+		q          Query
+		indexes    []*Index
+		candidates []int
+		ids        []int
+		explain    string
+	}{
+		{
+			name:       "Index",
+			q:          Join([]Column{group}, []Column{name}, EvalQuery(open.Eq(true), len(groups))),
+			indexes:    []*Index{x},
+			candidates: []int{0, 2, 3, 5},
+			ids:        []int{1, 3, 4, 6},
+			explain:    "join on index columns [1]: 4 rows\nexamines 4 of 6 rows",
+		},
+		{
+			name:       "Scan",
+			q:          Join([]Column{group}, []Column{name}, EvalQuery(open.Eq(false), len(groups))),
+			candidates: []int{0, 1, 2, 3, 4, 5},
+			ids:        []int{2, 5},
+			explain:    "full scan: 6 rows\nexamines 6 of 6 rows",
+		},
+		{
+			name:       "Key",
+			q:          Join([]Column{id}, []Column{IntColumn(-1, func(idx int) int { return idx * 2 })}, All(4)),
+			candidates: []int{1, 3, 5},
+			ids:        []int{2, 4, 6},
+			explain:    "join on key: 3 rows\nexamines 3 of 6 rows",
+		},
+		{
+			name:       "Not",
+			q:          Not(Join([]Column{group}, []Column{name}, EvalQuery(open.Eq(true), len(groups)))),
+			indexes:    []*Index{x},
+			candidates: []int{0, 1, 2, 3, 4, 5},
+			ids:        []int{2, 5},
+			explain:    "full scan: 6 rows\nexamines 6 of 6 rows",
+		},
+		{
+			name:       "None",
+			q:          Join([]Column{group}, []Column{name}, EvalQuery(none(), len(groups))),
+			indexes:    []*Index{x},
+			candidates: nil,
+			ids:        nil,
+			explain:    "join on index columns [1]: 0 rows\nexamines 0 of 6 rows",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var candidates, ids []int
+			min, max, rs := planQuery(test.q, len(rows), test.indexes).candidates()
+			for i := min; i < max; i++ {
+				if rs != nil {
+					candidates = append(candidates, rs[i])
+				} else {
+					candidates = append(candidates, i)
+				}
+			}
+			if !reflect.DeepEqual(candidates, test.candidates) {
+				t.Errorf("got candidates %v, expecting %v", candidates, test.candidates)
+			}
+			r := EvalQuery(test.q, len(rows), test.indexes...)
+			for r.Next() {
+				ids = append(ids, rows[r.This()].id)
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("got rows %v, expecting %v", ids, test.ids)
+			}
+			if got := test.q.Explain(len(rows), test.indexes...); got != test.explain {
+				t.Errorf("got plan:\n%s\nexpecting:\n%s", got, test.explain)
+			}
+		})
+	}
+}
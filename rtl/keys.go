@@ -0,0 +1,32 @@
+package rtl
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// NewUUID4 makes a random UUID, in its canonical text form.
+func NewUUID4() string {
+	var u [16]byte
+	rand.Read(u[:])
+	return formatUUID(u, 4)
+}
+
+// NewUUID7 makes a UUID that begins with the current Unix time in
+// milliseconds, so that UUIDs made later sort after those made earlier.
+func NewUUID7() string {
+	var u [16]byte
+	rand.Read(u[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+	copy(u[:6], ms[2:])
+	return formatUUID(u, 7)
+}
+
+func formatUUID(u [16]byte, version byte) string {
+	u[6] = u[6]&0x0f | version<<4
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,31 @@
+package rtl
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestUUID(t *testing.T) {
+	form := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-([47])[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, test := range []struct {
+		version string
+		make    func() string
+	}{
+		{"4", NewUUID4},
+		{"7", NewUUID7},
+	} {
+		u, v := test.make(), test.make()
+		if m := form.FindStringSubmatch(u); m == nil || m[1] != test.version {
+			t.Errorf("got %q, expecting a version %s UUID", u, test.version)
+		}
+		if u == v {
+			t.Errorf("got %q twice", u)
+		}
+	}
+	u := NewUUID7()
+	time.Sleep(2 * time.Millisecond)
+	if v := NewUUID7(); v <= u {
+		t.Errorf("got %q after %q", v, u)
+	}
+}
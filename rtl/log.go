@@ -0,0 +1,188 @@
+package rtl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bobappleyard/er"
+)
+
+// Log provides durable storage for a model as a snapshot file plus a log of
+// the changes made since the snapshot was taken. Each log entry occupies a
+// single line. Both files start with a line giving the generation of the log,
+// which Compact advances, so that a log already folded into the snapshot can be
+// told apart from one that follows it.
+type Log struct {
+	path string
+	f    *os.File
+	gen  int
+}
+
+const generationPrefix = "#generation "
+
+// OpenLog opens the snapshot at path and the log next to it, creating them if
+// they do not exist. The snapshot is passed to load and then each complete log
+// entry is passed to replay. An incomplete final entry, as left behind by a
+// crash part way through a write, is discarded, as is a log from an earlier
+// generation than the snapshot, as left behind by a crash part way through
+// Compact.
+func OpenLog(path string, load, replay func([]byte) error) (*Log, error) {
+	snapshot, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	gen, snapshot, err := readGeneration(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := load(snapshot); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path+".log", os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{path: path, f: f, gen: gen}
+	entries, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	logGen, rest, err := readGeneration(entries)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if logGen < gen {
+		if err := l.reset(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	l.gen = logGen
+	good := len(entries) - len(rest)
+	for {
+		n := bytes.IndexByte(entries[good:], '\n')
+		if n < 0 {
+			break
+		}
+		if err := replay(entries[good : good+n]); err != nil {
+			f.Close()
+			return nil, err
+		}
+		good += n + 1
+	}
+	if good < len(entries) {
+		if err := f.Truncate(int64(good)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(int64(good), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// readGeneration splits the generation line from the start of a file. Files
+// without one, including those written before generations were recorded, are
+// of generation 0. So are logs whose generation line was cut off by a crash
+// part way through reset, which hold no entries and, as reset only writes
+// generations from 1 on, are then reset again.
+func readGeneration(bs []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(bs, []byte(generationPrefix)) {
+		return 0, bs, nil
+	}
+	n := bytes.IndexByte(bs, '\n')
+	if n < 0 {
+		return 0, nil, nil
+	}
+	gen, err := strconv.Atoi(string(bs[len(generationPrefix):n]))
+	if err != nil {
+		return 0, nil, er.ErrBadSyntax
+	}
+	return gen, bs[n+1:], nil
+}
+
+func generationLine(gen int) []byte {
+	return []byte(generationPrefix + strconv.Itoa(gen) + "\n")
+}
+
+// Append adds an entry to the log, returning once it has reached the disk.
+func (l *Log) Append(entry []byte) error {
+	buf := make([]byte, 0, len(entry)+1)
+	buf = append(buf, entry...)
+	buf = append(buf, '\n')
+	if _, err := l.f.Write(buf); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Compact replaces the snapshot and empties the log, advancing the
+// generation. The snapshot is written to a temporary file and renamed into
+// place, so a crash leaves either the old snapshot and log or the new snapshot.
+// In the latter case the old log may remain, but is of an earlier generation
+// than the snapshot and so is not replayed.
+func (l *Log) Compact(snapshot []byte) error {
+	tmp := l.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(generationLine(l.gen+1), snapshot...)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(l.path)); err != nil {
+		return err
+	}
+	l.gen++
+	return l.reset()
+}
+
+// reset empties the log, leaving only its generation.
+func (l *Log) reset() error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := l.f.Write(generationLine(l.gen)); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// syncDir makes a rename within dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (l *Log) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,172 @@
+package rtl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+
+	open := func() (*Log, string, []string) {
+		var snapshot string
+		var entries []string
+		l, err := OpenLog(path, func(bs []byte) error {
+			snapshot = string(bs)
+			return nil
+		}, func(bs []byte) error {
+			entries = append(entries, string(bs))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l, snapshot, entries
+	}
+
+	l, _, entries := open()
+	if len(entries) != 0 {
+		t.Errorf("got entries %q from a new log", entries)
+	}
+	l.Append([]byte("one"))
+	l.Append([]byte("two"))
+	l.Close()
+
+	f, _ := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0666)
+	f.WriteString("thr")
+	f.Close()
+
+	l, _, entries = open()
+	if !reflect.DeepEqual(entries, []string{"one", "two"}) {
+		t.Errorf("got entries %q after truncation", entries)
+	}
+	l.Append([]byte("three"))
+	l.Close()
+
+	l, _, entries = open()
+	if !reflect.DeepEqual(entries, []string{"one", "two", "three"}) {
+		t.Errorf("got entries %q after append", entries)
+	}
+	if err := l.Compact([]byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	l.Append([]byte("four"))
+	l.Close()
+
+	l, snapshot, entries := open()
+	defer l.Close()
+	if snapshot != "snapshot" {
+		t.Errorf("got snapshot %q", snapshot)
+	}
+	if !reflect.DeepEqual(entries, []string{"four"}) {
+		t.Errorf("got entries %q after compaction", entries)
+	}
+}
+
+func TestLogCompactCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+
+	open := func() (*Log, string, []string) {
+		var snapshot string
+		var entries []string
+		l, err := OpenLog(path, func(bs []byte) error {
+			snapshot = string(bs)
+			return nil
+		}, func(bs []byte) error {
+			entries = append(entries, string(bs))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l, snapshot, entries
+	}
+
+	l, _, _ := open()
+	l.Append([]byte("one"))
+	l.Append([]byte("two"))
+	l.Close()
+	old, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, _, _ = open()
+	if err := l.Compact([]byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// simulate a crash after the snapshot is renamed into place but before the
+	// log is emptied
+	if err := os.WriteFile(path+".log", old, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	l, snapshot, entries := open()
+	if snapshot != "snapshot" {
+		t.Errorf("got snapshot %q", snapshot)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got entries %q already in the snapshot", entries)
+	}
+	l.Append([]byte("three"))
+	l.Close()
+
+	l, _, entries = open()
+	defer l.Close()
+	if !reflect.DeepEqual(entries, []string{"three"}) {
+		t.Errorf("got entries %q after recovery", entries)
+	}
+}
+
+func TestLogResetCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+
+	open := func() (*Log, string, []string) {
+		var snapshot string
+		var entries []string
+		l, err := OpenLog(path, func(bs []byte) error {
+			snapshot = string(bs)
+			return nil
+		}, func(bs []byte) error {
+			entries = append(entries, string(bs))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l, snapshot, entries
+	}
+
+	l, _, _ := open()
+	l.Append([]byte("one"))
+	if err := l.Compact([]byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Compact([]byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// simulate a crash part way through writing the generation line
+	for _, header := range []string{"#generation 2", "#generation ", "#gen", ""} {
+		if err := os.WriteFile(path+".log", []byte(header), 0666); err != nil {
+			t.Fatal(err)
+		}
+		l, snapshot, entries := open()
+		if snapshot != "snapshot" || len(entries) != 0 {
+			t.Errorf("%q: got snapshot %q and entries %q", header, snapshot, entries)
+		}
+		l.Append([]byte("two"))
+		l.Close()
+
+		l, _, entries = open()
+		l.Close()
+		if !reflect.DeepEqual(entries, []string{"two"}) {
+			t.Errorf("%q: got entries %q after recovery", header, entries)
+		}
+	}
+}
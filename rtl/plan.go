@@ -0,0 +1,167 @@
+package rtl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// plan records how to find the rows matching each alternative of a query.
+type plan struct {
+	n     int
+	paths []accessPath
+}
+
+// accessPath is a window [min, max) over either the rows in key order or, if
+// index is not nil, the rows in the order of that index. used counts the
+// clauses that narrowed the window; if none did, the path is a full scan. A
+// join instead finds its rows through the key or index, listing them in rows
+// with the window over that list.
+type accessPath struct {
+	index    *Index
+	min, max int
+	used     int
+	rows     []int
+}
+
+// planQuery picks, for each alternative of the query, whichever of a key
+// search, the indexes and its joins selects the fewest rows.
+func planQuery(q Query, n int, indexes []*Index) plan {
+	p := plan{n: n}
+	for alt := &q; alt != nil; alt = alt.alt {
+		best := accessPath{}
+		best.min, best.max, best.used = alt.keyRange(0, n)
+		for _, x := range indexes {
+			min, max, used := alt.indexRange(x)
+			if used != 0 && max-min < best.max-best.min {
+				best = accessPath{index: x, min: min, max: max, used: used}
+			}
+		}
+		for _, c := range alt.clauses {
+			if c.join == nil || c.op != eq {
+				continue
+			}
+			if a, ok := c.join.path(n, indexes); ok && a.max-a.min < best.max-best.min {
+				best = a
+			}
+		}
+		p.paths = append(p.paths, best)
+	}
+	return p
+}
+
+// path finds the rows matching the join through the key or the index that its
+// columns lead furthest, if they lead either.
+func (j *join) path(n int, indexes []*Index) (accessPath, bool) {
+	lead, index := j.keyLead(), (*Index)(nil)
+	for _, x := range indexes {
+		if l := j.indexLead(x); l > lead {
+			lead, index = l, x
+		}
+	}
+	if lead == 0 {
+		return accessPath{}, false
+	}
+	var rows []int
+	if index == nil {
+		rows = j.rows(lead, n, func(pos int) int { return pos })
+	} else {
+		rows = j.rows(lead, len(index.rows), func(pos int) int { return index.rows[pos] })
+	}
+	return accessPath{index: index, max: len(rows), used: 1, rows: rows}, true
+}
+
+// candidates works out which rows need to be tested against the query. Where
+// these form a single contiguous window it is returned as [min, max) with nil
+// rows. Otherwise rows lists their positions in order, with overlaps between
+// the alternatives removed, and [min, max) is the window over rows.
+func (p plan) candidates() (int, int, []int) {
+	if len(p.paths) == 1 && p.paths[0].index == nil && p.paths[0].rows == nil {
+		// an empty window still marks where the rows would be
+		return p.paths[0].min, p.paths[0].max, nil
+	}
+	var ranges [][2]int
+	indexed := false
+	for _, a := range p.paths {
+		if a.index != nil || a.rows != nil {
+			indexed = true
+			continue
+		}
+		if a.min < a.max {
+			ranges = append(ranges, [2]int{a.min, a.max})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+	var merged [][2]int
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r[0] <= merged[last][1] {
+			if r[1] > merged[last][1] {
+				merged[last][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	switch {
+	case len(merged) == 1 && (!indexed || merged[0] == [2]int{0, p.n}):
+		return merged[0][0], merged[0][1], nil
+	case len(merged) == 0 && !indexed:
+		return 0, 0, nil
+	}
+	rows := []int{}
+	for _, r := range merged {
+		for i := r[0]; i < r[1]; i++ {
+			rows = append(rows, i)
+		}
+	}
+	for _, a := range p.paths {
+		switch {
+		case a.rows != nil:
+			rows = append(rows, a.rows...)
+		case a.index != nil:
+			rows = append(rows, a.index.rows[a.min:a.max]...)
+		}
+	}
+	sort.Ints(rows)
+	unique := rows[:0]
+	for i, r := range rows {
+		if i == 0 || r != rows[i-1] {
+			unique = append(unique, r)
+		}
+	}
+	return 0, len(unique), unique
+}
+
+// Explain describes how the query would be evaluated over n rows with the
+// given indexes: the access path chosen for each alternative with the number
+// of rows it selects, and the total number of rows that would be examined.
+func (q Query) Explain(n int, indexes ...*Index) string {
+	var buf strings.Builder
+	p := planQuery(q, n, indexes)
+	for _, a := range p.paths {
+		switch {
+		case a.rows != nil && a.index == nil:
+			fmt.Fprintf(&buf, "join on key: %s\n", plural(len(a.rows), "row"))
+		case a.rows != nil:
+			fmt.Fprintf(&buf, "join on index columns %v: %s\n", a.index.columns, plural(len(a.rows), "row"))
+		case a.used == 0:
+			fmt.Fprintf(&buf, "full scan: %s\n", plural(a.max-a.min, "row"))
+		case a.index == nil:
+			fmt.Fprintf(&buf, "key search, %s: %s\n", plural(a.used, "clause"), plural(a.max-a.min, "row"))
+		default:
+			fmt.Fprintf(&buf, "index search on columns %v, %s: %s\n", a.index.columns, plural(a.used, "clause"), plural(a.max-a.min, "row"))
+		}
+	}
+	min, max, _ := p.candidates()
+	fmt.Fprintf(&buf, "examines %d of %d rows", max-min, n)
+	return buf.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
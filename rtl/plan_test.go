@@ -0,0 +1,109 @@
+package rtl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	rows := []struct {
+		id    int
+		group string
+	}{
+		{1, "a"},
+		{2, "b"},
+		{3, "a"},
+		{4, "c"},
+		{5, "b"},
+		{6, "a"},
+	}
+	id := IntIndex(0, func(idx int) int { return rows[idx].id })
+	group := StringColumn(1, func(idx int) string { return rows[idx].group })
+	x := NewIndex(func(i, j int) int {
+		return CompareString(rows[i].group, rows[j].group)
+	}, 1)
+	x.Reset(len(rows))
+
+	for _, test := range []struct {
+		name       string
+		q          Query
+		candidates []int
+		ids        []int
+		explain    string
+	}{
+		{
+			name:       "Key",
+			q:          id.Range(2, 3),
+			candidates: []int{1, 2},
+			ids:        []int{2, 3},
+			explain:    "key search, 2 clauses: 2 rows\nexamines 2 of 6 rows",
+		},
+		{
+			name:       "FullScan",
+			q:          group.Ne("a"),
+			candidates: []int{0, 1, 2, 3, 4, 5},
+			ids:        []int{2, 4, 5},
+			explain:    "full scan: 6 rows\nexamines 6 of 6 rows",
+		},
+		{
+			name:       "Index",
+			q:          group.Eq("b"),
+			candidates: []int{1, 4},
+			ids:        []int{2, 5},
+			explain:    "index search on columns [1], 1 clause: 2 rows\nexamines 2 of 6 rows",
+		},
+		{
+			name:       "Disjoint",
+			q:          id.Eq(1).Or(id.Eq(6)),
+			candidates: []int{0, 5},
+			ids:        []int{1, 6},
+			explain:    "key search, 1 clause: 1 row\nkey search, 1 clause: 1 row\nexamines 2 of 6 rows",
+		},
+		{
+			name:       "Overlapping",
+			q:          id.Range(1, 3).Or(id.Range(2, 4)).Or(group.Eq("c")),
+			candidates: []int{0, 1, 2, 3},
+			ids:        []int{1, 2, 3, 4},
+			explain:    "key search, 2 clauses: 3 rows\nkey search, 2 clauses: 3 rows\nindex search on columns [1], 1 clause: 1 row\nexamines 4 of 6 rows",
+		},
+		{
+			name:       "In",
+			q:          id.In(5, 2),
+			candidates: []int{1, 4},
+			ids:        []int{2, 5},
+			explain:    "key search, 1 clause: 1 row\nkey search, 1 clause: 1 row\nexamines 2 of 6 rows",
+		},
+		{
+			name:       "Mixed",
+			q:          id.Gt(5).Or(group.Eq("c")),
+			candidates: []int{3, 5},
+			ids:        []int{4, 6},
+			explain:    "key search, 1 clause: 1 row\nindex search on columns [1], 1 clause: 1 row\nexamines 2 of 6 rows",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var candidates, ids []int
+			min, max, rs := planQuery(test.q, len(rows), []*Index{x}).candidates()
+			for i := min; i < max; i++ {
+				if rs != nil {
+					candidates = append(candidates, rs[i])
+				} else {
+					candidates = append(candidates, i)
+				}
+			}
+			if !reflect.DeepEqual(candidates, test.candidates) {
+				t.Errorf("got candidates %v, expecting %v", candidates, test.candidates)
+			}
+			r := EvalQuery(test.q, len(rows), x)
+			for r.Next() {
+				ids = append(ids, rows[r.This()].id)
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("got rows %v, expecting %v", ids, test.ids)
+			}
+			if got := test.q.Explain(len(rows), x); got != test.explain {
+				t.Errorf("got plan:\n%s\nexpecting:\n%s", got, test.explain)
+			}
+		})
+	}
+}
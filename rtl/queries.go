@@ -10,8 +10,10 @@ type Query struct {
 }
 
 type QueryResult struct {
-	this, end int
-	q         Query
+	this, end     int
+	rows          []int
+	offset, limit int
+	q             Query
 }
 
 type test byte
@@ -24,12 +26,17 @@ const (
 	ge
 	ne
 	key
+	prefix
+	notPrefix
 )
 
 type clause struct {
 	columnID int
+	indexed  bool
 	op       test
 	cmp      func(int) int
+	null     func(int) bool
+	join     *join
 }
 
 // Query construction
@@ -38,24 +45,120 @@ func queryForClause(c clause) Query {
 	return Query{clauses: []clause{c}}
 }
 
+// Where builds a query from an arbitrary test of the row at a position. Such
+// a query cannot be used to narrow the search, so every row is tested.
+func Where(f func(idx int) bool) Query {
+	return queryForClause(clause{
+		columnID: -1,
+		op:       eq,
+		cmp: func(idx int) int {
+			if f(idx) {
+				return 0
+			}
+			return 1
+		},
+	})
+}
+
+// none matches no rows.
+func none() Query {
+	return Where(func(int) bool { return false })
+}
+
 // Query composition
 
+// And matches the rows matching both q and r. Where both have alternatives
+// the result has an alternative for each pair of them.
 func (q Query) And(r Query) Query {
-	var res Query
-	res.clauses = append(res.clauses, q.clauses...)
-	res.clauses = append(res.clauses, r.clauses...)
-	if q.alt != nil {
-		alt := q.alt.And(r)
-		res.alt = &alt
+	var res []Query
+	for qa := &q; qa != nil; qa = qa.alt {
+		for ra := &r; ra != nil; ra = ra.alt {
+			var a Query
+			a.clauses = append(a.clauses, qa.clauses...)
+			a.clauses = append(a.clauses, ra.clauses...)
+			res = append(res, a)
+		}
 	}
-	return res
+	return anyOf(res)
 }
 
 func (q Query) Or(r Query) Query {
-	return Query{
+	res := Query{
 		clauses: q.clauses,
 		alt:     &r,
 	}
+	if q.alt != nil {
+		alt := q.alt.Or(r)
+		res.alt = &alt
+	}
+	return res
+}
+
+// Not matches the rows that q does not match. The negation is pushed through
+// to the clauses, so the result has an alternative for each way of choosing
+// one clause from each of q's alternatives.
+func Not(q Query) Query {
+	var res Query
+	for qa := &q; qa != nil; qa = qa.alt {
+		if len(qa.clauses) == 0 {
+			return none()
+		}
+		var alts []Query
+		for _, c := range qa.clauses {
+			alts = append(alts, queryForClause(c.negate()))
+		}
+		res = res.And(anyOf(alts))
+	}
+	return res
+}
+
+// Any matches the rows matching any of qs. With no queries it matches no rows.
+func Any(qs ...Query) Query {
+	if len(qs) == 0 {
+		return none()
+	}
+	res := qs[len(qs)-1]
+	for i := len(qs) - 2; i >= 0; i-- {
+		res = qs[i].Or(res)
+	}
+	return res
+}
+
+// anyOf matches the rows matching any of qs, which must not be empty and must
+// not have alternatives.
+func anyOf(qs []Query) Query {
+	res := qs[len(qs)-1]
+	for i := len(qs) - 2; i >= 0; i-- {
+		alt := res
+		res = qs[i]
+		res.alt = &alt
+	}
+	return res
+}
+
+func (c clause) negate() clause {
+	switch c.op {
+	case eq, key:
+		c.op = ne
+	case ne:
+		c.op = eq
+		if c.indexed {
+			c.op = key
+		}
+	case lt:
+		c.op = ge
+	case le:
+		c.op = gt
+	case gt:
+		c.op = le
+	case ge:
+		c.op = lt
+	case prefix:
+		c.op = notPrefix
+	case notPrefix:
+		c.op = prefix
+	}
+	return c
 }
 
 // Query evaluation
@@ -66,66 +169,167 @@ func All(n int) *QueryResult {
 	}), n)
 }
 
-func EvalQuery(q Query, n int) *QueryResult {
-	res := &QueryResult{0, n, q}
-	res.refineSearchSpace()
+// EvalQuery finds the rows out of n that match q. The rows are visited in
+// order of position, using the indexes to avoid scanning every row where
+// possible.
+func EvalQuery(q Query, n int, indexes ...*Index) *QueryResult {
+	res := &QueryResult{q: q, limit: -1}
+	res.this, res.end, res.rows = planQuery(q, n, indexes).candidates()
 	res.this--
 	return res
 }
 
-func (c clause) key() bool { return c.op == key }
+// keyRange narrows the window [min, max) using the clauses on key columns,
+// returning the number of clauses used.
+func (q Query) keyRange(min, max int) (int, int, int) {
+	return q.bounds(min, max, func(k int, c clause) bool {
+		return c.indexed && c.columnID == k
+	}, func(idx int) int {
+		return idx
+	})
+}
 
-func (r *QueryResult) refineSearchSpace() {
-	this := r.this
-	end := r.end
-	for q := &r.q; q != nil; q = q.alt {
-		min := r.this
-		max := r.end
-		clauses := q.clauses
-		sort.Slice(clauses, func(i, j int) bool {
-			if clauses[i].key() != clauses[j].key() {
-				return clauses[i].key()
+// indexRange narrows the window [min, max) of the index's rows using the
+// clauses on its columns, returning the number of clauses used.
+func (q Query) indexRange(x *Index) (int, int, int) {
+	return q.bounds(0, len(x.rows), func(k int, c clause) bool {
+		return k < len(x.columns) && c.columnID == x.columns[k]
+	}, func(idx int) int {
+		return x.rows[idx]
+	})
+}
+
+// bounds narrows the window [min, max) over a sequence of rows sorted by some
+// columns. column reports whether a clause tests the kth of these columns,
+// and row maps positions in the sequence to row positions. Equality on each of
+// the leading columns selects a contiguous block of rows, within which the
+// rows are sorted by the next column. Range and prefix tests on that column
+// narrow the window further.
+func (q Query) bounds(min, max int, column func(int, clause) bool, row func(int) int) (int, int, int) {
+	used := 0
+	for k := 0; ; k++ {
+		equal := false
+		for _, c := range q.clauses {
+			if !column(k, c) {
+				continue
 			}
-			return clauses[i].columnID < clauses[j].columnID
-		})
-		for i, c := range clauses {
-			if c.op != key || i != c.columnID {
-				break
+			cmp := func(idx int) int { return c.cmp(row(idx)) }
+			switch c.op {
+			case key, eq, prefix:
+				equal = equal || c.op != prefix
+				min = search(min, max, func(c int) bool { return c >= 0 }, cmp)
+				max = search(min, max, func(c int) bool { return c > 0 }, cmp)
+			case gt:
+				min = search(min, max, func(c int) bool { return c > 0 }, cmp)
+			case ge:
+				min = search(min, max, func(c int) bool { return c >= 0 }, cmp)
+			case lt:
+				max = search(min, max, func(c int) bool { return c >= 0 }, cmp)
+			case le:
+				max = search(min, max, func(c int) bool { return c > 0 }, cmp)
+			default:
+				continue
 			}
-			min = sort.Search(max-min, func(idx int) bool {
-				return c.cmp(idx+min) >= 0
-			}) + min
-			max = sort.Search(max-min, func(idx int) bool {
-				return c.cmp(idx+min) > 0
-			}) + min
-		}
-		if min < this || q == &r.q {
-			this = min
+			used++
 		}
-		if max > end || q == &r.q {
-			end = max
+		if !equal {
+			return min, max, used
 		}
 	}
-	r.this = this
-	r.end = end
+}
+
+// search finds the first position in [min, max) for which f holds of the
+// comparison at that position, or max if there is none.
+func search(min, max int, f func(int) bool, cmp func(int) int) int {
+	if max <= min {
+		return min
+	}
+	return sort.Search(max-min, func(idx int) bool {
+		return f(cmp(idx + min))
+	}) + min
 }
 
 func (r *QueryResult) This() int {
+	if r.rows != nil && r.this < r.end {
+		return r.rows[r.this]
+	}
 	return r.this
 }
 
 func (r *QueryResult) Next() bool {
-	for {
+	for r.limit != 0 {
 		r.this++
 		if r.this >= r.end {
 			return false
 		}
-		for q := &r.q; q != nil; q = q.alt {
-			if q.matches(r.this) {
-				return true
-			}
+		if !r.matches(r.This()) {
+			continue
+		}
+		if r.offset > 0 {
+			r.offset--
+			continue
+		}
+		if r.limit > 0 {
+			r.limit--
+		}
+		return true
+	}
+	return false
+}
+
+func (r *QueryResult) matches(idx int) bool {
+	for q := &r.q; q != nil; q = q.alt {
+		if q.matches(idx) {
+			return true
+		}
+	}
+	return false
+}
+
+// Page skips the first offset matching rows and stops after limit more. A
+// negative limit means there is no limit.
+func (r *QueryResult) Page(offset, limit int) {
+	r.offset = offset
+	r.limit = limit
+}
+
+// Order describes how to sort rows by a column.
+type Order struct {
+	col  Column
+	desc bool
+}
+
+// OrderBy sorts rows by c, in descending order if desc is set.
+func OrderBy(c Column, desc bool) Order {
+	return Order{c, desc}
+}
+
+// Sort makes the result visit the remaining matching rows in the given order,
+// with rows that compare equal visited in order of position. Finding the
+// first row requires finding all of them.
+func (r *QueryResult) Sort(orders []Order) {
+	var rows []int
+	for r.this++; r.this < r.end; r.this++ {
+		if r.matches(r.This()) {
+			rows = append(rows, r.This())
 		}
 	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range orders {
+			c := o.col.compare(rows[i], rows[j])
+			if o.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	r.q = Query{}
+	r.rows = rows
+	r.this = -1
+	r.end = len(rows)
 }
 
 func (q Query) matches(idx int) bool {
@@ -138,9 +342,12 @@ func (q Query) matches(idx int) bool {
 }
 
 func (c clause) matches(idx int) bool {
+	if c.null != nil && c.null(idx) {
+		return false
+	}
 	cmp := c.cmp(idx)
 	switch c.op {
-	case key, eq:
+	case key, eq, prefix:
 		return cmp == 0
 	case lt:
 		return cmp < 0
@@ -150,7 +357,7 @@ func (c clause) matches(idx int) bool {
 		return cmp > 0
 	case ge:
 		return cmp >= 0
-	case ne:
+	case ne, notPrefix:
 		return cmp != 0
 	}
 	panic("invalid op")
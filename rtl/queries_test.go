@@ -1,6 +1,8 @@
 package rtl
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"testing"
 )
@@ -44,6 +46,13 @@ func TestQueryConstruction(t *testing.T) {
 		t.Error("missing alt")
 	}
 	t.Run("w2", assertClauses(*w.alt, 2))
+	x := w.Or(r)
+	if x.alt == nil || x.alt.alt == nil {
+		t.Fatal("missing alt")
+	}
+	t.Run("x1", assertClauses(x, 0))
+	t.Run("x2", assertClauses(*x.alt, 2))
+	t.Run("x3", assertClauses(*x.alt.alt, 1))
 }
 
 func TestQueryEvaluation(t *testing.T) {
@@ -117,6 +126,109 @@ func TestQueryEvaluation(t *testing.T) {
 				{1, "Banana", 20},
 			},
 		},
+		{
+			name: "OrderRange",
+			q:    orderID.Gt(1),
+			rows: []dept{
+				{2, "Apple", 10},
+				{2, "Cider", 1},
+			},
+		},
+		{
+			name: "ProductRangeInOrder",
+			q:    orderID.Eq(1).And(productID.Gt("Apple")).And(productID.Lt("Butter")),
+			rows: []dept{
+				{1, "Banana", 20},
+			},
+		},
+		{
+			name: "ProductPrefixInOrder",
+			q:    orderID.Eq(1).And(productID.HasPrefix("B")),
+			rows: []dept{
+				{1, "Banana", 20},
+				{1, "Butter", 1},
+			},
+		},
+		{
+			name: "ProductPrefix",
+			q:    productID.HasPrefix("Ap"),
+			rows: []dept{
+				{1, "Apple", 10},
+				{2, "Apple", 10},
+			},
+		},
+		{
+			name: "EmptyRange",
+			q:    orderID.Gt(1).And(orderID.Lt(2)),
+		},
+		{
+			name: "AndOr",
+			q:    quantity.Lt(15).And(productID.Eq("Apple").Or(productID.Eq("Cider"))),
+			rows: []dept{
+				{1, "Apple", 10},
+				{2, "Apple", 10},
+				{2, "Cider", 1},
+			},
+		},
+		{
+			name: "NotAnd",
+			q:    Not(orderID.Eq(1).And(quantity.Lt(15))),
+			rows: []dept{
+				{1, "Banana", 20},
+				{2, "Apple", 10},
+				{2, "Cider", 1},
+			},
+		},
+		{
+			name: "NotOr",
+			q:    Not(productID.HasPrefix("B").Or(quantity.Eq(1))),
+			rows: []dept{
+				{1, "Apple", 10},
+				{2, "Apple", 10},
+			},
+		},
+		{
+			name: "NotNot",
+			q:    Not(Not(orderID.Eq(2))),
+			rows: []dept{
+				{2, "Apple", 10},
+				{2, "Cider", 1},
+			},
+		},
+		{
+			name: "NotAll",
+			q:    Not(Query{}),
+		},
+		{
+			name: "In",
+			q:    orderID.Eq(1).And(productID.In("Butter", "Apple", "Cider")),
+			rows: []dept{
+				{1, "Apple", 10},
+				{1, "Butter", 1},
+			},
+		},
+		{
+			name: "InNothing",
+			q:    productID.In(),
+		},
+		{
+			name: "NotIn",
+			q:    quantity.NotIn(1, 20),
+			rows: []dept{
+				{1, "Apple", 10},
+				{2, "Apple", 10},
+			},
+		},
+		{
+			name: "Where",
+			q: orderID.Eq(1).And(Where(func(idx int) bool {
+				return len(rows[idx].productID) > 5
+			})),
+			rows: []dept{
+				{1, "Banana", 20},
+				{1, "Butter", 1},
+			},
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			got := runQuery(test.q)
@@ -137,3 +249,242 @@ func TestAll(t *testing.T) {
 		i++
 	}
 }
+
+func TestIntExtremes(t *testing.T) {
+	rows := []int{math.MinInt64, -1, 0, math.MaxInt64}
+	a := IntIndex(0, func(idx int) int { return rows[idx] })
+	for _, test := range []struct {
+		name string
+		q    Query
+		rows []int
+	}{
+		{"Gt", a.Gt(-1), []int{2, 3}},
+		{"Lt", a.Lt(math.MaxInt64), []int{0, 1, 2}},
+		{"Eq", a.Eq(math.MinInt64), []int{0}},
+		{"Ge", a.Ge(math.MinInt64 + 1), []int{1, 2, 3}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int
+			for r := EvalQuery(test.q, len(rows)); r.Next(); {
+				got = append(got, r.This())
+			}
+			if !reflect.DeepEqual(got, test.rows) {
+				t.Errorf("got %v, expecting %v", got, test.rows)
+			}
+		})
+	}
+}
+
+func TestFloatNaN(t *testing.T) {
+	rows := []float64{math.NaN(), math.NaN(), math.Inf(-1), -1, 0, math.Inf(1)}
+	a := Float64Index(0, func(idx int) float64 { return rows[idx] })
+	for _, test := range []struct {
+		name string
+		q    Query
+		rows []int
+	}{
+		{"EqNaN", a.Eq(math.NaN()), []int{0, 1}},
+		{"Eq", a.Eq(-1), []int{3}},
+		{"Gt", a.Gt(-1), []int{4, 5}},
+		{"Ge", a.Ge(math.Inf(-1)), []int{2, 3, 4, 5}},
+		{"Lt", a.Lt(-1), []int{0, 1, 2}},
+		{"NeNaN", a.Ne(math.NaN()), []int{2, 3, 4, 5}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int
+			for r := EvalQuery(test.q, len(rows)); r.Next(); {
+				got = append(got, r.This())
+			}
+			if !reflect.DeepEqual(got, test.rows) {
+				t.Errorf("got %v, expecting %v", got, test.rows)
+			}
+		})
+	}
+}
+
+func TestSortAndPage(t *testing.T) {
+	rows := []struct {
+		a int
+		b string
+	}{
+		{1, "c"},
+		{2, "a"},
+		{3, "b"},
+		{4, "a"},
+		{5, "c"},
+	}
+	a := IntIndex(0, func(idx int) int { return rows[idx].a })
+	b := StringColumn(1, func(idx int) string { return rows[idx].b })
+	for _, test := range []struct {
+		name          string
+		q             Query
+		order         []Order
+		offset, limit int
+		rows          []int
+	}{
+		{"Page", Query{}, nil, 1, 2, []int{1, 2}},
+		{"Unlimited", a.Gt(2), nil, 1, -1, []int{3, 4}},
+		{"Empty", Query{}, nil, 0, 0, nil},
+		{"Sort", Query{}, []Order{OrderBy(b, false)}, 0, -1, []int{1, 3, 2, 0, 4}},
+		{"SortDesc", Query{}, []Order{OrderBy(b, true), OrderBy(a, true)}, 0, -1, []int{4, 0, 2, 3, 1}},
+		{"SortPage", a.Ne(3), []Order{OrderBy(b, false)}, 1, 2, []int{3, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := EvalQuery(test.q, len(rows))
+			if test.order != nil {
+				r.Sort(test.order)
+			}
+			r.Page(test.offset, test.limit)
+			var got []int
+			for r.Next() {
+				got = append(got, r.This())
+			}
+			if !reflect.DeepEqual(got, test.rows) {
+				t.Errorf("got %v, expecting %v", got, test.rows)
+			}
+		})
+	}
+}
+
+func TestOptional(t *testing.T) {
+	rows := []*int{nil, nil, nil, new(int), new(int), new(int)}
+	*rows[3], *rows[4], *rows[5] = 1, 2, 3
+	val := func(idx int) (v int) {
+		if rows[idx] != nil {
+			v = *rows[idx]
+		}
+		return v
+	}
+	null := func(idx int) bool { return rows[idx] == nil }
+	for _, a := range []Int{IntIndex(0, val).Optional(null), IntColumn(0, val).Optional(null)} {
+		for _, test := range []struct {
+			name string
+			q    Query
+			rows []int
+		}{
+			{"Eq", a.Eq(0), nil},
+			{"Lt", a.Lt(2), []int{3}},
+			{"Ge", a.Ge(2), []int{4, 5}},
+			{"Ne", a.Ne(2), []int{3, 5}},
+			{"NotEq", Not(a.Eq(2)), []int{3, 5}},
+			{"IsNull", a.IsNull(), []int{0, 1, 2}},
+			{"IsNotNull", a.IsNotNull(), []int{3, 4, 5}},
+			{"NotIsNull", Not(a.IsNull()), []int{3, 4, 5}},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				var got []int
+				for r := EvalQuery(test.q, len(rows)); r.Next(); {
+					got = append(got, r.This())
+				}
+				if !reflect.DeepEqual(got, test.rows) {
+					t.Errorf("got %v, expecting %v", got, test.rows)
+				}
+			})
+		}
+	}
+	a := IntColumn(0, val).Optional(null)
+	r := All(len(rows))
+	r.Sort([]Order{OrderBy(a, true)})
+	var got []int
+	for r.Next() {
+		got = append(got, r.This())
+	}
+	if !reflect.DeepEqual(got, []int{5, 4, 3, 0, 1, 2}) {
+		t.Errorf("got %v sorted", got)
+	}
+	if sum := a.Sum(All(len(rows))); sum != 6 {
+		t.Errorf("got sum %d", sum)
+	}
+	if avg, ok := a.Avg(All(len(rows))); avg != 2 || !ok {
+		t.Errorf("got average %g", avg)
+	}
+	if groups := GroupBy(All(len(rows)), a); len(groups) != 4 {
+		t.Errorf("got %d groups", len(groups))
+	}
+}
+
+func TestKeyRange(t *testing.T) {
+	rows := []struct {
+		a int
+		b string
+	}{
+		{1, "a"},
+		{1, "ab"},
+		{1, "abc"},
+		{1, "b"},
+		{2, "a"},
+		{3, "a"},
+	}
+	a := IntIndex(0, func(idx int) int { return rows[idx].a })
+	b := StringIndex(1, func(idx int) string { return rows[idx].b })
+	for _, test := range []struct {
+		name     string
+		q        Query
+		min, max int
+	}{
+		{"Eq", a.Eq(1), 0, 4},
+		{"Ge", a.Ge(2), 4, 6},
+		{"Lt", a.Lt(2), 0, 4},
+		{"Range", a.Range(2, 3), 4, 6},
+		{"EqGt", a.Eq(1).And(b.Gt("ab")), 2, 4},
+		{"EqPrefix", a.Eq(1).And(b.HasPrefix("ab")), 1, 3},
+		{"Prefix", b.HasPrefix("ab"), 0, 6},
+		{"RangeThenEq", a.Lt(2).And(b.Eq("b")), 0, 4},
+		{"NotNotEq", Not(Not(a.Eq(1))), 0, 4},
+		{"NotGe", Not(a.Ge(2)), 0, 4},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			min, max, _ := test.q.keyRange(0, len(rows))
+			if min != test.min || max != test.max {
+				t.Errorf("got [%d, %d), expecting [%d, %d)", min, max, test.min, test.max)
+			}
+		})
+	}
+}
+
+type benchRow struct {
+	id   int
+	name string
+}
+
+func benchRows() []benchRow {
+	rows := make([]benchRow, 1000000)
+	for i := range rows {
+		rows[i] = benchRow{i / 10, fmt.Sprintf("name%07d", i)}
+	}
+	return rows
+}
+
+func benchQuery(b *testing.B, q Query, n int) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := EvalQuery(q, n)
+		for r.Next() {
+		}
+	}
+}
+
+func BenchmarkRangeScan(b *testing.B) {
+	rows := benchRows()
+	id := IntIndex(0, func(idx int) int { return rows[idx].id })
+	benchQuery(b, id.Range(50000, 50100), len(rows))
+}
+
+func BenchmarkRangeFullScan(b *testing.B) {
+	rows := benchRows()
+	id := IntColumn(0, func(idx int) int { return rows[idx].id })
+	benchQuery(b, id.Range(50000, 50100), len(rows))
+}
+
+func BenchmarkPrefixScan(b *testing.B) {
+	rows := benchRows()
+	id := IntIndex(0, func(idx int) int { return rows[idx].id })
+	name := StringIndex(1, func(idx int) string { return rows[idx].name })
+	benchQuery(b, id.Eq(50000).And(name.HasPrefix("name050000")), len(rows))
+}
+
+func BenchmarkPrefixFullScan(b *testing.B) {
+	rows := benchRows()
+	name := StringColumn(1, func(idx int) string { return rows[idx].name })
+	benchQuery(b, name.HasPrefix("name05000"), len(rows))
+}
@@ -1,18 +1,24 @@
 package rtl
 
 import (
+	"encoding/hex"
 	"github.com/bobappleyard/er"
 	"github.com/pkg/errors"
 	"strconv"
+	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
 
+const dateFormat = "2006-01-02"
+
 type Reader struct {
 	parent *Reader
 	src    []byte
 	pos    int
 	name   string
+	seen   []string
 	err    error
 }
 
@@ -34,9 +40,27 @@ func (p *Reader) Next() bool {
 	if !p.parseName() {
 		return false
 	}
+	if p.parent != nil {
+		p.seen = append(p.seen, p.name)
+	}
 	return true
 }
 
+// Require fails with ErrMissingAttribute unless the record has given each of
+// names.
+func (p *Reader) Require(names ...string) {
+	for _, name := range names {
+		found := false
+		for _, s := range p.seen {
+			found = found || s == name
+		}
+		if !found {
+			p.SetErr(er.ErrMissingAttribute)
+			return
+		}
+	}
+}
+
 func (p *Reader) Name() string {
 	return p.name
 }
@@ -72,31 +96,138 @@ func (p *Reader) Record() *Reader {
 }
 
 func (p *Reader) StringAttr() string {
-	if !p.skipSpace() {
-		p.SetErr(er.ErrBadSyntax)
+	if !p.startAttr() {
 		return ""
 	}
-	if p.readChar() != ':' {
-		p.SetErr(er.ErrBadSyntax)
-		return ""
+	res, err := strconv.Unquote(p.parseAttr())
+	if err != nil {
+		p.SetErr(err)
 	}
-	if !p.skipSpace() {
+	return res
+}
+
+func (p *Reader) IntAttr() int {
+	if !p.startAttr() {
+		return 0
+	}
+	res, err := strconv.Atoi(p.parseNumber())
+	if err != nil {
+		p.SetErr(err)
+	}
+	return res
+}
+
+func (p *Reader) FloatAttr() float64 {
+	if !p.startAttr() {
+		return 0
+	}
+	// a token rather than a number, so that NaN, +Inf and -Inf are read back
+	res, err := strconv.ParseFloat(p.parseToken(), 64)
+	if err != nil {
+		p.SetErr(err)
+	}
+	return res
+}
+
+func (p *Reader) BoolAttr() bool {
+	if !p.startAttr() {
+		return false
+	}
+	switch p.parseToken() {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	p.SetErr(er.ErrBadSyntax)
+	return false
+}
+
+// TimeAttr reads a timestamp in RFC 3339 format, such as
+// 2006-01-02T15:04:05Z.
+func (p *Reader) TimeAttr() time.Time {
+	return p.timeAttr(time.RFC3339Nano)
+}
+
+// DateAttr reads a date such as 2006-01-02, as midnight UTC.
+func (p *Reader) DateAttr() time.Time {
+	return p.timeAttr(dateFormat)
+}
+
+func (p *Reader) timeAttr(format string) time.Time {
+	if !p.startAttr() {
+		return time.Time{}
+	}
+	res, err := time.Parse(format, p.parseToken())
+	if err != nil {
+		p.SetErr(err)
+	}
+	return res
+}
+
+// BytesAttr reads bytes written in hexadecimal after 0x.
+func (p *Reader) BytesAttr() []byte {
+	if !p.startAttr() {
+		return nil
+	}
+	tok := p.parseToken()
+	if !strings.HasPrefix(tok, "0x") {
 		p.SetErr(er.ErrBadSyntax)
-		return ""
+		return nil
 	}
-	res, err := strconv.Unquote(p.parseAttr())
+	res, err := hex.DecodeString(tok[2:])
+	if err != nil {
+		p.SetErr(err)
+	}
+	return res
+}
+
+func (p *Reader) DecimalAttr() Decimal {
+	if !p.startAttr() {
+		return Decimal{}
+	}
+	res, err := ParseDecimal(p.parseNumber())
 	if err != nil {
 		p.SetErr(err)
 	}
 	return res
 }
 
+// EnumAttr reads a quoted value, returning its position in values. Anything
+// else is an invalid attribute.
+func (p *Reader) EnumAttr(values []string) int {
+	val := p.StringAttr()
+	for i, v := range values {
+		if v == val {
+			return i
+		}
+	}
+	p.SetErr(er.ErrInvalidAttribute)
+	return 0
+}
+
 func (p *Reader) ExpectEOF() {
 	if p.pos < len(p.src) {
 		p.SetErr(er.ErrBadSyntax)
 	}
 }
 
+func (p *Reader) startAttr() bool {
+	if !p.skipSpace() {
+		p.SetErr(er.ErrBadSyntax)
+		return false
+	}
+	if p.readChar() != ':' {
+		p.SetErr(er.ErrBadSyntax)
+		return false
+	}
+	if !p.skipSpace() {
+		p.SetErr(er.ErrBadSyntax)
+		return false
+	}
+	return true
+}
+
 func (p *Reader) running() bool {
 	return p != nil && p.err == nil && p.pos < len(p.src)
 }
@@ -160,3 +291,26 @@ func (p *Reader) parseAttr() string {
 	}
 	return string(p.src[attrStart:p.pos])
 }
+
+// parseToken reads up to the next space or closing brace.
+func (p *Reader) parseToken() string {
+	start := p.pos
+	for p.running() {
+		if r := p.readChar(); r == '}' || unicode.IsSpace(r) {
+			p.unreadChar()
+			break
+		}
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *Reader) parseNumber() string {
+	numStart := p.pos
+	for p.running() {
+		if !strings.ContainsRune("+-.0123456789eE", p.readChar()) {
+			p.unreadChar()
+			break
+		}
+	}
+	return string(p.src[numStart:p.pos])
+}
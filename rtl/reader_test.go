@@ -1,7 +1,13 @@
 package rtl
 
 import (
+	"bytes"
+	"errors"
+	"math"
 	"testing"
+	"time"
+
+	"github.com/bobappleyard/er"
 )
 
 func TestReaderNext(t *testing.T) {
@@ -70,3 +76,177 @@ func TestParseAttr(t *testing.T) {
 		t.Error("Err() failed")
 	}
 }
+
+func TestWriterRoundTrip(t *testing.T) {
+	for _, indent := range []string{"", "\t"} {
+		w := NewWriter(indent)
+		w.Begin("rec")
+		w.StringAttr("s", "a \"quoted\"\nvalue")
+		w.IntAttr("i", -42)
+		w.Begin("sub")
+		w.FloatAttr("f", 1.5e10)
+		w.End()
+		w.End()
+
+		p := NewReader(w.Bytes())
+		if !p.Next() || p.Name() != "rec" {
+			t.Fatalf("missing record in %q", w.Bytes())
+		}
+		r := p.Record()
+		var s string
+		var i int
+		var f float64
+		for r.Next() {
+			switch r.Name() {
+			case "s":
+				s = r.StringAttr()
+			case "i":
+				i = r.IntAttr()
+			case "sub":
+				sub := r.Record()
+				for sub.Next() {
+					f = sub.FloatAttr()
+				}
+			}
+		}
+		if p.Next() {
+			t.Errorf("%q: unexpected %s", w.Bytes(), p.Name())
+		}
+		p.ExpectEOF()
+		if p.Err() != nil {
+			t.Errorf("%q: %v", w.Bytes(), p.Err())
+		}
+		if s != "a \"quoted\"\nvalue" || i != -42 || f != 1.5e10 {
+			t.Errorf("%q: got %q %d %g", w.Bytes(), s, i, f)
+		}
+	}
+}
+
+func TestFloatRoundTrip(t *testing.T) {
+	for _, f := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), -0.5} {
+		w := NewWriter("")
+		w.Begin("rec")
+		w.FloatAttr("f", f)
+		w.End()
+		w.Begin("rec")
+		w.FloatAttr("f", f)
+		w.IntAttr("i", 1)
+		w.End()
+
+		p := NewReader(w.Bytes())
+		for p.Next() {
+			r := p.Record()
+			for r.Next() {
+				switch r.Name() {
+				case "f":
+					got := r.FloatAttr()
+					if got != f && !(math.IsNaN(got) && math.IsNaN(f)) {
+						t.Errorf("%s: got %g", w.Bytes(), got)
+					}
+				case "i":
+					r.IntAttr()
+				}
+			}
+		}
+		p.ExpectEOF()
+		if p.Err() != nil {
+			t.Errorf("%s: %v", w.Bytes(), p.Err())
+		}
+	}
+}
+
+func TestLiteralRoundTrip(t *testing.T) {
+	at := time.Date(2024, 2, 29, 13, 14, 15, 16, time.FixedZone("", 3600))
+	day := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	price := NewDecimal(-1250, 2)
+	w := NewWriter("")
+	w.Begin("rec")
+	w.BoolAttr("b", true)
+	w.TimeAttr("t", at)
+	w.DateAttr("d", day)
+	w.BytesAttr("x", []byte{0, 0xfe})
+	w.DecimalAttr("p", price)
+	w.End()
+	expected := `rec { b: true t: 2024-02-29T13:14:15.000000016+01:00 d: 2024-02-29 x: 0x00fe p: -12.5 }`
+	if string(w.Bytes()) != expected {
+		t.Errorf("got %s, expecting %s", w.Bytes(), expected)
+	}
+
+	p := NewReader(w.Bytes())
+	p.Next()
+	r := p.Record()
+	for r.Next() {
+		var ok bool
+		switch r.Name() {
+		case "b":
+			ok = r.BoolAttr()
+		case "t":
+			ok = r.TimeAttr().Equal(at)
+		case "d":
+			ok = r.DateAttr().Equal(day)
+		case "x":
+			ok = bytes.Equal(r.BytesAttr(), []byte{0, 0xfe})
+		case "p":
+			ok = r.DecimalAttr() == price
+		}
+		if !ok {
+			t.Errorf("%s did not survive the round trip", r.Name())
+		}
+	}
+	p.ExpectEOF()
+	if p.Err() != nil {
+		t.Error(p.Err())
+	}
+	for _, src := range []string{`b: yes`, `x: fe`, `x: 0xf`, `d: 2024-02-30`, `p: 1.2.3`} {
+		p := NewReader([]byte(src))
+		p.Next()
+		switch p.Name() {
+		case "b":
+			p.BoolAttr()
+		case "x":
+			p.BytesAttr()
+		case "d":
+			p.DateAttr()
+		case "p":
+			p.DecimalAttr()
+		}
+		if p.Err() == nil {
+			t.Errorf("%s: expecting an error", src)
+		}
+	}
+}
+
+func TestEnumAttr(t *testing.T) {
+	values := []string{"on", "off"}
+	w := NewWriter("")
+	w.EnumAttr("a", values, 1)
+	w.EnumAttr("b", values, 2)
+	if string(w.Bytes()) != `a: "off" b: 2` {
+		t.Errorf("got %s", w.Bytes())
+	}
+	p := NewReader([]byte(`a: "off" b: "dim"`))
+	p.Next()
+	if v := p.EnumAttr(values); v != 1 || p.Err() != nil {
+		t.Errorf("got %d, %v", v, p.Err())
+	}
+	p.Next()
+	p.EnumAttr(values)
+	if !errors.Is(p.Err(), er.ErrInvalidAttribute) {
+		t.Errorf("got %v, expecting %v", p.Err(), er.ErrInvalidAttribute)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	p := NewReader([]byte(`r { a: 1 } r { b: 2 }`))
+	for _, err := range []error{nil, er.ErrMissingAttribute} {
+		p.Next()
+		r := p.Record()
+		for r.Next() {
+			r.IntAttr()
+		}
+		r.Require("a")
+		if !errors.Is(r.Err(), err) {
+			t.Errorf("got %v, expecting %v", r.Err(), err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package rtl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/bobappleyard/er"
+)
+
+// Pattern is a regular expression that must match the whole of a string.
+type Pattern struct {
+	re *regexp.Regexp
+}
+
+// NewPattern compiles a pattern in the syntax of package regexp.
+func NewPattern(src string) (Pattern, error) {
+	re, err := regexp.Compile(`^(?:` + src + `)$`)
+	if err != nil {
+		return Pattern{}, er.ErrInvalidAttribute
+	}
+	return Pattern{re}, nil
+}
+
+// MustPattern is like NewPattern but panics if the pattern does not compile.
+func MustPattern(src string) Pattern {
+	p, err := NewPattern(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p Pattern) Match(s string) bool {
+	return p.re.MatchString(s)
+}
+
+// ValidateRules checks that the rules declared on an attribute suit its type.
+// Bounds on int attributes must be whole numbers.
+func ValidateRules(a *er.Attribute) error {
+	bounded := a.Min != nil || a.Max != nil
+	if bounded && a.Type != er.IntType && a.Type != er.FloatType {
+		return er.ErrInvalidAttribute
+	}
+	for _, b := range []*float64{a.Min, a.Max} {
+		if b != nil && (math.IsNaN(*b) || a.Type == er.IntType && *b != math.Trunc(*b)) {
+			return er.ErrInvalidAttribute
+		}
+	}
+	if a.Min != nil && a.Max != nil && *a.Min > *a.Max {
+		return er.ErrInvalidAttribute
+	}
+	if a.Pattern != "" {
+		if a.Type != er.StringType {
+			return er.ErrInvalidAttribute
+		}
+		if _, err := NewPattern(a.Pattern); err != nil {
+			return err
+		}
+	}
+	if (a.MaxLength != 0 || a.NonEmpty) && a.Type != er.StringType && a.Type != er.BytesType {
+		return er.ErrInvalidAttribute
+	}
+	if a.MaxLength < 0 {
+		return er.ErrInvalidAttribute
+	}
+	return nil
+}
+
+// BrokenRule reports that a value of an attribute, named as in er.Attribute's
+// String method, breaks a rule, such as "min 0". The error matches
+// er.ErrBrokenRule.
+func BrokenRule(attr, rule string) error {
+	return fmt.Errorf("%s: %s: %w", attr, rule, er.ErrBrokenRule)
+}
@@ -0,0 +1,56 @@
+package rtl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/er"
+)
+
+func TestPattern(t *testing.T) {
+	p := MustPattern("[a-z]+|x[0-9]")
+	for s, match := range map[string]bool{
+		"abc": true,
+		"x1":  true,
+		"aB":  false,
+		"x12": false,
+		"":    false,
+	} {
+		if p.Match(s) != match {
+			t.Errorf("%q: got %t, expecting %t", s, !match, match)
+		}
+	}
+	if _, err := NewPattern("[a-"); err != er.ErrInvalidAttribute {
+		t.Errorf("got %v, expecting %v", err, er.ErrInvalidAttribute)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	half, one, two := 0.5, 1.0, 2.0
+	for _, test := range []struct {
+		name  string
+		a     er.Attribute
+		valid bool
+	}{
+		{"IntBounds", er.Attribute{Type: er.IntType, Min: &one, Max: &two}, true},
+		{"FloatBound", er.Attribute{Type: er.FloatType, Max: &half}, true},
+		{"WholeBound", er.Attribute{Type: er.IntType, Max: &half}, false},
+		{"Crossed", er.Attribute{Type: er.IntType, Min: &two, Max: &one}, false},
+		{"BoundType", er.Attribute{Type: er.StringType, Min: &one}, false},
+		{"Pattern", er.Attribute{Type: er.StringType, Pattern: "a*"}, true},
+		{"PatternType", er.Attribute{Type: er.BytesType, Pattern: "a*"}, false},
+		{"Length", er.Attribute{Type: er.BytesType, MaxLength: 3, NonEmpty: true}, true},
+		{"LengthType", er.Attribute{Type: er.IntType, NonEmpty: true}, false},
+	} {
+		if err := ValidateRules(&test.a); (err == nil) != test.valid {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestBrokenRule(t *testing.T) {
+	err := BrokenRule("a.size", "min 0")
+	if !errors.Is(err, er.ErrBrokenRule) || err.Error() != "a.size: min 0: attribute rule broken" {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package rtl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer produces records in the format understood by Reader.
+type Writer struct {
+	buf    bytes.Buffer
+	indent string
+	depth  int
+	empty  bool
+}
+
+// NewWriter creates a writer that places each item on its own line, indented
+// by indent for each level of nesting. If indent is empty the output is kept
+// on a single line.
+func NewWriter(indent string) *Writer {
+	return &Writer{indent: indent, empty: true}
+}
+
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+func (w *Writer) Begin(name string) {
+	w.separate(w.depth)
+	w.buf.WriteString(name)
+	w.buf.WriteString(" {")
+	w.depth++
+}
+
+func (w *Writer) End() {
+	w.depth--
+	w.separate(w.depth)
+	w.buf.WriteByte('}')
+	if w.depth == 0 && w.indent != "" {
+		w.buf.WriteByte('\n')
+	}
+}
+
+func (w *Writer) StringAttr(name, val string) {
+	w.attr(name, strconv.Quote(val))
+}
+
+func (w *Writer) IntAttr(name string, val int) {
+	w.attr(name, strconv.Itoa(val))
+}
+
+func (w *Writer) FloatAttr(name string, val float64) {
+	w.attr(name, strconv.FormatFloat(val, 'g', -1, 64))
+}
+
+func (w *Writer) BoolAttr(name string, val bool) {
+	w.attr(name, strconv.FormatBool(val))
+}
+
+func (w *Writer) TimeAttr(name string, val time.Time) {
+	w.attr(name, val.Format(time.RFC3339Nano))
+}
+
+func (w *Writer) DateAttr(name string, val time.Time) {
+	w.attr(name, val.Format(dateFormat))
+}
+
+func (w *Writer) BytesAttr(name string, val []byte) {
+	w.attr(name, "0x"+hex.EncodeToString(val))
+}
+
+func (w *Writer) DecimalAttr(name string, val Decimal) {
+	w.attr(name, val.String())
+}
+
+// EnumAttr writes the value at position val. Positions outside values are
+// written as numbers, which EnumAttr on the Reader rejects.
+func (w *Writer) EnumAttr(name string, values []string, val int) {
+	if val < 0 || val >= len(values) {
+		w.IntAttr(name, val)
+		return
+	}
+	w.StringAttr(name, values[val])
+}
+
+func (w *Writer) attr(name, val string) {
+	w.separate(w.depth)
+	w.buf.WriteString(name)
+	w.buf.WriteString(": ")
+	w.buf.WriteString(val)
+}
+
+func (w *Writer) separate(depth int) {
+	if w.empty {
+		w.empty = false
+		return
+	}
+	if w.indent == "" {
+		w.buf.WriteByte(' ')
+		return
+	}
+	if depth == 0 {
+		w.buf.WriteByte('\n')
+		return
+	}
+	w.buf.WriteByte('\n')
+	w.buf.WriteString(strings.Repeat(w.indent, depth))
+}
@@ -0,0 +1,429 @@
+// Package schema reads entity models written in the record format, such as
+//
+//	name: "shop"
+//	type {
+//		name: "order"
+//		description: "A request for goods."
+//		annotation { key: "owner" value: "sales" }
+//		attribute { name: "number" type: "int" identifying: true }
+//		attribute { name: "state" type: "enum" enum: "order_state" default: "open" }
+//		attribute { name: "lines" type: "int" min: 1 }
+//		relationship { name: "customer" type_name: "customer" key: "by_email" }
+//		derived { name: "line_count" count: "line" via: "order" }
+//	}
+//	enum { name: "order_state" value: "open" value: "shipped" }
+//	type {
+//		name: "payment"
+//		attribute { name: "id" type: "int" identifying: true }
+//		subtyping { exclusive: true total: true mapping: "single_table" }
+//	}
+//	type { name: "card_payment" supertype: "payment" }
+//
+// The record and attribute names follow the rsf tags on the er types. Any
+// element may have a description and annotations. Models are returned as
+// written, ready for LogicalToPhysical.
+package schema
+
+import (
+	"github.com/bobappleyard/er"
+	"github.com/bobappleyard/er/rtl"
+)
+
+// Parse reads an entity model and resolves the names it uses to refer to
+// types and relationships.
+func Parse(src []byte) (*er.EntityModel, error) {
+	m := &er.EntityModel{}
+	p := rtl.NewReader(src)
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			m.Name = p.StringAttr()
+		case "type":
+			m.Types = append(m.Types, parseType(p.Record()))
+		case "enum":
+			m.Enums = append(m.Enums, parseEnum(p.Record()))
+		case "description", "annotation":
+			parseDoc(p, &m.Doc)
+		default:
+			p.SetErr(er.ErrInvalidRecord)
+		}
+	}
+	p.ExpectEOF()
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	if err := resolve(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func parseType(p *rtl.Reader) *er.EntityType {
+	t := &er.EntityType{}
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			t.Name = p.StringAttr()
+		case "attribute":
+			t.Attributes = append(t.Attributes, parseAttribute(p.Record(), t))
+		case "derived":
+			t.Derived = append(t.Derived, parseDerived(p.Record(), t))
+		case "relationship":
+			t.Relationships = append(t.Relationships, parseRelationship(p.Record(), t))
+		case "index":
+			t.Indexes = append(t.Indexes, parseIndex(p.Record(), t))
+		case "depends_on":
+			t.DependsOnName = p.StringAttr()
+		case "supertype":
+			t.SupertypeName = p.StringAttr()
+		case "subtyping":
+			t.Subtyping = parseSubtyping(p.Record())
+		case "surrogate":
+			var ok bool
+			if t.Surrogate, ok = er.ParseKeyGenerator(p.StringAttr()); !ok {
+				p.SetErr(er.ErrInvalidAttribute)
+			}
+		default:
+			parseDoc(p, &t.Doc)
+		}
+	}
+	return t
+}
+
+// parseDoc reads the description or an annotation of a model element, failing
+// on anything else.
+func parseDoc(p *rtl.Reader, d *er.Doc) {
+	switch p.Name() {
+	case "description":
+		d.Description = p.StringAttr()
+	case "annotation":
+		var a er.Annotation
+		r := p.Record()
+		for r.Next() {
+			switch r.Name() {
+			case "key":
+				a.Key = r.StringAttr()
+			case "value":
+				a.Value = r.StringAttr()
+			default:
+				r.SetErr(er.ErrInvalidAttribute)
+			}
+		}
+		d.Annotations = append(d.Annotations, a)
+	default:
+		p.SetErr(er.ErrInvalidAttribute)
+	}
+}
+
+func parseSubtyping(p *rtl.Reader) er.Subtyping {
+	var s er.Subtyping
+	for p.Next() {
+		switch p.Name() {
+		case "exclusive":
+			s.Exclusive = p.BoolAttr()
+		case "total":
+			s.Total = p.BoolAttr()
+		case "mapping":
+			var ok bool
+			if s.Mapping, ok = er.ParseSubtypeMapping(p.StringAttr()); !ok {
+				p.SetErr(er.ErrInvalidAttribute)
+			}
+		default:
+			p.SetErr(er.ErrInvalidAttribute)
+		}
+	}
+	return s
+}
+
+func parseEnum(p *rtl.Reader) *er.Enum {
+	e := &er.Enum{}
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			e.Name = p.StringAttr()
+		case "value":
+			e.Values = append(e.Values, p.StringAttr())
+		default:
+			parseDoc(p, &e.Doc)
+		}
+	}
+	return e
+}
+
+func parseAttribute(p *rtl.Reader, t *er.EntityType) *er.Attribute {
+	a := &er.Attribute{Owner: t}
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			a.Name = p.StringAttr()
+		case "type":
+			a.Type = er.ParseAttributeType(p.StringAttr())
+			if a.Type == er.InvalidType {
+				p.SetErr(er.ErrInvalidAttribute)
+			}
+		case "identifying":
+			a.Identifying = p.BoolAttr()
+		case "optional":
+			a.Optional = p.BoolAttr()
+		case "collation":
+			a.Collation = p.StringAttr()
+			if _, err := rtl.NewCollation(a.Collation); err != nil {
+				p.SetErr(err)
+			}
+		case "enum":
+			a.EnumName = p.StringAttr()
+		case "default":
+			a.Default = p.StringAttr()
+		case "min":
+			v := p.FloatAttr()
+			a.Min = &v
+		case "max":
+			v := p.FloatAttr()
+			a.Max = &v
+		case "pattern":
+			a.Pattern = p.StringAttr()
+		case "max_length":
+			a.MaxLength = p.IntAttr()
+		case "non_empty":
+			a.NonEmpty = p.BoolAttr()
+		default:
+			parseDoc(p, &a.Doc)
+		}
+	}
+	if a.Optional && a.Identifying {
+		p.SetErr(er.ErrInvalidAttribute)
+	}
+	if err := rtl.ValidateRules(a); err != nil {
+		p.SetErr(err)
+	}
+	return a
+}
+
+func parseDerived(p *rtl.Reader, t *er.EntityType) *er.DerivedAttribute {
+	d := &er.DerivedAttribute{Owner: t}
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			d.Name = p.StringAttr()
+		case "component":
+			d.Path = append(d.Path, parseComponent(p.Record()))
+		case "attribute":
+			d.AttributeName = p.StringAttr()
+		case "count":
+			d.CountTypeName = p.StringAttr()
+		case "via":
+			d.CountRelName = p.StringAttr()
+		default:
+			parseDoc(p, &d.Doc)
+		}
+	}
+	return d
+}
+
+func parseRelationship(p *rtl.Reader, t *er.EntityType) *er.Relationship {
+	r := &er.Relationship{Source: t}
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			r.Name = p.StringAttr()
+		case "type_name":
+			r.TargetName = p.StringAttr()
+		case "identifying":
+			r.Identifying = p.BoolAttr()
+		case "key":
+			r.KeyName = p.StringAttr()
+		case "constraint":
+			r.Constraints = append(r.Constraints, parseConstraint(p.Record()))
+		default:
+			parseDoc(p, &r.Doc)
+		}
+	}
+	return r
+}
+
+func parseConstraint(p *rtl.Reader) er.Constraint {
+	var c er.Constraint
+	for p.Next() {
+		switch p.Name() {
+		case "diagonal":
+			c.Diagonal.Components = parsePath(p.Record())
+		case "riser":
+			c.Riser.Components = parsePath(p.Record())
+		default:
+			p.SetErr(er.ErrInvalidAttribute)
+		}
+	}
+	return c
+}
+
+func parsePath(p *rtl.Reader) []er.Component {
+	var res []er.Component
+	for p.Next() {
+		if p.Name() != "component" {
+			p.SetErr(er.ErrInvalidAttribute)
+			continue
+		}
+		res = append(res, parseComponent(p.Record()))
+	}
+	return res
+}
+
+func parseComponent(p *rtl.Reader) er.Component {
+	var c er.Component
+	for p.Next() {
+		if p.Name() != "rel_name" {
+			p.SetErr(er.ErrInvalidAttribute)
+			continue
+		}
+		c.RelName = p.StringAttr()
+	}
+	return c
+}
+
+func parseIndex(p *rtl.Reader, t *er.EntityType) *er.Index {
+	x := &er.Index{Owner: t}
+	for p.Next() {
+		switch p.Name() {
+		case "name":
+			x.Name = p.StringAttr()
+		case "attribute":
+			x.AttributeNames = append(x.AttributeNames, p.StringAttr())
+		case "unique":
+			x.Unique = p.BoolAttr()
+		default:
+			parseDoc(p, &x.Doc)
+		}
+	}
+	return x
+}
+
+// resolve links up the supertypes, types, relationships, keys, enumerations
+// and derived attributes referred to by name, and checks attributes' defaults
+// once their enumerations are known. Diagonal paths start at a
+// relationship's source and risers at its target, and derived attributes'
+// paths at their owner.
+func resolve(m *er.EntityModel) error {
+	types := map[string]*er.EntityType{}
+	for _, t := range m.Types {
+		types[t.Name] = t
+	}
+	enums := map[string]*er.Enum{}
+	for _, e := range m.Enums {
+		enums[e.Name] = e
+	}
+	for _, t := range m.Types {
+		for _, a := range t.Attributes {
+			if a.EnumName != "" || a.Type == er.EnumType {
+				a.Enum = enums[a.EnumName]
+				if a.Enum == nil || a.Type != er.EnumType {
+					return er.ErrInvalidAttribute
+				}
+			}
+			if _, err := rtl.ParseDefault(a); err != nil {
+				return err
+			}
+		}
+		for _, r := range t.Relationships {
+			r.Target = types[r.TargetName]
+			if r.Target == nil {
+				return er.ErrInvalidRecord
+			}
+			if r.KeyName == "" {
+				continue
+			}
+			r.Key = findIndex(r.Target, r.KeyName)
+			if r.Key == nil || !r.Key.Unique {
+				return er.ErrInvalidAttribute
+			}
+		}
+		if t.SupertypeName != "" {
+			t.Supertype = types[t.SupertypeName]
+			if t.Supertype == nil {
+				return er.ErrInvalidRecord
+			}
+		}
+		if t.DependsOnName != "" {
+			t.DependsOn = findRel(t, t.DependsOnName)
+			if t.DependsOn == nil {
+				return er.ErrInvalidAttribute
+			}
+		}
+	}
+	for _, t := range m.Types {
+		for _, r := range t.Relationships {
+			for _, c := range r.Constraints {
+				if err := resolvePath(r.Source, c.Diagonal.Components); err != nil {
+					return err
+				}
+				if err := resolvePath(r.Target, c.Riser.Components); err != nil {
+					return err
+				}
+			}
+		}
+		for _, d := range t.Derived {
+			if err := resolveDerived(types, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveDerived finds the attribute a derived attribute takes its value
+// from, or the relationship whose sources it counts, at the end of its path.
+func resolveDerived(types map[string]*er.EntityType, d *er.DerivedAttribute) error {
+	if err := resolvePath(d.Owner, d.Path); err != nil {
+		return err
+	}
+	end := d.End()
+	switch {
+	case d.CountTypeName != "" && d.AttributeName == "":
+		if src := types[d.CountTypeName]; src != nil {
+			d.Count = findRel(src, d.CountRelName)
+		}
+		if d.Count == nil || d.Count.Target != end {
+			return er.ErrInvalidAttribute
+		}
+	case d.CountTypeName == "":
+		for _, a := range end.Attributes {
+			if a.Name == d.AttributeName {
+				d.Attribute = a
+			}
+		}
+		if d.Attribute == nil {
+			return er.ErrInvalidAttribute
+		}
+	default:
+		return er.ErrInvalidAttribute
+	}
+	return nil
+}
+
+func resolvePath(t *er.EntityType, path []er.Component) error {
+	for i, c := range path {
+		path[i].Rel = findRel(t, c.RelName)
+		if path[i].Rel == nil {
+			return er.ErrInvalidAttribute
+		}
+		t = path[i].Rel.Target
+	}
+	return nil
+}
+
+func findIndex(t *er.EntityType, name string) *er.Index {
+	for _, x := range t.Indexes {
+		if x.Name == name {
+			return x
+		}
+	}
+	return nil
+}
+
+func findRel(t *er.EntityType, name string) *er.Relationship {
+	for _, r := range t.Relationships {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
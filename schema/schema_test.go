@@ -0,0 +1,203 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bobappleyard/er"
+)
+
+const square = `
+name: "square"
+description: "Shapes with four sides."
+type {
+	name: "a"
+	annotation { key: "table" value: "things" }
+	annotation { key: "owner" value: "me" }
+	attribute { name: "name" type: "string" identifying: true description: "What a is called." }
+	derived { name: "children" count: "c" via: "parent" }
+	relationship { name: "s" type_name: "b" description: "The b of a." }
+	relationship { name: "t" type_name: "b" key: "by_code" }
+}
+type {
+	name: "b"
+	attribute { name: "name" type: "string" identifying: true }
+	attribute { name: "code" type: "string" }
+	index { name: "by_code" attribute: "code" unique: true }
+}
+type {
+	name: "c"
+	attribute { name: "name" type: "string" identifying: true }
+	attribute { name: "size" type: "int" identifying: false min: 0 max: 100 }
+	attribute { name: "state" type: "enum" enum: "state" default: "off" }
+	attribute { name: "note" type: "string" optional: true max_length: 20 non_empty: true pattern: "[a-z ]+" }
+	relationship { name: "parent" type_name: "a" }
+	relationship {
+		name: "f"
+		type_name: "d"
+		constraint {
+			diagonal {
+				component { rel_name: "parent" }
+				component { rel_name: "s" }
+			}
+			riser {
+				component { rel_name: "parent" }
+			}
+		}
+	}
+	index { name: "by_size" attribute: "size" }
+	derived {
+		name: "s_code"
+		component { rel_name: "parent" }
+		component { rel_name: "s" }
+		attribute: "code"
+	}
+	depends_on: "parent"
+}
+type {
+	name: "d"
+	attribute { name: "name" type: "string" identifying: true }
+	relationship { name: "parent" type_name: "b" identifying: true }
+	depends_on: "parent"
+}
+enum { name: "state" value: "on" value: "off" description: "Whether c is on." }
+`
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(square))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "square" || len(m.Types) != 4 {
+		t.Fatalf("got model %q with %d types", m.Name, len(m.Types))
+	}
+	a, b, c, d := m.Types[0], m.Types[1], m.Types[2], m.Types[3]
+	if a.Relationships[0].Target != b || a.Relationships[0].Source != a {
+		t.Errorf("a.s not resolved: %v", a.Relationships[0])
+	}
+	if r := a.Relationships[1]; r.Key != b.Indexes[0] || !r.Key.Unique {
+		t.Errorf("a.t key not resolved: %v", r.Key)
+	}
+	if size := c.Attributes[1]; size.Type != er.IntType || size.Identifying || size.Owner != c {
+		t.Errorf("got size attribute %v", size)
+	}
+	if state := c.Attributes[2]; state.Enum != m.Enums[0] || len(m.Enums[0].Values) != 2 {
+		t.Errorf("got state attribute %v", state)
+	}
+	if state := c.Attributes[2]; state.Default != "off" || c.Attributes[1].Default != "" {
+		t.Errorf("got default %q", state.Default)
+	}
+	if !c.Attributes[3].Optional || c.Attributes[0].Optional {
+		t.Errorf("got optional %t and %t", c.Attributes[3].Optional, c.Attributes[0].Optional)
+	}
+	if size, note := c.Attributes[1], c.Attributes[3]; *size.Min != 0 || *size.Max != 100 ||
+		note.MaxLength != 20 || !note.NonEmpty || note.Pattern != "[a-z ]+" {
+		t.Errorf("got rules %v and %v", size, note)
+	}
+	if m.Description != "Shapes with four sides." || a.Attributes[0].Description != "What a is called." ||
+		a.Relationships[0].Description != "The b of a." || m.Enums[0].Description != "Whether c is on." {
+		t.Errorf("descriptions not parsed")
+	}
+	if v, ok := a.Annotation("owner"); !ok || v != "me" || len(a.Annotations) != 2 {
+		t.Errorf("got annotations %v", a.Annotations)
+	}
+	if _, ok := b.Annotation("owner"); ok {
+		t.Errorf("found annotation on b")
+	}
+	if c.DependsOn != c.Relationships[0] || d.DependsOn != d.Relationships[0] {
+		t.Errorf("dependencies not resolved")
+	}
+	if !d.Relationships[0].Identifying {
+		t.Errorf("d.parent should be identifying")
+	}
+	con := c.Relationships[1].Constraints[0]
+	if con.Diagonal.Components[0].Rel != c.Relationships[0] ||
+		con.Diagonal.Components[1].Rel != a.Relationships[0] ||
+		con.Riser.Components[0].Rel != d.Relationships[0] {
+		t.Errorf("constraint paths not resolved")
+	}
+	if d := c.Derived[0]; d.Attribute != b.Attributes[1] || d.Path[1].Rel != a.Relationships[0] || d.Owner != c {
+		t.Errorf("got derived attribute %v", d)
+	}
+	if d := a.Derived[0]; d.Count != c.Relationships[0] || d.Attribute != nil {
+		t.Errorf("got derived count %v", d)
+	}
+	if x := c.Indexes[0]; x.Name != "by_size" || len(x.AttributeNames) != 1 || x.AttributeNames[0] != "size" {
+		t.Errorf("got index %v", x.AttributeNames)
+	}
+}
+
+func TestParseTypeOptions(t *testing.T) {
+	m, err := Parse([]byte(`
+		type {
+			name: "payment"
+			attribute { name: "id" type: "int" identifying: true }
+			subtyping { exclusive: true mapping: "single_table" }
+		}
+		type { name: "card" supertype: "payment" }
+		type { name: "cash" supertype: "payment" }
+		type { name: "receipt" surrogate: "uuid4" }
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, card, cash := m.Types[0], m.Types[1], m.Types[2]
+	if card.Supertype != p || cash.Supertype != p || p.Supertype != nil {
+		t.Errorf("supertypes not resolved")
+	}
+	if r := m.Types[3]; r.Surrogate != er.UUID4Key || p.Surrogate != er.NoKey {
+		t.Errorf("got surrogate keys %v and %v", r.Surrogate, p.Surrogate)
+	}
+	if s := p.Subtyping; !s.Exclusive || s.Total || s.Mapping != er.SingleTable {
+		t.Errorf("got subtyping %v", s)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		name, src string
+		err       error
+	}{
+		{"Syntax", `type { name "a" }`, er.ErrBadSyntax},
+		{"Record", `table { name: "a" }`, er.ErrInvalidRecord},
+		{"Field", `type { colour: "red" }`, er.ErrInvalidAttribute},
+		{"AttributeType", `type { attribute { name: "x" type: "colour" } }`, er.ErrInvalidAttribute},
+		{"Collation", `type { attribute { name: "x" type: "string" collation: "no such" } }`, er.ErrInvalidAttribute},
+		{"Enum", `type { attribute { name: "x" type: "enum" enum: "colour" } }`, er.ErrInvalidAttribute},
+		{"EnumType", `type { attribute { name: "x" type: "int" enum: "e" } } enum { name: "e" }`, er.ErrInvalidAttribute},
+		{"OptionalKey", `type { attribute { name: "x" type: "int" identifying: true optional: true } }`, er.ErrInvalidAttribute},
+		{"Bounds", `type { attribute { name: "x" type: "bool" min: 1 } }`, er.ErrInvalidAttribute},
+		{"IntBound", `type { attribute { name: "x" type: "int" max: 0.5 } }`, er.ErrInvalidAttribute},
+		{"Pattern", `type { attribute { name: "x" type: "string" pattern: "(" } }`, er.ErrInvalidAttribute},
+		{"MaxLength", `type { attribute { name: "x" type: "int" max_length: 3 } }`, er.ErrInvalidAttribute},
+		{"Default", `type { attribute { name: "x" type: "int" default: "none" } }`, er.ErrInvalidAttribute},
+		{"EnumDefault", `type { attribute { name: "x" type: "enum" enum: "e" default: "c" } } enum { name: "e" value: "a" }`, er.ErrInvalidAttribute},
+		{"Target", `type { name: "a" relationship { name: "r" type_name: "b" } }`, er.ErrInvalidRecord},
+		{"DependsOn", `type { name: "a" depends_on: "r" }`, er.ErrInvalidAttribute},
+		{"Key", `type { name: "a" relationship { name: "r" type_name: "a" key: "k" } }`, er.ErrInvalidAttribute},
+		{"UniqueKey", `type {
+			name: "a"
+			index { name: "k" attribute: "name" }
+			relationship { name: "r" type_name: "a" key: "k" }
+		}`, er.ErrInvalidAttribute},
+		{"Path", `type {
+			name: "a"
+			relationship {
+				name: "r"
+				type_name: "a"
+				constraint { diagonal { component { rel_name: "q" } } }
+			}
+		}`, er.ErrInvalidAttribute},
+		{"Supertype", `type { name: "a" supertype: "b" }`, er.ErrInvalidRecord},
+		{"Mapping", `type { name: "a" subtyping { mapping: "shared" } }`, er.ErrInvalidAttribute},
+		{"Surrogate", `type { name: "a" surrogate: "random" }`, er.ErrInvalidAttribute},
+		{"Annotation", `type { name: "a" annotation { name: "x" } }`, er.ErrInvalidAttribute},
+		{"Derived", `type { name: "a" derived { name: "x" attribute: "y" } }`, er.ErrInvalidAttribute},
+		{"Count", `type { name: "a" derived { name: "x" count: "a" via: "r" } }`, er.ErrInvalidAttribute},
+	} {
+		_, err := Parse([]byte(test.src))
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, expecting %v", test.name, err, test.err)
+		}
+	}
+}
@@ -7,24 +7,203 @@ import (
 
 // EntityModel represents a collection of entity types and how they relate.
 type EntityModel struct {
-	Name  string
-	Types []*EntityType
+	Name  string        `rsf:"name"`
+	Types []*EntityType `rsf:"type"`
+	Enums []*Enum       `rsf:"enum"`
+	Doc
 }
 
-// EntityType represents an entity type.
+// Doc documents an element of a model. Each kind of element embeds it, and
+// LogicalToPhysical keeps it with the element. Annotations hold whatever else
+// tools want to say about the element.
+type Doc struct {
+	Description string       `rsf:"description"`
+	Annotations []Annotation `rsf:"annotation"`
+}
+
+// Annotation is a key/value pair attached to a model element.
+type Annotation struct {
+	Key   string `rsf:"key"`
+	Value string `rsf:"value"`
+}
+
+// Annotation finds the value of the last annotation with the given key.
+func (d *Doc) Annotation(key string) (string, bool) {
+	for i := len(d.Annotations) - 1; i >= 0; i-- {
+		if d.Annotations[i].Key == key {
+			return d.Annotations[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Enum represents a named, closed set of values that enum attributes may
+// take. Values are ordered as they are declared.
+type Enum struct {
+	Name   string   `rsf:"name"`
+	Values []string `rsf:"value"`
+	Doc
+}
+
+// EntityType represents an entity type. Subtypes name their supertype, whose
+// Subtyping says how its subtypes divide its entities. LogicalToPhysical fills
+// in Subtypes. Types without identifying attributes may ask for a Surrogate
+// key, which LogicalToPhysical adds as an attribute called "id". Derived
+// attributes are computed from the model rather than stored.
 type EntityType struct {
-	Name          string          `rsf:"name"`
-	Attributes    []*Attribute    `rsf:"attribute"`
-	Relationships []*Relationship `rsf:"relationship"`
+	Name          string              `rsf:"name"`
+	Attributes    []*Attribute        `rsf:"attribute"`
+	Derived       []*DerivedAttribute `rsf:"derived"`
+	Relationships []*Relationship     `rsf:"relationship"`
+	Indexes       []*Index            `rsf:"index"`
+	DependsOnName string              `rsf:"depends_on"`
+	SupertypeName string              `rsf:"supertype"`
+	Subtyping     Subtyping           `rsf:"subtyping"`
+	Surrogate     KeyGenerator        `rsf:"surrogate"`
 	DependsOn     *Relationship
+	Supertype     *EntityType
+	Subtypes      []*EntityType
+	Doc
+}
+
+// Subtyping describes the subtypes of an entity type. In exclusive hierarchies
+// an entity has at most one subtype, and in total hierarchies at least one.
+type Subtyping struct {
+	Exclusive bool           `rsf:"exclusive"`
+	Total     bool           `rsf:"total"`
+	Mapping   SubtypeMapping `rsf:"mapping"`
 }
 
-// Attribute represents an attribute.
+// SubtypeMapping says how LogicalToPhysical implements a hierarchy.
+type SubtypeMapping byte
+
+// Supported subtype mappings.
+//
+// SharedKey keeps a type per subtype, with an identifying relationship to the
+// supertype named after it.
+//
+// SingleTable folds the subtypes into the supertype. Their attributes become
+// optional and lose their defaults, and the subtype is recorded in an enum
+// attribute called "subtype" for exclusive hierarchies, or in bool attributes
+// called "is_" followed by the subtype's name otherwise. Relationships to the
+// subtypes lead to the supertype instead.
+//
+// ConcreteTypes copies the supertype's attributes and relationships into each
+// subtype and removes the supertype, which no relationship may then lead to.
+const (
+	SharedKey SubtypeMapping = iota
+	SingleTable
+	ConcreteTypes
+)
+
+var subtypeMappingNames = []string{
+	SharedKey:     "shared_key",
+	SingleTable:   "single_table",
+	ConcreteTypes: "concrete_types",
+}
+
+func (m SubtypeMapping) String() string {
+	if int(m) < len(subtypeMappingNames) {
+		return subtypeMappingNames[m]
+	}
+	return "invalid"
+}
+
+// ParseSubtypeMapping finds the mapping with the given name.
+func ParseSubtypeMapping(name string) (SubtypeMapping, bool) {
+	for m, n := range subtypeMappingNames {
+		if n == name {
+			return SubtypeMapping(m), true
+		}
+	}
+	return 0, false
+}
+
+// SupertypeRelationship finds the relationship that LogicalToPhysical adds to
+// subtypes in a SharedKey hierarchy, or nil if there is none.
+func (t *EntityType) SupertypeRelationship() *Relationship {
+	if t.Supertype == nil || t.Supertype.Subtyping.Mapping != SharedKey {
+		return nil
+	}
+	for _, r := range t.Relationships {
+		if r.Name == t.Supertype.Name && r.Target == t.Supertype && r.Identifying {
+			return r
+		}
+	}
+	return nil
+}
+
+// KeyGenerator says how the values of a surrogate key are made.
+type KeyGenerator byte
+
+// Supported key generators. SerialKey counts up from 1 and UUID4Key and
+// UUID7Key make UUIDs, the latter ordered by time.
+const (
+	NoKey KeyGenerator = iota
+	SerialKey
+	UUID4Key
+	UUID7Key
+)
+
+var keyGeneratorNames = []string{
+	NoKey:     "none",
+	SerialKey: "serial",
+	UUID4Key:  "uuid4",
+	UUID7Key:  "uuid7",
+}
+
+func (g KeyGenerator) String() string {
+	if int(g) < len(keyGeneratorNames) {
+		return keyGeneratorNames[g]
+	}
+	return "invalid"
+}
+
+// ParseKeyGenerator finds the key generator with the given name.
+func ParseKeyGenerator(name string) (KeyGenerator, bool) {
+	for g, n := range keyGeneratorNames {
+		if n == name {
+			return KeyGenerator(g), true
+		}
+	}
+	return 0, false
+}
+
+// Attribute represents an attribute. Collation names the order of string
+// attributes, as understood by rtl.NewCollation, with the empty string
+// meaning byte order. Attributes of EnumType name their enumeration. Optional
+// attributes may hold no value, which is distinct from the zero value; they
+// cannot be identifying. Generated is set on surrogate keys, whose zero value
+// is replaced with a new key when an entity is inserted.
+//
+// Default is the value given to the attribute when a record or a new entity
+// leaves it out, written as in the record format without quotes: 12, 1.5,
+// true, 2006-01-02, 2006-01-02T15:04:05Z, 0x00ff, an enumeration value or any
+// string. The generators now() and sequence() stand for the current time or
+// date and for one more than the largest value so far of an int attribute.
+//
+// The remaining fields are rules that values must follow. Min and Max bound int
+// and float attributes. Pattern is a regular expression that string attributes
+// must match in full. MaxLength and NonEmpty limit the length of string
+// attributes, in characters, and bytes attributes.
 type Attribute struct {
 	Name        string        `rsf:"name"`
 	Type        AttributeType `rsf:"type"`
 	Identifying bool          `rsf:"identifying"`
+	Optional    bool          `rsf:"optional"`
+	Collation   string        `rsf:"collation"`
+	EnumName    string        `rsf:"enum"`
+	Default     string        `rsf:"default"`
+	Enum        *Enum
 	Owner       *EntityType
+	Generated   KeyGenerator
+
+	Min       *float64 `rsf:"min"`
+	Max       *float64 `rsf:"max"`
+	Pattern   string   `rsf:"pattern"`
+	MaxLength int      `rsf:"max_length"`
+	NonEmpty  bool     `rsf:"non_empty"`
+	Doc
 }
 
 // AttributeType represents an attribute type.
@@ -36,16 +215,116 @@ const (
 	StringType
 	IntType
 	FloatType
+	BoolType
+	TimeType
+	DateType
+	BytesType
+	DecimalType
+	EnumType
 )
 
-// Relationship represents a relationship.
+var attributeTypeNames = []string{
+	InvalidType: "invalid",
+	StringType:  "string",
+	IntType:     "int",
+	FloatType:   "float",
+	BoolType:    "bool",
+	TimeType:    "time",
+	DateType:    "date",
+	BytesType:   "bytes",
+	DecimalType: "decimal",
+	EnumType:    "enum",
+}
+
+func (t AttributeType) String() string {
+	if int(t) < len(attributeTypeNames) {
+		return attributeTypeNames[t]
+	}
+	return attributeTypeNames[InvalidType]
+}
+
+// ParseAttributeType finds the attribute type with the given name, returning
+// InvalidType if there is none.
+func ParseAttributeType(name string) AttributeType {
+	for t, n := range attributeTypeNames {
+		if n == name {
+			return AttributeType(t)
+		}
+	}
+	return InvalidType
+}
+
+// Index finds the position of a value in the enumeration, returning -1 if it
+// is not one of the values.
+func (e *Enum) Index(val string) int {
+	for i, v := range e.Values {
+		if v == val {
+			return i
+		}
+	}
+	return -1
+}
+
+// DerivedAttribute represents an attribute whose value is computed rather
+// than stored. Path leads from the owner to another entity. The value is that
+// entity's attribute called AttributeName or, given CountTypeName, the number
+// of entities of that type whose relationship called CountRelName leads to
+// it.
+type DerivedAttribute struct {
+	Name          string      `rsf:"name"`
+	Path          []Component `rsf:"component"`
+	AttributeName string      `rsf:"attribute"`
+	CountTypeName string      `rsf:"count"`
+	CountRelName  string      `rsf:"via"`
+	Owner         *EntityType
+	Attribute     *Attribute
+	Count         *Relationship
+	Doc
+}
+
+// End finds the type that the derived attribute's path leads to.
+func (d *DerivedAttribute) End() *EntityType {
+	if len(d.Path) == 0 {
+		return d.Owner
+	}
+	return d.Path[len(d.Path)-1].Rel.Target
+}
+
+// Value describes the derived attribute's values as if it were an attribute
+// of its owner. Counts are ints, and other values are as the attribute they
+// come from, though never identifying.
+func (d *DerivedAttribute) Value() *Attribute {
+	if d.Count != nil {
+		return &Attribute{Owner: d.Owner, Name: d.Name, Type: IntType, Doc: d.Doc}
+	}
+	return &Attribute{
+		Owner:     d.Owner,
+		Name:      d.Name,
+		Type:      d.Attribute.Type,
+		Optional:  d.Attribute.Optional,
+		Collation: d.Attribute.Collation,
+		EnumName:  d.Attribute.EnumName,
+		Enum:      d.Attribute.Enum,
+		Doc:       d.Doc,
+	}
+}
+
+func (d *DerivedAttribute) String() string {
+	return fmt.Sprintf("%s.%s", d.Owner.Name, d.Name)
+}
+
+// Relationship represents a relationship. A relationship leads to the target's
+// identifying attributes, unless Key is one of the target's unique indexes.
 type Relationship struct {
 	Name           string           `rsf:"name"`
 	TargetName     string           `rsf:"type_name"`
 	Constraints    []Constraint     `rsf:"constraint"`
 	Identifying    bool             `rsf:"identifying"`
+	KeyName        string           `rsf:"key"`
 	Implementation []Implementation `rsf:"implementation"`
 	Source, Target *EntityType
+	Key            *Index
+	Doc
 }
 
 // Constraint represnts a constraint over a relationship.
@@ -68,6 +347,19 @@ type Component struct {
 	Rel     *Relationship
 }
 
+// Index represents a secondary index over some of an entity type's
+// attributes, allowing entities to be found by them without a full scan.
+// Unique indexes are alternate keys: no two entities may share values for all
+// of their attributes.
+type Index struct {
+	Name           string   `rsf:"name"`
+	AttributeNames []string `rsf:"attribute"`
+	Unique         bool     `rsf:"unique"`
+	Attributes     []*Attribute
+	Owner          *EntityType
+	Doc
+}
+
 // Implementation represents part of an attribute filter.
 type Implementation struct {
 	Source, Target *Attribute
@@ -86,6 +378,10 @@ func (a *Relationship) String() string {
 	return fmt.Sprintf("%s.%s", a.Source.Name, a.Name)
 }
 
+func (x *Index) String() string {
+	return fmt.Sprintf("%s.%s", x.Owner.Name, x.Name)
+}
+
 // ErrInvalidAttribute ...
 var (
 	ErrInvalidAttribute = errors.New("invalid attribute")
@@ -95,4 +391,5 @@ var (
 	ErrMissingEntity    = errors.New("entity not found by key")
 	ErrImmutableSet     = errors.New("attempting to modify immutable set")
 	ErrBadSyntax        = errors.New("syntax error")
+	ErrBrokenRule       = errors.New("attribute rule broken")
 )